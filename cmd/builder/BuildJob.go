@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"github.com/develar/app-builder/pkg/util"
 	"io"
@@ -18,16 +20,37 @@ import (
 	"github.com/develar/errors"
 	"github.com/develar/go-fs-util"
 	"github.com/electronuserland/electron-build-service/internal"
+	"github.com/electronuserland/electron-build-service/internal/tracing"
 	"github.com/json-iterator/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
 type BuildJob struct {
 	id string
+	// secret to authorize job control requests (e.g. cancel), sent only to the client that requested the build
+	token string
 
 	projectDir   string
 	queueAddTime time.Time
 
+	clientIp string
+	// identity of client certificate if mutual TLS is enabled, client IP otherwise
+	tenant string
+	// compressed size of uploaded project
+	uploadSize *atomic.Int64
+	// declared upload size (x-expected-size) or max request body if not declared, upload is rejected if exceeds
+	maxUploadSize int64
+	// sha256 of uploaded project archive (as uploaded, i.e. compressed), hex-encoded
+	inputDigest string
+	// last lines of builder output
+	output *outputTail
+
+	// releases disk space reserved for the job, must be called after project dir is removed
+	releaseDiskSpace func()
+
 	buildRequest    *BuildRequest
 	rawBuildRequest *string
 
@@ -39,6 +62,25 @@ type BuildJob struct {
 	// error - only internal error, not from electron-builder
 	complete chan BuildJobResult
 
+	// unix time in nanoseconds when job was taken from the queue by worker, 0 if not yet started
+	startTime *atomic.Int64
+	// unix time in nanoseconds when build is completed (successfully or not), 0 if not yet completed
+	completeTime *atomic.Int64
+
+	// cancels job context (parent is a client request context)
+	cancel      context.CancelFunc
+	isCancelled *atomic.Bool
+
+	// span of the build request (job is executed in a worker goroutine with own context)
+	spanContext trace.SpanContext
+	queueSpan   trace.Span
+
+	// set by handler, written to the build history when request handling is finished
+	uploadDuration time.Duration
+	outcome        string
+	errorClass     string
+	fileSizes      []int64
+
 	logger *zap.Logger
 }
 
@@ -46,6 +88,11 @@ type BuildJobResult struct {
 	error     error
 	rawResult string
 	fileSizes []int64
+
+	// artifact file names, digests are computed only if provenance is enabled
+	files         []string
+	fileDigests   []string
+	hasProvenance bool
 }
 
 const outDirName = "out"
@@ -54,13 +101,38 @@ func (t *BuildJob) String() string {
 	return t.id
 }
 
+// Key is used by fair scheduling policy to schedule jobs fairly across tenants.
+func (t *BuildJob) Key() string {
+	return t.tenant
+}
+
+// Cancel returns false if job is already cancelled.
+func (t *BuildJob) Cancel() bool {
+	if !t.isCancelled.CAS(false, true) {
+		return false
+	}
+
+	t.cancel()
+	return true
+}
+
 func (t *BuildJob) Run(ctx context.Context) {
 	jobStartTime := time.Now()
+	t.startTime.Store(jobStartTime.UnixNano())
+	t.queueSpan.End()
+	t.handler.publishJob(t)
+
+	ctx = trace.ContextWithSpanContext(ctx, t.spanContext)
+	ctx, span := tracing.Tracer().Start(ctx, "run")
+	defer span.End()
+
 	waitTime := jobStartTime.Sub(t.queueAddTime)
 	t.logger.Info("job started", zap.Duration("waitTime", waitTime))
-	t.messages <- fmt.Sprintf("job started (queue time: %s)", waitTime.Round(time.Millisecond))
+	t.sendMessage(ctx, fmt.Sprintf("job started (queue time: %s)", waitTime.Round(time.Millisecond)))
 
 	err := t.doBuild(ctx, jobStartTime)
+	t.completeTime.Store(time.Now().UnixNano())
+	t.handler.publishJob(t)
 
 	if ctx.Err() != nil {
 		close(t.complete)
@@ -68,16 +140,32 @@ func (t *BuildJob) Run(ctx context.Context) {
 	}
 
 	if err != nil {
-		t.complete <- BuildJobResult{error: err}
+		span.RecordError(err)
+		t.sendResult(ctx, BuildJobResult{error: err})
 		close(t.complete)
 	}
 }
 
+// handler doesn't read messages after job is cancelled or client closed connection, so, do not block on send
+func (t *BuildJob) sendMessage(ctx context.Context, message string) {
+	select {
+	case t.messages <- message:
+	case <-ctx.Done():
+	}
+}
+
+func (t *BuildJob) sendResult(ctx context.Context, result BuildJobResult) {
+	select {
+	case t.complete <- result:
+	case <-ctx.Done():
+	}
+}
+
 func (t *BuildJob) doBuild(buildContext context.Context, jobStartTime time.Time) error {
 	defer func() {
 		r := recover()
 		if r != nil {
-			t.complete <- BuildJobResult{error: errors.Errorf("recovered %v", r)}
+			t.sendResult(buildContext, BuildJobResult{error: errors.Errorf("recovered %v", r)})
 		}
 	}()
 
@@ -115,7 +203,7 @@ func (t *BuildJob) doBuild(buildContext context.Context, jobStartTime time.Time)
 	)
 	command.Dir = t.projectDir
 
-	err = t.doExecute(command)
+	err = t.doExecute(buildContext, command)
 	if err != nil {
 		return err
 	}
@@ -130,24 +218,44 @@ func (t *BuildJob) doBuild(buildContext context.Context, jobStartTime time.Time)
 		return err
 	}
 
-	result, err := t.processResult(rawResult, projectOutDir)
+	result, err := t.processResult(buildContext, rawResult, projectOutDir)
 	if err != nil {
 		return err
 	}
 
+	provenanceSigner := t.handler.provenanceSigner
+	if provenanceSigner != nil && result.files != nil {
+		if indexOf(result.files, provenanceFileName) != -1 {
+			t.logger.Warn("provenance is not written", zap.String("reason", "artifact has reserved name "+provenanceFileName))
+		} else if err = provenanceSigner.writeProvenance(t, result, jobStartTime); err != nil {
+			t.logger.Error("cannot write provenance", zap.Error(err))
+		} else {
+			result.hasProvenance = true
+		}
+	}
+
 	info, err := ioutil.ReadFile(filepath.Join(t.projectDir, "info.json"))
 	if err != nil {
 		t.logger.Error("cannot write project info", zap.Error(err))
 	}
 
+	duration := time.Since(jobStartTime)
+	t.handler.buildDurationStats.Add(t.buildRequest.Targets, duration)
+
+	targets := make([]string, len(t.buildRequest.Targets))
+	for index, target := range t.buildRequest.Targets {
+		targets[index] = strings.ToLower(target.Name)
+	}
+
 	t.logger.Info("job completed",
-		zap.Duration("duration", time.Since(jobStartTime)),
+		zap.Duration("duration", duration),
+		zap.Strings("targets", targets),
 		zap.ByteString("result", rawResult),
 		zap.Int64s("fileSizes", result.fileSizes),
 		zap.ByteString("projectInfo", info),
 	)
 
-	t.complete <- *result
+	t.sendResult(buildContext, *result)
 	close(t.complete)
 
 	// on complete connection will be not closed immediately, but client will download results, so, no need to execute remote in a new goroutine
@@ -155,7 +263,12 @@ func (t *BuildJob) doBuild(buildContext context.Context, jobStartTime time.Time)
 	return nil
 }
 
-func (t *BuildJob) doExecute(command *exec.Cmd) error {
+func (t *BuildJob) doExecute(ctx context.Context, command *exec.Cmd) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "doExecute")
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
 	r, w := io.Pipe()
 	defer util.Close(r)
 	defer util.Close(w)
@@ -163,7 +276,7 @@ func (t *BuildJob) doExecute(command *exec.Cmd) error {
 	command.Stdout = w
 	command.Stderr = w
 
-	err := command.Start()
+	err = command.Start()
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -171,36 +284,47 @@ func (t *BuildJob) doExecute(command *exec.Cmd) error {
 	go func() {
 		outReader := bufio.NewReader(r)
 		var b bytes.Buffer
+		// last line without new line in the end
+		var partialLine string
 		for {
 			line, err := outReader.ReadString('\n')
 			if err != nil {
 				if err != io.EOF && err != io.ErrClosedPipe {
 					t.logger.Error("cannot read builder output", zap.Error(err))
 				}
+				partialLine = line
 				break
 			}
 
+			_, _ = t.output.Write([]byte(line))
+
 			// do not send status if some new lines are already available
 			if outReader.Buffered() > 0 {
 				b.WriteString(line)
 				continue
 			} else if b.Len() == 0 {
-				t.messages <- line
+				t.sendMessage(ctx, line)
 			} else {
 				b.WriteString(line)
-				t.messages <- b.String()
+				t.sendMessage(ctx, b.String())
 				b.Reset()
 			}
 		}
 
+		// buffered lines are already written to output tail, but not yet sent
+		tailSize := b.Len()
+		b.WriteString(partialLine)
 		// read rest (if no new line in the end)
 		_, err = outReader.WriteTo(&b)
 		if err != nil && err != io.EOF && err != io.ErrClosedPipe {
 			t.logger.Error("cannot read builder output", zap.Error(err))
 		}
 
+		if b.Len() > tailSize {
+			_, _ = t.output.Write(b.Bytes()[tailSize:])
+		}
 		if b.Len() > 0 {
-			t.messages <- b.String()
+			t.sendMessage(ctx, b.String())
 		}
 	}()
 
@@ -215,19 +339,32 @@ func (t *BuildJob) doExecute(command *exec.Cmd) error {
 	return nil
 }
 
-func (t *BuildJob) processResult(rawResult []byte, projectOutDir string) (*BuildJobResult, error) {
-	result := &BuildJobResult{
+func (t *BuildJob) processResult(ctx context.Context, rawResult []byte, projectOutDir string) (result *BuildJobResult, err error) {
+	_, span := tracing.Tracer().Start(ctx, "processResult")
+	defer func() {
+		if result != nil {
+			span.SetAttributes(attribute.Int64Slice("result.fileSizes", result.fileSizes))
+		}
+		tracing.EndSpan(span, err)
+	}()
+
+	result = &BuildJobResult{
 		rawResult: string(rawResult),
 	}
 
 	if len(rawResult) > 0 && rawResult[0] == '[' {
 		var partialArtifactInfo []PartialArtifactInfo
-		err := jsoniter.Unmarshal(rawResult, &partialArtifactInfo)
+		err = jsoniter.Unmarshal(rawResult, &partialArtifactInfo)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
 
-		result.fileSizes, err = t.computeFileSizes(partialArtifactInfo, projectOutDir)
+		result.files = make([]string, len(partialArtifactInfo))
+		for index, info := range partialArtifactInfo {
+			result.files[index] = info.File
+		}
+
+		result.fileSizes, result.fileDigests, err = t.computeFileInfo(result.files, projectOutDir, t.handler.provenanceSigner != nil)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
@@ -236,10 +373,16 @@ func (t *BuildJob) processResult(rawResult []byte, projectOutDir string) (*Build
 	return result, nil
 }
 
-func (t *BuildJob) computeFileSizes(partialArtifactInfo []PartialArtifactInfo, projectOutDir string) ([]int64, error) {
-	fileSizes := make([]int64, len(partialArtifactInfo))
-	err := internal.MapAsync(len(partialArtifactInfo), t.logger, func(taskIndex int) (func() error, error) {
-		file := filepath.Join(projectOutDir, partialArtifactInfo[taskIndex].File)
+// computeFileInfo computes file sizes and, if isDigestRequired, sha256 digests
+func (t *BuildJob) computeFileInfo(files []string, projectOutDir string, isDigestRequired bool) ([]int64, []string, error) {
+	fileSizes := make([]int64, len(files))
+	var fileDigests []string
+	if isDigestRequired {
+		fileDigests = make([]string, len(files))
+	}
+
+	err := internal.MapAsync(len(files), t.logger, func(taskIndex int) (func() error, error) {
+		file := filepath.Join(projectOutDir, files[taskIndex])
 		return func() error {
 			info, err := os.Stat(file)
 			if err != nil {
@@ -247,10 +390,26 @@ func (t *BuildJob) computeFileSizes(partialArtifactInfo []PartialArtifactInfo, p
 			}
 
 			fileSizes[taskIndex] = info.Size()
+
+			if isDigestRequired {
+				fileDigests[taskIndex], err = computeFileDigest(file)
+				if err != nil {
+					return err
+				}
+			}
 			return nil
 		}, nil
 	})
-	return fileSizes, err
+	return fileSizes, fileDigests, err
+}
+
+func generateJobToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return hex.EncodeToString(b), nil
 }
 
 type PartialArtifactInfo struct {
@@ -268,7 +427,7 @@ func (t *BuildJob) removeAllFilesExceptArtifacts(projectTempDir string) {
 	}
 
 	for _, file := range files {
-		if file == outDirName {
+		if file == outDirName || file == provenanceFileName {
 			continue
 		}
 
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/develar/errors"
+	"github.com/electronuserland/electron-build-service/internal/config"
+	"go.uber.org/zap"
+)
+
+// each resolver must be fast, otherwise agent start is delayed (e.g. metadata endpoint is not reachable outside of cloud)
+const addressResolverTimeout = 5 * time.Second
+
+const metadataIp = "169.254.169.254"
+
+// addressResolver returns external host of the agent, empty string if resolver is not applicable (e.g. interface is not configured)
+type addressResolver func(ctx context.Context, configuration *config.Config) (string, error)
+
+var addressResolvers = map[string]addressResolver{
+	"config":        resolveConfiguredAddress,
+	"interface":     resolveInterfaceAddress,
+	"kubernetes":    resolveKubernetesAddress,
+	"cloudMetadata": resolveCloudMetadataAddress,
+	"external":      resolveExternalAddress,
+}
+
+func getAgentKey(port string, configuration *config.Config, logger *zap.Logger) (string, error) {
+	host, err := resolveAgentAddress(configuration, logger)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// resolveAgentAddress tries configured resolvers in order, the first resolved address is used
+func resolveAgentAddress(configuration *config.Config, logger *zap.Logger) (string, error) {
+	return resolveAddress(configuration, addressResolvers, addressResolverTimeout, logger)
+}
+
+// resolveAddress tries resolvers in order of configuration.AddressResolvers, each resolver is limited by timeout
+func resolveAddress(configuration *config.Config, resolvers map[string]addressResolver, timeout time.Duration, logger *zap.Logger) (string, error) {
+	var failures []string
+	for _, name := range configuration.AddressResolvers {
+		resolver := resolvers[name]
+		if resolver == nil {
+			return "", fmt.Errorf("unknown address resolver: %s", name)
+		}
+
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		address, err := resolver(ctx, configuration)
+		cancel()
+
+		if err != nil {
+			logger.Warn("cannot resolve agent address", zap.String("source", name), zap.Duration("duration", time.Since(start)), zap.Error(err))
+			failures = append(failures, name+": "+err.Error())
+			continue
+		}
+
+		if address == "" {
+			logger.Debug("address resolver is not applicable", zap.String("source", name))
+			continue
+		}
+
+		logger.Info("agent address resolved", zap.String("source", name), zap.String("address", address), zap.Duration("duration", time.Since(start)))
+		return address, nil
+	}
+
+	if len(failures) == 0 {
+		return "", fmt.Errorf("cannot resolve agent address: no applicable resolver (tried %s)", strings.Join(configuration.AddressResolvers, ", "))
+	}
+	return "", fmt.Errorf("cannot resolve agent address: %s", strings.Join(failures, "; "))
+}
+
+func resolveConfiguredAddress(_ context.Context, configuration *config.Config) (string, error) {
+	return configuration.Host, nil
+}
+
+func resolveInterfaceAddress(_ context.Context, configuration *config.Config) (string, error) {
+	if configuration.HostInterface == "" {
+		return "", nil
+	}
+
+	networkInterface, err := net.InterfaceByName(configuration.HostInterface)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	addresses, err := networkInterface.Addrs()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	var ips []net.IP
+	for _, address := range addresses {
+		if ipNet, ok := address.(*net.IPNet); ok {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+
+	ip := selectIp(ips, configuration.PreferredIpVersion)
+	if ip == nil {
+		return "", fmt.Errorf("interface %s has no usable address", configuration.HostInterface)
+	}
+	return ip.String(), nil
+}
+
+// selectIp returns the first global unicast IP of preferred version (IPv4 by default), IP of another version if there is no such IP
+func selectIp(ips []net.IP, preferredIpVersion string) net.IP {
+	var fallback net.IP
+	for _, ip := range ips {
+		if !ip.IsGlobalUnicast() {
+			continue
+		}
+
+		isIpV4 := ip.To4() != nil
+		if isIpV4 == (preferredIpVersion != "6") {
+			return ip
+		}
+		if fallback == nil {
+			fallback = ip
+		}
+	}
+	return fallback
+}
+
+// NODE_IP (status.hostIP, agent uses host port) or POD_IP (status.podIP) must be set using downward API
+func resolveKubernetesAddress(_ context.Context, _ *config.Config) (string, error) {
+	for _, name := range []string{"NODE_IP", "POD_IP"} {
+		value := strings.TrimSpace(os.Getenv(name))
+		if value == "" {
+			continue
+		}
+
+		if net.ParseIP(value) == nil {
+			return "", fmt.Errorf("env %s is not a valid IP: %s", name, value)
+		}
+		return value, nil
+	}
+	return "", nil
+}
+
+type metadataProvider struct {
+	name    string
+	url     string
+	headers map[string]string
+}
+
+// public IP (not private IP of the instance), because clients connect to the agent directly
+var metadataProviders = []metadataProvider{
+	{
+		name: "gcp",
+		url:  "http://metadata.google.internal/computeMetadata/v1/instance/network-interfaces/0/access-configs/0/external-ip",
+		headers: map[string]string{
+			"Metadata-Flavor": "Google",
+		},
+	},
+	{
+		name: "azure",
+		url:  "http://" + metadataIp + "/metadata/instance/network/interface/0/ipv4/ipAddress/0/publicIpAddress?api-version=2017-08-01&format=text",
+		headers: map[string]string{
+			"Metadata": "true",
+		},
+	},
+	{
+		name: "digitalOcean",
+		url:  "http://" + metadataIp + "/metadata/v1/interfaces/public/0/ipv4/address",
+	},
+}
+
+func resolveCloudMetadataAddress(ctx context.Context, _ *config.Config) (string, error) {
+	// AWS requires session token (IMDSv2)
+	token, err := getAwsMetadataToken(ctx)
+	if err == nil {
+		address, err := getMetadataValue(ctx, metadataProvider{
+			name:    "aws",
+			url:     "http://" + metadataIp + "/latest/meta-data/public-ipv4",
+			headers: map[string]string{"X-aws-ec2-metadata-token": token},
+		})
+		if err == nil {
+			return address, nil
+		}
+	}
+
+	if ctx.Err() != nil {
+		return "", errors.WithStack(ctx.Err())
+	}
+
+	var failures []string
+	for _, provider := range metadataProviders {
+		address, err := getMetadataValue(ctx, provider)
+		if err == nil {
+			return address, nil
+		}
+
+		failures = append(failures, provider.name+": "+err.Error())
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return "", fmt.Errorf("no metadata endpoint is available (%s)", strings.Join(failures, "; "))
+}
+
+func getAwsMetadataToken(ctx context.Context) (string, error) {
+	request, err := http.NewRequest(http.MethodPut, "http://"+metadataIp+"/latest/api/token", nil)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	request.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	return doAddressRequest(ctx, request, false)
+}
+
+func getMetadataValue(ctx context.Context, provider metadataProvider) (string, error) {
+	request, err := http.NewRequest(http.MethodGet, provider.url, nil)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	for name, value := range provider.headers {
+		request.Header.Set(name, value)
+	}
+	return doAddressRequest(ctx, request, true)
+}
+
+func resolveExternalAddress(ctx context.Context, configuration *config.Config) (string, error) {
+	ipType := ""
+	if configuration.PreferredIpVersion != "" {
+		ipType = "ipv" + configuration.PreferredIpVersion + "."
+	}
+
+	//noinspection SpellCheckingInspection
+	request, err := http.NewRequest(http.MethodGet, "https://"+ipType+"myexternalip.com/raw", nil)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return doAddressRequest(ctx, request, true)
+}
+
+// doAddressRequest returns trimmed response text, if isIpExpected, response must be a valid IP
+func doAddressRequest(ctx context.Context, request *http.Request, isIpExpected bool) (string, error) {
+	response, err := http.DefaultClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer response.Body.Close()
+
+	// response is expected to be small, do not read a lot if some unrelated service responds
+	responseBytes, err := ioutil.ReadAll(io.LimitReader(response.Body, 4096))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	responseText := strings.TrimSpace(string(responseBytes))
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status: %d, response: %s", response.StatusCode, responseText)
+	}
+
+	if isIpExpected && net.ParseIP(responseText) == nil {
+		return "", fmt.Errorf("response is not a valid IP: %s", responseText)
+	}
+	return responseText, nil
+}
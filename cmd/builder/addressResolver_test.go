@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/electronuserland/electron-build-service/internal/config"
+	"go.uber.org/zap"
+)
+
+func parseIps(values ...string) []net.IP {
+	var result []net.IP
+	for _, value := range values {
+		result = append(result, net.ParseIP(value))
+	}
+	return result
+}
+
+func TestSelectIp(t *testing.T) {
+	testCases := []struct {
+		name               string
+		ips                []string
+		preferredIpVersion string
+		expected           string
+	}{
+		{"IPv4 by default", []string{"2001:db8::1", "192.0.2.1"}, "", "192.0.2.1"},
+		{"IPv4 is preferred", []string{"2001:db8::1", "192.0.2.1"}, "4", "192.0.2.1"},
+		{"IPv6 is preferred", []string{"192.0.2.1", "2001:db8::1"}, "6", "2001:db8::1"},
+		{"the first of preferred version", []string{"192.0.2.1", "192.0.2.2"}, "", "192.0.2.1"},
+		{"fallback to IPv6", []string{"2001:db8::1", "2001:db8::2"}, "4", "2001:db8::1"},
+		{"fallback to IPv4", []string{"192.0.2.1"}, "6", "192.0.2.1"},
+		{"loopback and link-local are skipped", []string{"127.0.0.1", "::1", "fe80::1", "169.254.1.1", "192.0.2.1"}, "6", "192.0.2.1"},
+		{"unspecified and multicast are skipped", []string{"0.0.0.0", "::", "224.0.0.1", "ff02::1"}, "", ""},
+		{"no addresses", nil, "", ""},
+	}
+
+	for _, testCase := range testCases {
+		ip := selectIp(parseIps(testCase.ips...), testCase.preferredIpVersion)
+		actual := ""
+		if ip != nil {
+			actual = ip.String()
+		}
+		if actual != testCase.expected {
+			t.Errorf("%s: expected %q, actual %q", testCase.name, testCase.expected, actual)
+		}
+	}
+}
+
+// stubResolvers records names of called resolvers
+type stubResolvers struct {
+	called []string
+}
+
+func (t *stubResolvers) stub(name string, address string, err error) addressResolver {
+	return func(ctx context.Context, configuration *config.Config) (string, error) {
+		t.called = append(t.called, name)
+		return address, err
+	}
+}
+
+// stubHanging resolves only after ctx is done (e.g. metadata endpoint is not reachable)
+func (t *stubResolvers) stubHanging(name string) addressResolver {
+	return func(ctx context.Context, configuration *config.Config) (string, error) {
+		t.called = append(t.called, name)
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+}
+
+func TestResolveAddressChain(t *testing.T) {
+	stubs := &stubResolvers{}
+	resolvers := map[string]addressResolver{
+		"config":        stubs.stub("config", "", nil),
+		"interface":     stubs.stub("interface", "", errors.New("no such network interface")),
+		"kubernetes":    stubs.stub("kubernetes", "10.0.0.1", nil),
+		"cloudMetadata": stubs.stub("cloudMetadata", "192.0.2.1", nil),
+	}
+
+	configuration := &config.Config{AddressResolvers: []string{"config", "interface", "kubernetes", "cloudMetadata"}}
+	address, err := resolveAddress(configuration, resolvers, time.Second, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// not applicable and failed resolvers are skipped, resolvers after resolved one are not called
+	if address != "10.0.0.1" {
+		t.Errorf("unexpected address: %s", address)
+	}
+	if !reflect.DeepEqual(stubs.called, []string{"config", "interface", "kubernetes"}) {
+		t.Errorf("unexpected resolver order: %v", stubs.called)
+	}
+
+	// configured order is used
+	stubs.called = nil
+	configuration.AddressResolvers = []string{"cloudMetadata", "kubernetes"}
+	address, err = resolveAddress(configuration, resolvers, time.Second, zap.NewNop())
+	if err != nil || address != "192.0.2.1" || !reflect.DeepEqual(stubs.called, []string{"cloudMetadata"}) {
+		t.Errorf("configured order is not used: %s %v %v", address, stubs.called, err)
+	}
+}
+
+func TestResolveAddressTimeout(t *testing.T) {
+	stubs := &stubResolvers{}
+	resolvers := map[string]addressResolver{
+		"cloudMetadata": stubs.stubHanging("cloudMetadata"),
+		"external":      stubs.stub("external", "192.0.2.1", nil),
+	}
+
+	start := time.Now()
+	address, err := resolveAddress(&config.Config{AddressResolvers: []string{"cloudMetadata", "external"}}, resolvers, 50*time.Millisecond, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the next resolver is tried after timeout
+	if address != "192.0.2.1" || !reflect.DeepEqual(stubs.called, []string{"cloudMetadata", "external"}) {
+		t.Errorf("timed out resolver must be skipped: %s %v", address, stubs.called)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("resolver is not limited by timeout: %v", time.Since(start))
+	}
+
+	// all resolvers are failed
+	_, err = resolveAddress(&config.Config{AddressResolvers: []string{"cloudMetadata"}}, resolvers, 50*time.Millisecond, zap.NewNop())
+	if err == nil || !strings.Contains(err.Error(), "cloudMetadata: context deadline exceeded") {
+		t.Errorf("timeout must be reported: %v", err)
+	}
+}
+
+func TestResolveAddressErrors(t *testing.T) {
+	stubs := &stubResolvers{}
+	resolvers := map[string]addressResolver{
+		"config": stubs.stub("config", "", nil),
+	}
+
+	_, err := resolveAddress(&config.Config{AddressResolvers: []string{"config"}}, resolvers, time.Second, zap.NewNop())
+	if err == nil || !strings.Contains(err.Error(), "no applicable resolver (tried config)") {
+		t.Errorf("not applicable resolvers must be reported: %v", err)
+	}
+
+	_, err = resolveAddress(&config.Config{AddressResolvers: []string{"unknown", "config"}}, resolvers, time.Second, zap.NewNop())
+	if err == nil || !strings.Contains(err.Error(), "unknown address resolver: unknown") {
+		t.Errorf("unknown resolver must be reported: %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/electronuserland/electron-build-service/internal"
+	"go.uber.org/zap"
+)
+
+const baseAdminPath = "/admin/"
+
+// configureAdmin registers admin endpoints. Admin API is enabled only if admin token is configured.
+// Requests must be authorized by header `Authorization: Bearer <token>`.
+func (t *BuildHandler) configureAdmin(mux *http.ServeMux) {
+	token := t.configManager.Get().AdminToken
+	if token == "" {
+		t.logger.Info("admin API is disabled", zap.String("reason", "adminToken is not set"))
+		return
+	}
+
+	mux.Handle(baseAdminPath+"drain", internal.RequireAdminToken(token, t.logger, t.HandleDrainRequest))
+	mux.Handle(baseAdminPath+"config", internal.RequireAdminToken(token, t.logger, t.HandleConfigRequest))
+	jobsHandler := internal.RequireAdminToken(token, t.logger, t.HandleAdminJobsRequest)
+	mux.Handle(adminJobsPath, jobsHandler)
+	mux.Handle(adminJobsPath+"/", jobsHandler)
+}
+
+// HandleConfigRequest handles GET /admin/config - effective configuration (live-reloadable fields are reloaded on SIGHUP), secrets are redacted.
+func (t *BuildHandler) HandleConfigRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	internal.WriteJson(w, t.configManager.Get().Redacted(), t.logger)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/electronuserland/electron-build-service/internal"
+	"github.com/electronuserland/electron-build-service/internal/agentRegistry"
+	"go.uber.org/zap"
+)
+
+const adminJobsPath = baseAdminPath + "jobs"
+
+func (t *BuildJob) GetSummary() agentRegistry.JobSummary {
+	targets := make([]string, len(t.buildRequest.Targets))
+	for index, target := range t.buildRequest.Targets {
+		targets[index] = target.Name + ":" + target.Arch
+	}
+
+	summary := agentRegistry.JobSummary{
+		Id:       t.id,
+		Agent:    t.handler.getAgentAddress(),
+		Tenant:   t.tenant,
+		State:    agentRegistry.JobStatePending,
+		Platform: t.buildRequest.Platform,
+		Targets:  targets,
+		ClientIp: t.clientIp,
+
+		UploadSize: t.uploadSize.Load(),
+		QueuedAt:   t.queueAddTime,
+	}
+
+	rawStartTime := t.startTime.Load()
+	if rawStartTime != 0 {
+		startTime := time.Unix(0, rawStartTime)
+		summary.State = agentRegistry.JobStateRunning
+		summary.StartedAt = &startTime
+
+		rawCompleteTime := t.completeTime.Load()
+		if rawCompleteTime != 0 {
+			completeTime := time.Unix(0, rawCompleteTime)
+			summary.State = agentRegistry.JobStateCompleted
+			summary.CompletedAt = &completeTime
+		}
+	}
+
+	summary.ComputeDurations(time.Now())
+	return summary
+}
+
+func (t *BuildHandler) getJobSummaries() []agentRegistry.JobSummary {
+	t.jobsLock.RLock()
+	result := make([]agentRegistry.JobSummary, 0, len(t.jobs))
+	for _, job := range t.jobs {
+		result = append(result, job.GetSummary())
+	}
+	t.jobsLock.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].QueuedAt.Before(result[j].QueuedAt)
+	})
+	return result
+}
+
+// HandleAdminJobsRequest handles:
+// GET /admin/jobs - list of pending, running and completed (artifacts are being downloaded) jobs,
+// GET /admin/jobs/{id} - job summary,
+// GET /admin/jobs/{id}/output - last lines of builder output,
+// POST /admin/jobs/{id}/kill - cancel job (409 if job is already cancelled).
+func (t *BuildHandler) HandleAdminJobsRequest(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path[len(adminJobsPath):], "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "only GET supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		internal.WriteJson(w, t.getJobSummaries(), t.logger)
+		return
+	}
+
+	segments := strings.Split(path, "/")
+	if len(segments) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	buildJob := t.getJob(segments[0])
+	if buildJob == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	action := ""
+	if len(segments) == 2 {
+		action = segments[1]
+	}
+
+	switch action {
+	case "":
+		if r.Method != http.MethodGet {
+			http.Error(w, "only GET supported", http.StatusMethodNotAllowed)
+			return
+		}
+		internal.WriteJson(w, buildJob.GetSummary(), t.logger)
+
+	case "output":
+		if r.Method != http.MethodGet {
+			http.Error(w, "only GET supported", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(buildJob.output.Bytes())
+
+	case "kill":
+		if r.Method != http.MethodPost {
+			http.Error(w, "only POST supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !buildJob.Cancel() {
+			writeJsonError(w, http.StatusConflict, "job is already cancelled", "alreadyCancelled")
+			return
+		}
+
+		t.logger.Info("job killed by admin", zap.String("jobId", buildJob.id))
+		internal.WriteJson(w, buildJob.GetSummary(), t.logger)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
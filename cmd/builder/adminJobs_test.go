@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/electronuserland/electron-build-service/internal/agentRegistry"
+	"github.com/json-iterator/go"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// addTestJob adds queued job, returned context is cancelled on job cancel
+func addTestJob(handler *BuildHandler, id string, token string) (*BuildJob, context.Context) {
+	ctx, cancel := context.WithCancel(context.Background())
+	buildJob := &BuildJob{
+		id:           id,
+		token:        token,
+		queueAddTime: time.Now(),
+		clientIp:     "192.0.2.1",
+		tenant:       "ci.example.com",
+		buildRequest: &BuildRequest{Platform: "linux", Targets: []TargetInfo{{Name: "deb", Arch: "x64"}}},
+		handler:      handler,
+
+		uploadSize:   atomic.NewInt64(42),
+		output:       newOutputTail(maxOutputTailSize),
+		startTime:    atomic.NewInt64(0),
+		completeTime: atomic.NewInt64(0),
+		cancel:       cancel,
+		isCancelled:  atomic.NewBool(false),
+
+		logger: zap.NewNop(),
+	}
+	handler.addJob(buildJob)
+	return buildJob, ctx
+}
+
+func readJobSummary(t *testing.T, data []byte) agentRegistry.JobSummary {
+	var summary agentRegistry.JobSummary
+	err := jsoniter.ConfigFastest.Unmarshal(data, &summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return summary
+}
+
+func TestAdminJobsRequestIsAuthorized(t *testing.T) {
+	handler, mux := createTestBuildHandler(t)
+	_, ctx := addTestJob(handler, "job1", "job-secret")
+
+	// job token is not an admin token
+	for _, token := range []string{"", "wrong", "job-secret"} {
+		for _, path := range []string{"/admin/jobs", "/admin/jobs/job1", "/admin/jobs/job1/output"} {
+			w := serve(mux, http.MethodGet, path, token)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("%s %q: unauthorized request must be rejected: %d", path, token, w.Code)
+			}
+		}
+
+		w := serve(mux, http.MethodPost, "/admin/jobs/job1/kill", token)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("kill %q: unauthorized request must be rejected: %d", token, w.Code)
+		}
+	}
+
+	if ctx.Err() != nil {
+		t.Errorf("job must be not killed by unauthorized request")
+	}
+}
+
+func TestAdminJobsRequest(t *testing.T) {
+	handler, mux := createTestBuildHandler(t)
+
+	w := serve(mux, http.MethodGet, "/admin/jobs", testAdminToken)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("empty list expected: %d %s", w.Code, w.Body.String())
+	}
+
+	buildJob, _ := addTestJob(handler, "job1", "job-secret")
+	secondJob, _ := addTestJob(handler, "job2", "job-secret")
+	// list is sorted by queue time
+	secondJob.queueAddTime = buildJob.queueAddTime.Add(time.Second)
+	secondJob.startTime.Store(time.Now().UnixNano())
+
+	w = serve(mux, http.MethodGet, "/admin/jobs", testAdminToken)
+	var summaries []agentRegistry.JobSummary
+	err := jsoniter.ConfigFastest.Unmarshal(w.Body.Bytes(), &summaries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 || summaries[0].Id != "job1" || summaries[0].State != agentRegistry.JobStatePending || summaries[1].State != agentRegistry.JobStateRunning {
+		t.Errorf("unexpected list: %s", w.Body.String())
+	}
+
+	w = serve(mux, http.MethodGet, "/admin/jobs/job1", testAdminToken)
+	summary := readJobSummary(t, w.Body.Bytes())
+	if w.Code != http.StatusOK || summary.Id != "job1" || summary.Tenant != "ci.example.com" || summary.ClientIp != "192.0.2.1" ||
+		summary.UploadSize != 42 || len(summary.Targets) != 1 || summary.Targets[0] != "deb:x64" {
+		t.Errorf("unexpected summary: %d %s", w.Code, w.Body.String())
+	}
+
+	_, _ = buildJob.output.Write([]byte("building deb\n"))
+	w = serve(mux, http.MethodGet, "/admin/jobs/job1/output", testAdminToken)
+	if w.Code != http.StatusOK || w.Body.String() != "building deb\n" {
+		t.Errorf("unexpected output: %d %q", w.Code, w.Body.String())
+	}
+
+	for _, path := range []string{"/admin/jobs/unknown", "/admin/jobs/unknown/output", "/admin/jobs/job1/unknown", "/admin/jobs/job1/output/unknown"} {
+		w = serve(mux, http.MethodGet, path, testAdminToken)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: not found expected: %d", path, w.Code)
+		}
+	}
+
+	w = serve(mux, http.MethodPost, "/admin/jobs/job1", testAdminToken)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status: %d", w.Code)
+	}
+}
+
+func TestAdminKillJob(t *testing.T) {
+	handler, mux := createTestBuildHandler(t)
+	_, ctx := addTestJob(handler, "job1", "job-secret")
+
+	w := serve(mux, http.MethodGet, "/admin/jobs/job1/kill", testAdminToken)
+	if w.Code != http.StatusMethodNotAllowed || ctx.Err() != nil {
+		t.Errorf("job must be killed only by POST: %d", w.Code)
+	}
+
+	w = serve(mux, http.MethodPost, "/admin/jobs/job1/kill", testAdminToken)
+	if w.Code != http.StatusOK || readJobSummary(t, w.Body.Bytes()).Id != "job1" {
+		t.Errorf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	if ctx.Err() == nil {
+		t.Errorf("job context must be cancelled")
+	}
+
+	w = serve(mux, http.MethodPost, "/admin/jobs/job1/kill", testAdminToken)
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "alreadyCancelled") {
+		t.Errorf("already killed job must be reported: %d %s", w.Code, w.Body.String())
+	}
+
+	w = serve(mux, http.MethodPost, "/admin/jobs/unknown/kill", testAdminToken)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("not found expected: %d", w.Code)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestConfigRequest(t *testing.T) {
+	_, mux := createTestBuildHandler(t)
+
+	for _, token := range []string{"", "wrong"} {
+		w := serve(mux, http.MethodGet, "/admin/config", token)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%q: unauthorized request must be rejected: %d", token, w.Code)
+		}
+	}
+
+	w := serve(mux, http.MethodGet, "/admin/config", testAdminToken)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"jobMaxTime":"30m0s"`) {
+		t.Errorf("unexpected config: %d %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), testAdminToken) {
+		t.Errorf("admin token must be redacted: %s", w.Body.String())
+	}
+
+	w = serve(mux, http.MethodPost, "/admin/config", testAdminToken)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status: %d", w.Code)
+	}
+}
+
+// admin API is not registered if admin token is not configured
+func TestAdminIsDisabledWithoutToken(t *testing.T) {
+	handler, _ := createTestBuildHandler(t)
+	handler.configManager = createTestConfigManager(t)
+
+	mux := http.NewServeMux()
+	handler.configureAdmin(mux)
+	for _, path := range []string{"/admin/drain", "/admin/config", "/admin/jobs"} {
+		w := serve(mux, http.MethodGet, path, "")
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: admin API must be disabled: %d", path, w.Code)
+		}
+	}
+}
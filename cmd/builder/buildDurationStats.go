@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// weight of the new sample in the exponential moving average
+const buildDurationSmoothing = 0.2
+
+// BuildDurationStats keeps moving average of build duration per target set (e.g. "appimage" or "deb,snap").
+type BuildDurationStats struct {
+	averages map[string]time.Duration
+	lock     sync.RWMutex
+}
+
+func NewBuildDurationStats() *BuildDurationStats {
+	return &BuildDurationStats{
+		averages: make(map[string]time.Duration),
+	}
+}
+
+func getTargetsKey(targets []TargetInfo) string {
+	names := make([]string, len(targets))
+	for index, target := range targets {
+		names[index] = strings.ToLower(target.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (t *BuildDurationStats) Add(targets []TargetInfo, duration time.Duration) {
+	key := getTargetsKey(targets)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	average, ok := t.averages[key]
+	if ok {
+		t.averages[key] = average + time.Duration(buildDurationSmoothing*float64(duration-average))
+	} else {
+		t.averages[key] = duration
+	}
+}
+
+// Estimate returns average build duration for the targets.
+// If targets were never built, average across all known targets is returned. Zero if nothing is known yet.
+func (t *BuildDurationStats) Estimate(targets []TargetInfo) time.Duration {
+	key := getTargetsKey(targets)
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	average, ok := t.averages[key]
+	if ok {
+		return average
+	}
+
+	if len(t.averages) == 0 {
+		return 0
+	}
+
+	var sum time.Duration
+	for _, value := range t.averages {
+		sum += value
+	}
+	return sum / time.Duration(len(t.averages))
+}
@@ -1,39 +1,71 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"github.com/develar/app-builder/pkg/electron"
 	"github.com/develar/app-builder/pkg/util"
 	"go.uber.org/atomic"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/develar/errors"
 	"github.com/develar/go-fs-util"
+	"github.com/electronuserland/electron-build-service/internal"
 	"github.com/electronuserland/electron-build-service/internal/agentRegistry"
+	"github.com/electronuserland/electron-build-service/internal/archive"
+	"github.com/electronuserland/electron-build-service/internal/buildHistory"
+	"github.com/electronuserland/electron-build-service/internal/config"
 	"github.com/electronuserland/electron-build-service/internal/gopool"
+	"github.com/electronuserland/electron-build-service/internal/schema"
+	"github.com/electronuserland/electron-build-service/internal/tracing"
 	"github.com/json-iterator/go"
 	"github.com/segmentio/ksuid"
 	"github.com/tomasen/realip"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
 const queueCompleteTimeOut = 1 * time.Minute
-const maxRequestBody = 768 * 1024 * 1024
-const jobMaxTime = 30 * time.Minute
 const maxUploadTime = 1 * time.Hour
 
+// uploaded archive is untrusted input
+var archiveLimits = archive.Limits{
+	MaxFileCount:        500000,
+	MaxUncompressedSize: 8 * 1024 * 1024 * 1024,
+	MaxCompressionRatio: 100,
+	RatioCheckThreshold: 64 * 1024 * 1024,
+}
+
 type BuildHandler struct {
+	configManager *config.Manager
+
+	// external address (ip:port)
+	agentAddress string
+	agentKey     string
+	// nil if agent is not registered (e.g. in drain mode)
 	agentEntry *agentRegistry.AgentEntry
-	logger     *zap.Logger
+	// capacity advertised by capacity controller (0 if not changed), applied on registration
+	agentCapacity int
+	agentLock     sync.Mutex
+
+	jobPublisher *agentRegistry.JobPublisher
+
+	drain     drainState
+	drainLock sync.Mutex
+
+	logger *zap.Logger
 
 	queueCancel context.CancelFunc
 	pool        *gopool.GoPool
@@ -41,20 +73,36 @@ type BuildHandler struct {
 	stageDir string
 	tempDir  string
 
-	zstdPath   string
 	scriptPath string
 
 	// don't use gopool running count because at the moment when we update agent entry,
 	// job can be not yet completed (so, for gopool job is still running, but for us already completed)
 	runningJobCount *atomic.Int32
+
+	buildDurationStats *BuildDurationStats
+
+	// space in stageDir reserved for accepted jobs
+	diskSpaceReservation *DiskSpaceReservation
+
+	// queued and running jobs (job is added after upload, on adding to queue)
+	jobs map[string]*BuildJob
+	// accepted jobs that are not yet added to queue (electron and upload are being unpacked), to abort them on drain deadline
+	uploadingJobs map[string]*BuildJob
+	jobsLock      sync.RWMutex
+
+	// nil if build history is disabled
+	historySink buildHistory.Sink
+
+	// nil if provenance is disabled
+	provenanceSigner *ProvenanceSigner
 }
 
-func (t *BuildHandler) CreateAndStartQueue(numWorkers int) {
+func (t *BuildHandler) CreateAndStartQueue(numWorkers int, queue gopool.Queue) {
 	ctx, cancel := context.WithCancel(context.Background())
 	t.queueCancel = cancel
 	logger := t.logger.Named("queue")
-	t.pool = gopool.New(numWorkers, ctx, logger)
-	t.pool.JobMaxTime = jobMaxTime
+	t.pool = gopool.NewWithQueue(numWorkers, queue, ctx, logger)
+	t.pool.SetJobMaxTime(time.Duration(t.configManager.Get().JobMaxTime))
 }
 
 func (t *BuildHandler) WaitTasksAreComplete() {
@@ -93,23 +141,96 @@ func (t *BuildHandler) PrepareDirs() error {
 }
 
 func (t *BuildHandler) RegisterAgent(port string, disposer *Disposer) error {
-	agentKey, err := getAgentKey(port, t.logger)
+	agentKey, err := getAgentKey(port, t.configManager.Get(), t.logger)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	agentEntry, err := agentRegistry.NewAgentEntry("/builders/"+agentKey, t.logger)
+	// readiness watcher and handlers read address concurrently
+	t.agentLock.Lock()
+	t.agentAddress = agentKey
+	t.agentKey = "/builders/" + agentKey
+	t.agentLock.Unlock()
+
+	err = t.registerAgent()
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	t.agentEntry = agentEntry
+	disposer.Add(t.unregisterAgent)
+
+	jobPublisher, err := agentRegistry.NewJobPublisher(t.configManager.Get().EtcdEndpoint, t.logger)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	t.agentLock.Lock()
+	t.jobPublisher = jobPublisher
+	t.agentLock.Unlock()
 	disposer.Add(func() {
-		util.Close(agentEntry)
+		util.Close(jobPublisher)
 	})
 	return nil
 }
 
+func (t *BuildHandler) registerAgent() error {
+	t.agentLock.Lock()
+	defer t.agentLock.Unlock()
+
+	// address is not yet resolved
+	if t.agentEntry != nil || t.agentKey == "" {
+		return nil
+	}
+
+	agentEntry, err := agentRegistry.NewAgentEntry(t.agentKey, t.configManager.Get().EtcdEndpoint, t.logger)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if t.agentCapacity > 0 {
+		agentEntry.SetCapacity(t.agentCapacity)
+	}
+	agentEntry.Update(int(t.runningJobCount.Load()) + t.pool.GetPendingJobCount())
+
+	t.agentEntry = agentEntry
+	return nil
+}
+
+// getAgentAddress returns external address (ip:port), empty if not yet resolved
+func (t *BuildHandler) getAgentAddress() string {
+	t.agentLock.Lock()
+	defer t.agentLock.Unlock()
+	return t.agentAddress
+}
+
+// getJobPublisher returns nil if agent is not yet registered
+func (t *BuildHandler) getJobPublisher() *agentRegistry.JobPublisher {
+	t.agentLock.Lock()
+	defer t.agentLock.Unlock()
+	return t.jobPublisher
+}
+
+// unregisterAgent removes agent entry, so, router will not select this agent anymore
+func (t *BuildHandler) unregisterAgent() {
+	t.agentLock.Lock()
+	defer t.agentLock.Unlock()
+
+	if t.agentEntry != nil {
+		util.Close(t.agentEntry)
+		t.agentEntry = nil
+	}
+}
+
+func (t *BuildHandler) setAgentCapacity(capacity int) {
+	t.agentLock.Lock()
+	defer t.agentLock.Unlock()
+
+	t.agentCapacity = capacity
+	if t.agentEntry != nil {
+		t.agentEntry.SetCapacity(capacity)
+	}
+}
+
 func (t *BuildHandler) HandleBuildRequest(w http.ResponseWriter, r *http.Request) {
 	logger := t.logger
 	if r.Method != "POST" {
@@ -119,6 +240,16 @@ func (t *BuildHandler) HandleBuildRequest(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	ctx, span := tracing.StartServerSpan(r, "build")
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	if t.isDraining() {
+		logger.Debug("reject build", zap.String("reason", "agent is draining"), zap.String("ip", realip.FromRequest(r)))
+		writeJsonError(w, http.StatusServiceUnavailable, "build agent is draining, please use another one", "draining")
+		return
+	}
+
 	rawRequest := r.Header.Get("x-build-request")
 	if rawRequest == "" {
 		errorMessage := "header x-build-request is not specified"
@@ -127,8 +258,12 @@ func (t *BuildHandler) HandleBuildRequest(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	var buildRequest BuildRequest
-	err := jsoniter.UnmarshalFromString(rawRequest, &buildRequest)
+	buildRequest, violations, err := parseBuildRequest(rawRequest)
+	if len(violations) != 0 {
+		logger.Warn("invalid build request", zap.Any("violations", violations), zap.String("ip", realip.FromRequest(r)))
+		writeValidationError(w, violations, logger)
+		return
+	}
 	if err != nil {
 		errorMessage := "cannot parse build request"
 		logger.Warn(errorMessage, zap.Error(err), zap.String("ip", realip.FromRequest(r)))
@@ -136,36 +271,93 @@ func (t *BuildHandler) HandleBuildRequest(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// used as tenant for fair scheduling, so, forwarded headers are trusted only from router or trusted proxy (otherwise client can get new tenant per request)
+	configuration := t.configManager.Get()
+	clientIp := getClientIp(r, isProxiedByRouter(r, configuration.RouterIdentity), configuration.TrustedProxies)
 	jobId := ksuid.New().String()
+	jobToken, err := generateJobToken()
+	if err != nil {
+		logger.Error("cannot generate job token", zap.Error(err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	releaseDiskSpace, maxUploadSize := t.reserveDiskSpace(w, r, logger)
+	if releaseDiskSpace == nil {
+		return
+	}
+
 	buildJob := &BuildJob{
 		id:              jobId,
-		buildRequest:    &buildRequest,
+		token:           jobToken,
+		buildRequest:    buildRequest,
 		rawBuildRequest: &rawRequest,
 
 		projectDir: filepath.Join(t.stageDir, jobId),
+		clientIp:   clientIp,
+		tenant:     getTenant(r, clientIp, configuration.RouterIdentity),
 		handler:    t,
 
+		releaseDiskSpace: releaseDiskSpace,
+		maxUploadSize:    maxUploadSize,
+
 		messages: make(chan string),
 		complete: make(chan BuildJobResult),
 
+		uploadSize:   atomic.NewInt64(0),
+		output:       newOutputTail(maxOutputTailSize),
+		startTime:    atomic.NewInt64(0),
+		completeTime: atomic.NewInt64(0),
+		isCancelled:  atomic.NewBool(false),
+
 		logger: logger.With(zap.String("jobId", jobId)),
 	}
 
+	span.SetAttributes(
+		attribute.String("job.id", jobId),
+		attribute.String("job.tenant", buildJob.tenant),
+		attribute.String("job.platform", buildRequest.Platform),
+	)
+
 	err = t.doBuild(w, r, buildJob)
 	if err != nil {
+		buildJob.setOutcome(buildHistory.OutcomeFailure, errorClassInternal)
+	} else {
+		// handler returned without result (e.g. client closed connection)
+		buildJob.setOutcome(buildHistory.OutcomeAborted, "")
+	}
+	t.writeHistory(buildJob)
+	span.SetAttributes(attribute.String("job.outcome", buildJob.outcome))
+
+	if err != nil {
+		span.RecordError(err)
 		logger.Error("error", zap.Error(err))
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 }
 
-func (t *BuildHandler) executeUnpackTarZstd(w http.ResponseWriter, r *http.Request, buildJob *BuildJob, projectDir string, parentContext context.Context) error {
+func (t *BuildHandler) executeUnpackTarZstd(r *http.Request, buildJob *BuildJob, projectDir string, parentContext context.Context) (err error) {
+	parentContext, span := tracing.Tracer().Start(parentContext, "upload")
+	defer func() {
+		span.SetAttributes(attribute.Int64("upload.size", buildJob.uploadSize.Load()))
+		tracing.EndSpan(span, err)
+	}()
+
 	unpackContext, cancel := context.WithTimeout(parentContext, maxUploadTime)
 	defer cancel()
 
 	start := time.Now()
 	body := r.Body
-	err := t.unpackTarZstd(http.MaxBytesReader(w, body, maxRequestBody), projectDir, unpackContext)
+	// digest is computed while streaming (for provenance)
+	hash := sha256.New()
+	// not http.MaxBytesReader - its error is not typed (and cannot be reported as 413)
+	reader := io.TeeReader(&countingReader{reader: body, count: buildJob.uploadSize, limit: buildJob.maxUploadSize}, hash)
+	err = t.unpackTarZstd(reader, projectDir, unpackContext)
+	if err == nil {
+		// tar can stop reading before the end of stream (e.g. trailing padding), digest must be computed for the whole archive
+		_, err = io.Copy(ioutil.Discard, reader)
+	}
 	closeError := body.Close()
 	logCloseError(closeError, buildJob)
 
@@ -177,14 +369,49 @@ func (t *BuildHandler) executeUnpackTarZstd(w http.ResponseWriter, r *http.Reque
 		return closeError
 	}
 
+	buildJob.uploadDuration = time.Since(start)
+	buildJob.inputDigest = hex.EncodeToString(hash.Sum(nil))
 	buildJob.logger.Info("uploaded and unpacked",
-		zap.Duration("elapsed", time.Since(start)),
+		zap.Duration("elapsed", buildJob.uploadDuration),
+		zap.String("inputDigest", buildJob.inputDigest),
 		zap.String("compressionLevel", r.Header.Get("x-zstd-compression-level")),
 	)
 
 	return nil
 }
 
+// abortUploadOnCancel interrupts body read on context cancel (e.g. on drain deadline) - context is checked only between reads,
+// and blocked read of stalled upload is not interrupted by context
+func abortUploadOnCancel(w http.ResponseWriter, ctx context.Context) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			// ErrNotSupported is not expected for server connection
+			_ = http.NewResponseController(w).SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// countingReader returns errUploadTooLarge if more than limit bytes are read
+type countingReader struct {
+	reader io.Reader
+	count  *atomic.Int64
+	limit  int64
+}
+
+func (t *countingReader) Read(p []byte) (int, error) {
+	n, err := t.reader.Read(p)
+	if t.count.Add(int64(n)) > t.limit {
+		return n, errUploadTooLarge
+	}
+	return n, err
+}
+
 func logCloseError(err error, buildJob *BuildJob) {
 	// do not log ErrUnexpectedEOF - it means that client closed connection during upload
 	if err != nil && err != os.ErrClosed && err != io.ErrUnexpectedEOF {
@@ -205,6 +432,7 @@ func (t *BuildHandler) doBuild(w http.ResponseWriter, r *http.Request, buildJob
 	projectDir := buildJob.projectDir
 	err := os.Mkdir(projectDir, 0700)
 	if err != nil {
+		buildJob.releaseDiskSpace()
 		return errors.WithStack(err)
 	}
 
@@ -220,37 +448,94 @@ func (t *BuildHandler) doBuild(w http.ResponseWriter, r *http.Request, buildJob
 			t.updateAgentInfo(running)
 		}
 
-		go removeFileAndLog(logger, projectDir)
+		go func() {
+			removeFileAndLog(logger, projectDir)
+			buildJob.releaseDiskSpace()
+		}()
 	}()
 
-	requestContext := r.Context()
+	// job context is cancelled on client disconnect or on explicit cancel request
+	requestContext, cancelJob := context.WithCancel(r.Context())
+	defer cancelJob()
+	buildJob.cancel = cancelJob
+
+	t.addUploadingJob(buildJob)
+	isUploading := true
+	defer func() {
+		if isUploading {
+			t.removeUploadingJob(buildJob)
+		}
+	}()
 
 	// must be unpacked before user files
-	err = t.unpackElectron(buildJob, projectDir)
+	err = t.unpackElectron(requestContext, buildJob, projectDir)
 	if err != nil {
+		buildJob.setOutcome(buildHistory.OutcomeFailure, errorClassElectron)
 		return err
 	}
 
-	err = t.executeUnpackTarZstd(w, r, buildJob, projectDir, requestContext)
+	stopAbortingUpload := abortUploadOnCancel(w, requestContext)
+	err = t.executeUnpackTarZstd(r, buildJob, projectDir, requestContext)
+	stopAbortingUpload()
 	if err != nil {
 		if requestContext.Err() == nil {
+			if _, ok := err.(*archive.ValidationError); ok {
+				logger.Warn("archive is rejected", zap.Error(err))
+				buildJob.setOutcome(buildHistory.OutcomeFailure, errorClassInvalidArchive)
+				writeJsonError(w, http.StatusBadRequest, err.Error(), "invalidArchive")
+				return nil
+			}
+
+			// decompressor can wrap reader error, so, size is checked instead of error
+			if buildJob.uploadSize.Load() > buildJob.maxUploadSize {
+				logger.Warn("upload is rejected", zap.Error(err), zap.Int64("maxUploadSize", buildJob.maxUploadSize))
+				buildJob.setOutcome(buildHistory.OutcomeFailure, errorClassUpload)
+				writeJsonError(w, http.StatusRequestEntityTooLarge, errUploadTooLarge.Error(), "uploadTooLarge")
+				return nil
+			}
+
+			buildJob.setOutcome(buildHistory.OutcomeFailure, errorClassUpload)
 			return err
+		} else if buildJob.isCancelled.Load() {
+			// e.g. aborted on drain deadline
+			logger.Info("job cancelled on upload")
+			buildJob.setOutcome(buildHistory.OutcomeCancelled, "")
+			writeJsonError(w, http.StatusServiceUnavailable, "job is cancelled", "cancelled")
+			return nil
 		} else {
 			logger.Debug("ignore unpack error because client closed connection")
+			buildJob.setOutcome(buildHistory.OutcomeAborted, errorClassUpload)
 			return nil
 		}
 	}
 
+	// ended by job on start, or here if job is not started
+	_, buildJob.queueSpan = tracing.Tracer().Start(requestContext, "queue")
+	defer buildJob.queueSpan.End()
+	buildJob.spanContext = trace.SpanContextFromContext(requestContext)
+
 	buildJob.queueAddTime = time.Now()
 	jobEntry := t.pool.AddJob(buildJob, 0)
 
 	defer jobEntry.Cancel()
 
+	t.addJob(buildJob)
+	defer t.removeJob(buildJob)
+	// removed after adding to jobs, so, job cannot be missed by abortRemainingJobs
+	t.removeUploadingJob(buildJob)
+	isUploading = false
+
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		return errors.New("cannot cast to Flusher")
 	}
 
+	// send job id and token as soon as possible to allow client to cancel job while it is in a queue
+	w.Header().Set("x-job-id", buildJob.id)
+	w.Header().Set("x-job-token", buildJob.token)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
 	jsonWriter := jsoniter.NewStream(jsoniter.ConfigFastest, w, 16*1024)
 
 	flushJsonWriter := func() error {
@@ -268,10 +553,45 @@ func (t *BuildHandler) doBuild(w http.ResponseWriter, r *http.Request, buildJob
 	ticker := time.NewTicker(20 * time.Second)
 	defer ticker.Stop()
 
+	// report queue position until job is started
+	queueTicker := time.NewTicker(2 * time.Second)
+	defer queueTicker.Stop()
+	queueTickerChannel := queueTicker.C
+	lastQueuePosition := -1
+
 	isCompleted := false
 	for {
 		select {
+		case <-queueTickerChannel:
+			pendingIds := t.pool.GetPendingJobIds()
+			position := indexOf(pendingIds, buildJob.id)
+			if position == -1 {
+				// started (or cancelled) - no need to report queue position anymore
+				queueTickerChannel = nil
+				continue
+			}
+
+			if position == lastQueuePosition {
+				continue
+			}
+
+			lastQueuePosition = position
+			jsonWriter.Reset(w)
+			writeStatus(t.formatQueueStatus(pendingIds, position), jsonWriter)
+			err = flushJsonWriter()
+			if err != nil {
+				return err
+			}
+
 		case <-requestContext.Done():
+			if buildJob.isCancelled.Load() {
+				buildJob.setOutcome(buildHistory.OutcomeCancelled, "")
+				logger.Info("job cancelled")
+				jsonWriter.Reset(w)
+				writeCancelled(jsonWriter)
+				return flushJsonWriter()
+			}
+
 			logger.Debug("client closed connection")
 			return nil
 
@@ -307,9 +627,17 @@ func (t *BuildHandler) doBuild(w http.ResponseWriter, r *http.Request, buildJob
 
 			logger.Debug("complete received", zap.Error(result.error))
 			if result.error != nil {
+				buildJob.setOutcome(buildHistory.OutcomeFailure, errorClassInternal)
 				return err
 			}
 
+			if len(result.rawResult) > 0 && result.rawResult[0] == '[' {
+				buildJob.setOutcome(buildHistory.OutcomeSuccess, "")
+				buildJob.fileSizes = result.fileSizes
+			} else {
+				buildJob.setOutcome(buildHistory.OutcomeFailure, errorClassBuilder)
+			}
+
 			jsonWriter.Reset(w)
 			writeResultInfo(&result, buildJob.id, jsonWriter)
 			err = flushJsonWriter()
@@ -326,12 +654,17 @@ func (t *BuildHandler) doBuild(w http.ResponseWriter, r *http.Request, buildJob
 	}
 }
 
-func (t *BuildHandler) unpackElectron(buildJob *BuildJob, projectDir string) error {
+func (t *BuildHandler) unpackElectron(ctx context.Context, buildJob *BuildJob, projectDir string) (err error) {
 	electronDownloadOptions := buildJob.buildRequest.ElectronDownload
 	if len(electronDownloadOptions.Version) == 0 {
 		return nil
 	}
 
+	_, span := tracing.Tracer().Start(ctx, "unpackElectron", trace.WithAttributes(attribute.String("electron.version", electronDownloadOptions.Version)))
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
 	executableName := buildJob.buildRequest.ExecutableName
 	if len(executableName) == 0 || strings.Contains(executableName, "/") || strings.Contains(executableName, "\\") {
 		return errors.New("executableName is invalid")
@@ -340,12 +673,13 @@ func (t *BuildHandler) unpackElectron(buildJob *BuildJob, projectDir string) err
 	// unset unused options to ensure that will be not maliciously used
 	electronDownloadOptions.CacheDir = ""
 	electronDownloadOptions.CustomDir = ""
+	electronDownloadOptions.CustomFilename = ""
 	electronDownloadOptions.Mirror = ""
 
 	start := time.Now()
 
 	unpackDir := filepath.Join(projectDir, buildJob.buildRequest.Targets[0].UnpackedDirName)
-	err := electron.UnpackElectron([]electron.ElectronDownloadOptions{electronDownloadOptions}, unpackDir, "", true)
+	err = electron.UnpackElectron([]electron.ElectronDownloadOptions{electronDownloadOptions}, unpackDir, "", true)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -368,7 +702,194 @@ func (t *BuildHandler) updateAgentInfo(running int) {
 	pending := t.pool.GetPendingJobCount()
 
 	t.logger.Debug("queue stat", zap.Int("pending", pending), zap.Int("running", running), zap.Int("jobPoolRunning", t.pool.GetRunningJobCount()))
-	t.agentEntry.Update(pending + running /* our job */)
+
+	t.agentLock.Lock()
+	defer t.agentLock.Unlock()
+	if t.agentEntry != nil {
+		t.agentEntry.Update(pending + running /* our job */)
+	}
+}
+
+func (t *BuildHandler) formatQueueStatus(pendingIds []string, position int) string {
+	message := fmt.Sprintf("queued: position %d", position+1)
+	eta := t.estimateQueueTime(pendingIds, position)
+	if eta > 0 {
+		message += fmt.Sprintf(", ETA ~%s", eta.Round(time.Second))
+	}
+	return message
+}
+
+// estimateQueueTime estimates time until job at the given position will be started:
+// remaining build time of running jobs plus build time of jobs ahead in the queue, divided by worker count.
+func (t *BuildHandler) estimateQueueTime(pendingIds []string, position int) time.Duration {
+	var total time.Duration
+	now := time.Now()
+
+	t.jobsLock.RLock()
+	defer t.jobsLock.RUnlock()
+
+	for _, job := range t.jobs {
+		startTime := job.startTime.Load()
+		if startTime == 0 || job.completeTime.Load() != 0 {
+			continue
+		}
+
+		remaining := t.buildDurationStats.Estimate(job.buildRequest.Targets) - now.Sub(time.Unix(0, startTime))
+		if remaining > 0 {
+			total += remaining
+		}
+	}
+
+	for _, id := range pendingIds[:position] {
+		job := t.jobs[id]
+		if job != nil {
+			total += t.buildDurationStats.Estimate(job.buildRequest.Targets)
+		}
+	}
+
+	return total / time.Duration(t.pool.GetWorkerCount())
+}
+
+// isProxiedByRouter checks whether request is authenticated by router certificate (routerIdentity)
+func isProxiedByRouter(r *http.Request, routerIdentity string) bool {
+	return routerIdentity != "" && internal.GetClientIdentity(r) == routerIdentity
+}
+
+// getClientIp returns IP of the peer, or IP forwarded by router or trusted proxy (X-Forwarded-For, the rightmost address that is not a trusted proxy).
+// realip is not used because it trusts X-Forwarded-For and X-Real-IP set by anyone.
+func getClientIp(r *http.Request, isFromRouter bool, trustedProxies []string) string {
+	clientIp, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIp = r.RemoteAddr
+	}
+
+	var networks []*net.IPNet
+	for _, value := range trustedProxies {
+		network := config.ParseNetwork(value)
+		if network != nil {
+			networks = append(networks, network)
+		}
+	}
+
+	isTrusted := func(ip string) bool {
+		parsedIp := net.ParseIP(ip)
+		if parsedIp == nil {
+			return false
+		}
+		for _, network := range networks {
+			if network.Contains(parsedIp) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !isFromRouter && !isTrusted(clientIp) {
+		return clientIp
+	}
+
+	// each proxy appends address of its peer, so, addresses are checked from right to left
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for index := len(forwarded) - 1; index >= 0; index-- {
+		ip := strings.TrimSpace(forwarded[index])
+		if net.ParseIP(ip) == nil {
+			// garbage is not trusted, the last trusted address is used
+			break
+		}
+
+		clientIp = ip
+		if !isTrusted(ip) {
+			break
+		}
+	}
+	return clientIp
+}
+
+// getTenant returns identity of client certificate if client is authenticated by certificate, client IP otherwise.
+// If build is proxied by router (authenticated by routerIdentity certificate), identity forwarded by router is used.
+func getTenant(r *http.Request, clientIp string, routerIdentity string) string {
+	identity := internal.GetClientIdentity(r)
+	if routerIdentity != "" && identity == routerIdentity {
+		// client is not authenticated by certificate on router, client IP is forwarded by router (X-Forwarded-For)
+		identity = r.Header.Get(internal.ForwardedClientIdentityHeader)
+	}
+	if identity == "" {
+		return clientIp
+	}
+	return identity
+}
+
+func indexOf(list []string, value string) int {
+	for index, item := range list {
+		if item == value {
+			return index
+		}
+	}
+	return -1
+}
+
+func writeJsonError(w http.ResponseWriter, statusCode int, message string, reason string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statusCode)
+
+	jsonWriter := jsoniter.NewStream(jsoniter.ConfigFastest, w, 512)
+	jsonWriter.WriteObjectStart()
+	jsonWriter.WriteObjectField("error")
+	jsonWriter.WriteString(message)
+	jsonWriter.WriteMore()
+	jsonWriter.WriteObjectField("reason")
+	jsonWriter.WriteString(reason)
+	jsonWriter.WriteObjectEnd()
+	jsonWriter.WriteRaw("\n")
+	_ = jsonWriter.Flush()
+}
+
+type validationErrorResponse struct {
+	Error      string             `json:"error"`
+	Reason     string             `json:"reason"`
+	Schema     string             `json:"schema"`
+	Violations []schema.Violation `json:"violations"`
+}
+
+// default jsoniter config matches keys case-insensitively, so, key that is not known to schema (e.g. UnpackedDirName) can override validated value
+var buildRequestJson = jsoniter.Config{CaseSensitive: true, DisallowUnknownFields: true}.Froze()
+
+// parseBuildRequest validates request against schema before decoding - invalid targets otherwise surface later as tar or node failures.
+// Every schema object lists allowed keys (all of them are known to decoder), and both schema and decoder are case-sensitive, so, decoded request is exactly the validated one.
+func parseBuildRequest(rawRequest string) (*BuildRequest, []schema.Violation, error) {
+	violations := schema.ValidateBuildRequest([]byte(rawRequest))
+	if len(violations) != 0 {
+		return nil, violations, nil
+	}
+
+	var buildRequest BuildRequest
+	err := buildRequestJson.UnmarshalFromString(rawRequest, &buildRequest)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return &buildRequest, nil, nil
+}
+
+// writeValidationError responds with 400 and lists every violation (see schema.BuildRequestSchema)
+func writeValidationError(w http.ResponseWriter, violations []schema.Violation, logger *zap.Logger) {
+	data, err := jsoniter.ConfigFastest.Marshal(validationErrorResponse{
+		Error:      "build request is invalid",
+		Reason:     "invalidBuildRequest",
+		Schema:     schema.BuildRequestSchemaPath,
+		Violations: violations,
+	})
+	if err != nil {
+		logger.Error("cannot serialize", zap.Error(err))
+		http.Error(w, "build request is invalid", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = w.Write(data)
+	_, _ = w.Write([]byte("\n"))
 }
 
 func writeStatus(message string, jsonWriter *jsoniter.Stream) {
@@ -378,6 +899,17 @@ func writeStatus(message string, jsonWriter *jsoniter.Stream) {
 	jsonWriter.WriteObjectEnd()
 }
 
+func writeCancelled(jsonWriter *jsoniter.Stream) {
+	jsonWriter.WriteObjectStart()
+	jsonWriter.WriteObjectField("cancelled")
+	jsonWriter.WriteTrue()
+	// old clients do not know about cancelled field, but handle error
+	jsonWriter.WriteMore()
+	jsonWriter.WriteObjectField("error")
+	jsonWriter.WriteString("job cancelled")
+	jsonWriter.WriteObjectEnd()
+}
+
 func writeResultInfo(result *BuildJobResult, jobId string, jsonWriter *jsoniter.Stream) {
 	jsonWriter.WriteObjectStart()
 
@@ -411,20 +943,19 @@ func writeResultInfo(result *BuildJobResult, jobId string, jsonWriter *jsoniter.
 		jsonWriter.WriteArrayEnd()
 	}
 
+	if result.hasProvenance {
+		jsonWriter.WriteMore()
+		jsonWriter.WriteObjectField("provenance")
+		jsonWriter.WriteString(provenanceFileName)
+	}
+
 	jsonWriter.WriteObjectEnd()
 }
 
 func (t *BuildHandler) unpackTarZstd(reader io.Reader, unpackDir string, ctx context.Context) error {
-	command := exec.CommandContext(ctx, "tar", "--use-compress-program="+t.zstdPath, "-x", "-C", unpackDir)
-	command.Stdin = reader
-
-	var errorOutput bytes.Buffer
-	command.Stderr = &errorOutput
-
-	err := command.Run()
+	err := archive.ExtractTarZstd(ctx, reader, unpackDir, archiveLimits)
 	if err != nil && ctx.Err() == nil {
-		return errors.Wrapf(err, "errorOutput: "+errorOutput.String())
+		return err
 	}
-
 	return nil
 }
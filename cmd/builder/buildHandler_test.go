@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"testing"
+
+	"github.com/electronuserland/electron-build-service/internal"
+)
+
+func TestParseBuildRequest(t *testing.T) {
+	buildRequest, violations, err := parseBuildRequest(`{"platform":"linux","targets":[{"name":"deb","arch":"x64","unpackedDirName":"linux-unpacked"}],"electronDownload":{"version":"8.2.0","mirror":"https://example.com/"},"executableName":"app"}`)
+	if err != nil || violations != nil {
+		t.Fatalf("request must be valid: %v %v", violations, err)
+	}
+	if buildRequest.Targets[0].UnpackedDirName != "linux-unpacked" || buildRequest.ElectronDownload.Version != "8.2.0" || buildRequest.ExecutableName != "app" {
+		t.Errorf("unexpected request: %+v", buildRequest)
+	}
+}
+
+func TestParseBuildRequestRejectsUnknownKeys(t *testing.T) {
+	testCases := []struct {
+		name    string
+		request string
+	}{
+		// decoded case-insensitively, UnpackedDirName would override validated unpackedDirName
+		{"target key", `{"platform":"linux","targets":[{"name":"deb","arch":"x64","unpackedDirName":"linux-unpacked","UnpackedDirName":"../../etc"}]}`},
+		{"request key", `{"platform":"linux","targets":[{"name":"deb","arch":"x64","unpackedDirName":"linux-unpacked"}],"Platform":"win"}`},
+		{"electron download key", `{"platform":"linux","targets":[{"name":"deb","arch":"x64","unpackedDirName":"linux-unpacked"}],"electronDownload":{"version":"8.2.0","Version":"../../1.0.0"},"executableName":"app"}`},
+		{"electron cache dir", `{"platform":"linux","targets":[{"name":"deb","arch":"x64","unpackedDirName":"linux-unpacked"}],"electronDownload":{"version":"8.2.0","cache":"/etc"},"executableName":"app"}`},
+		{"electron custom dir", `{"platform":"linux","targets":[{"name":"deb","arch":"x64","unpackedDirName":"linux-unpacked"}],"electronDownload":{"version":"8.2.0","customDir":"../.."},"executableName":"app"}`},
+	}
+
+	for _, testCase := range testCases {
+		buildRequest, violations, err := parseBuildRequest(testCase.request)
+		if buildRequest != nil {
+			t.Errorf("%s: request must be rejected: %+v", testCase.name, buildRequest)
+		}
+		// every violation is listed in response (not plain text decoder error)
+		if len(violations) == 0 {
+			t.Errorf("%s: request must be rejected by schema: %v", testCase.name, err)
+		}
+	}
+}
+
+func createRequest(t *testing.T, clientIdentity string, forwardedIdentity string) *http.Request {
+	r, err := http.NewRequest(http.MethodPost, "https://localhost/v2/build", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientIdentity != "" {
+		certificate := &x509.Certificate{Subject: pkix.Name{CommonName: clientIdentity}}
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{certificate}}}
+	}
+	if forwardedIdentity != "" {
+		r.Header.Set(internal.ForwardedClientIdentityHeader, forwardedIdentity)
+	}
+	return r
+}
+
+func TestGetTenant(t *testing.T) {
+	testCases := []struct {
+		name              string
+		clientIdentity    string
+		forwardedIdentity string
+		routerIdentity    string
+		expected          string
+	}{
+		{"not authenticated", "", "", "router", "10.0.0.1"},
+		{"authenticated", "ci.example.com", "", "router", "ci.example.com"},
+		{"proxied", "router", "ci.example.com", "router", "ci.example.com"},
+		{"proxied not authenticated", "router", "", "router", "10.0.0.1"},
+		{"forwarded by not router", "ci.example.com", "other.example.com", "router", "ci.example.com"},
+		{"forwarded by not authenticated", "", "other.example.com", "router", "10.0.0.1"},
+		{"router is not configured", "router", "other.example.com", "", "router"},
+	}
+
+	for _, testCase := range testCases {
+		tenant := getTenant(createRequest(t, testCase.clientIdentity, testCase.forwardedIdentity), "10.0.0.1", testCase.routerIdentity)
+		if tenant != testCase.expected {
+			t.Errorf("%s: expected %s, actual %s", testCase.name, testCase.expected, tenant)
+		}
+	}
+}
+
+// readiness watcher can tick before address is resolved (resolvers and etcd dial are slow)
+func TestRegisterAgentBeforeAddressIsResolved(t *testing.T) {
+	handler := &BuildHandler{}
+	err := handler.registerAgentIfNotDraining()
+	if err != nil || handler.agentEntry != nil {
+		t.Errorf("agent must be not registered with empty key: %v", err)
+	}
+}
+
+func TestGetClientIp(t *testing.T) {
+	testCases := []struct {
+		name           string
+		remoteAddr     string
+		forwardedFor   []string
+		isFromRouter   bool
+		trustedProxies []string
+		expected       string
+	}{
+		{"direct", "192.0.2.1:1234", nil, false, nil, "192.0.2.1"},
+		{"forwarded by untrusted peer", "192.0.2.1:1234", []string{"198.51.100.1"}, false, []string{"10.0.0.0/8"}, "192.0.2.1"},
+		{"forwarded by trusted proxy", "10.0.0.2:1234", []string{"198.51.100.1"}, false, []string{"10.0.0.0/8"}, "198.51.100.1"},
+		{"forwarded by trusted proxy IP", "10.0.0.2:1234", []string{"198.51.100.1"}, false, []string{"10.0.0.2"}, "198.51.100.1"},
+		{"spoofed address is not used", "10.0.0.2:1234", []string{"203.0.113.7, 198.51.100.1"}, false, []string{"10.0.0.0/8"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.2:1234", []string{"203.0.113.7, 198.51.100.1", "10.0.0.3"}, false, []string{"10.0.0.0/8"}, "198.51.100.1"},
+		{"forwarded by router", "192.0.2.1:1234", []string{"198.51.100.1"}, true, nil, "198.51.100.1"},
+		{"not forwarded by trusted proxy", "10.0.0.2:1234", nil, false, []string{"10.0.0.0/8"}, "10.0.0.2"},
+		{"garbage", "10.0.0.2:1234", []string{"unknown"}, false, []string{"10.0.0.0/8"}, "10.0.0.2"},
+		{"IPv6", "[2001:db8::1]:1234", []string{"198.51.100.1"}, false, []string{"2001:db8::/32"}, "198.51.100.1"},
+	}
+
+	for _, testCase := range testCases {
+		r, err := http.NewRequest(http.MethodPost, "https://localhost/v2/build", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.RemoteAddr = testCase.remoteAddr
+		// realip header must be never trusted
+		r.Header.Set("X-Real-IP", "203.0.113.9")
+		for _, value := range testCase.forwardedFor {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+
+		actual := getClientIp(r, testCase.isFromRouter, testCase.trustedProxies)
+		if actual != testCase.expected {
+			t.Errorf("%s: expected %s, actual %s", testCase.name, testCase.expected, actual)
+		}
+	}
+}
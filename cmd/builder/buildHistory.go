@@ -0,0 +1,113 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/electronuserland/electron-build-service/internal"
+	"github.com/electronuserland/electron-build-service/internal/buildHistory"
+	"go.uber.org/zap"
+)
+
+const errorClassInternal = "internal"
+const errorClassUpload = "upload"
+const errorClassInvalidArchive = "invalidArchive"
+const errorClassElectron = "electron"
+
+// electron-builder reported error
+const errorClassBuilder = "builder"
+
+// file: path to the build history database (default: builder-history/history.db), "off" to disable.
+// Database is not in the stage dir because stage dir is emptied on start.
+func createHistorySink(file string) (buildHistory.Sink, error) {
+	switch file {
+	case "off":
+		return nil, nil
+	case "":
+		file = filepath.Join(internal.GetBuilderDirectory("history"), "history.db")
+	}
+
+	return buildHistory.NewBoltStore(file)
+}
+
+// setOutcome is called by handler only, first set outcome wins (e.g. client can close connection after build failure)
+func (t *BuildJob) setOutcome(outcome string, errorClass string) {
+	if t.outcome == "" {
+		t.outcome = outcome
+		t.errorClass = errorClass
+	}
+}
+
+func (t *BuildJob) getHistoryRecord(now time.Time) *buildHistory.Record {
+	targetSet := make(map[string]bool)
+	archSet := make(map[string]bool)
+	for _, target := range t.buildRequest.Targets {
+		targetSet[target.Name] = true
+		archSet[target.Arch] = true
+	}
+
+	record := &buildHistory.Record{
+		JobId:           t.id,
+		Tenant:          t.tenant,
+		Platform:        t.buildRequest.Platform,
+		Targets:         toSortedList(targetSet),
+		Archs:           toSortedList(archSet),
+		ElectronVersion: t.buildRequest.ElectronDownload.Version,
+		CompletedAt:     now,
+		UploadTime:      toMilliseconds(t.uploadDuration),
+		UploadSize:      t.uploadSize.Load(),
+		ArtifactSizes:   t.fileSizes,
+		Outcome:         t.outcome,
+		ErrorClass:      t.errorClass,
+	}
+
+	rawStartTime := t.startTime.Load()
+	if rawStartTime == 0 {
+		if !t.queueAddTime.IsZero() {
+			record.QueueTime = toMilliseconds(now.Sub(t.queueAddTime))
+		}
+		return record
+	}
+
+	startTime := time.Unix(0, rawStartTime)
+	record.QueueTime = toMilliseconds(startTime.Sub(t.queueAddTime))
+
+	rawCompleteTime := t.completeTime.Load()
+	if rawCompleteTime == 0 {
+		record.BuildTime = toMilliseconds(now.Sub(startTime))
+	} else {
+		completeTime := time.Unix(0, rawCompleteTime)
+		record.BuildTime = toMilliseconds(completeTime.Sub(startTime))
+		if t.outcome == buildHistory.OutcomeSuccess {
+			record.DownloadTime = toMilliseconds(now.Sub(completeTime))
+		}
+	}
+	return record
+}
+
+// writeHistory is called when request handling is finished (artifacts are downloaded or job failed)
+func (t *BuildHandler) writeHistory(buildJob *BuildJob) {
+	if t.historySink == nil {
+		return
+	}
+
+	record := buildJob.getHistoryRecord(time.Now())
+	err := t.historySink.Write(record)
+	if err != nil {
+		buildJob.logger.Error("cannot write build history", zap.Error(err))
+	}
+}
+
+func toSortedList(set map[string]bool) []string {
+	result := make([]string, 0, len(set))
+	for key := range set {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func toMilliseconds(duration time.Duration) int64 {
+	return int64(duration / time.Millisecond)
+}
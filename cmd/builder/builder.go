@@ -4,27 +4,34 @@ import (
 	"fmt"
 	"github.com/develar/app-builder/pkg/util"
 	"go.uber.org/atomic"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"time"
 
-	"github.com/develar/app-builder/pkg/download"
 	l "github.com/develar/app-builder/pkg/log"
 	"github.com/develar/errors"
 	"github.com/didip/tollbooth"
-	"github.com/didip/tollbooth/limiter"
 	"github.com/electronuserland/electron-build-service/internal"
+	"github.com/electronuserland/electron-build-service/internal/config"
+	"github.com/electronuserland/electron-build-service/internal/gopool"
+	"github.com/electronuserland/electron-build-service/internal/health"
+	"github.com/electronuserland/electron-build-service/internal/router"
+	"github.com/electronuserland/electron-build-service/internal/schema"
+	"github.com/electronuserland/electron-build-service/internal/tracing"
 	"github.com/mitchellh/go-homedir"
 	"go.uber.org/zap"
 )
 
 func main() {
-	logger := internal.CreateLogger()
+	configManager, err := config.NewManager(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	logger := internal.CreateLogger(configManager.Get().LogEncoding)
 	l.LOG = logger
 	defer func() {
 		err := logger.Sync()
@@ -33,24 +40,27 @@ func main() {
 		}
 	}()
 
-	err := start(logger)
+	err = start(configManager, logger)
 	if err != nil {
 		logger.Fatal("cannot start", zap.Error(err))
 	}
 }
 
-func start(logger *zap.Logger) error {
-	builderTmpDir, err := getBuilderTmpDir()
+func start(configManager *config.Manager, logger *zap.Logger) error {
+	configuration := configManager.Get()
+
+	shutdownTracing, err := tracing.Configure("electron-build-service", logger)
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	defer shutdownTracing()
 
-	zstdPath, err := download.DownloadZstd(util.GetCurrentOs())
+	builderTmpDir, err := getBuilderTmpDir(configuration.TmpDir)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	scriptPath := os.Getenv("BUILDER_NODE_MODULES")
+	scriptPath := configuration.NodeModules
 	if scriptPath == "" {
 		executableFile, err := os.Executable()
 		if err != nil {
@@ -60,12 +70,16 @@ func start(logger *zap.Logger) error {
 	}
 
 	buildHandler := &BuildHandler{
+		configManager:   configManager,
 		logger:          logger,
 		stageDir:        internal.GetBuilderDirectory("stage"),
 		tempDir:         builderTmpDir,
-		zstdPath:        filepath.Join(zstdPath, "zstd"),
 		scriptPath:      filepath.Join(scriptPath, "node_modules/app-builder-lib/out/remoteBuilder/builder-cli.js"),
 		runningJobCount: atomic.NewInt32(0),
+
+		buildDurationStats: NewBuildDurationStats(),
+		jobs:               make(map[string]*BuildJob),
+		uploadingJobs:      make(map[string]*BuildJob),
 	}
 
 	err = buildHandler.PrepareDirs()
@@ -73,37 +87,97 @@ func start(logger *zap.Logger) error {
 		return errors.WithStack(err)
 	}
 
-	buildHandler.CreateAndStartQueue(runtime.NumCPU() + 1)
+	buildHandler.diskSpaceReservation = NewDiskSpaceReservation(buildHandler.stageDir)
 
-	buildLimit := tollbooth.NewLimiter(1, &limiter.ExpirableOptions{DefaultExpirationTTL: time.Hour})
-	buildLimit.SetBurst(10)
+	buildHandler.provenanceSigner, err = createProvenanceSigner(configuration.ProvenanceKey, buildHandler.scriptPath, logger)
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-	// client uses app-builder downloader that does parallel requests, so, limit is soft
-	downloadLimit := tollbooth.NewLimiter(10, &limiter.ExpirableOptions{DefaultExpirationTTL: time.Hour})
-	downloadLimit.SetBurst(100)
+	buildHandler.historySink, err = createHistorySink(configuration.HistoryDb)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if buildHandler.historySink != nil {
+		defer util.Close(buildHandler.historySink)
+	}
+
+	queue, err := createQueue(configManager)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	workerCount := configuration.WorkerCount
+	buildHandler.CreateAndStartQueue(workerCount, queue)
+
+	buildLimit := internal.CreateRateLimiter(configuration.RateLimits.Build)
+	downloadLimit := internal.CreateRateLimiter(configuration.RateLimits.Download)
+	jobLimit := internal.CreateRateLimiter(configuration.RateLimits.Job)
 
 	http.Handle("/v2/build", tollbooth.LimitFuncHandler(buildLimit, buildHandler.HandleBuildRequest))
 	http.Handle(baseDownloadPath, tollbooth.LimitFuncHandler(downloadLimit, buildHandler.HandleDownloadRequest))
-
-	port := internal.GetListenPort("BUILDER_PORT")
-	server := internal.ListenAndServe(port, logger)
+	http.Handle(baseJobPath, tollbooth.LimitFuncHandler(jobLimit, buildHandler.HandleJobRequest))
+	http.HandleFunc(schema.BuildRequestSchemaPath, handleBuildRequestSchemaRequest)
+
+	configManager.OnReload(func(configuration *config.Config) {
+		buildHandler.pool.SetJobMaxTime(time.Duration(configuration.JobMaxTime))
+		internal.ApplyRateLimit(buildLimit, configuration.RateLimits.Build)
+		internal.ApplyRateLimit(downloadLimit, configuration.RateLimits.Download)
+		internal.ApplyRateLimit(jobLimit, configuration.RateLimits.Job)
+	})
+
+	buildHandler.configureAdmin(http.DefaultServeMux)
+
+	checker := health.NewChecker()
+	buildHandler.addReadinessChecks(checker)
+
+	port := configuration.Port
+	server := internal.ListenAndServe(internal.ServerOptions{
+		Port:         port,
+		UseSsl:       configuration.UseSsl,
+		ClientCaFile: configuration.TlsClientCa,
+		Health:       checker,
+	}, logger)
 
 	disposer := NewDisposer()
 	defer disposer.Dispose()
 
+	disposer.Add(configManager.WatchSignal(logger))
+
+	// withdraw agent entry while not ready - started after registration (address is resolved), but stopped before agent entry removal on shutdown
+	var stopWatchingReadiness func()
+	disposer.Add(func() {
+		if stopWatchingReadiness != nil {
+			stopWatchingReadiness()
+		}
+	})
+
 	err = buildHandler.RegisterAgent(port, disposer)
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	stopWatchingReadiness = buildHandler.watchReadiness(checker)
 
-	configureRouter(logger, disposer)
+	// decrease worker count on memory or disk pressure
+	disposer.Add(NewCapacityController(buildHandler, workerCount).Start())
+
+	if configuration.Router {
+		agentRegistry, err := router.Configure(configManager, checker, false, logger)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		disposer.Add(func() {
+			util.Close(agentRegistry)
+		})
+	} else {
+		logger.Info("routing is disabled", zap.String("reason", "dedicated router is used"))
+	}
 
 	logger.Info("started",
 		zap.String("port", port),
 		zap.String("stage dir", buildHandler.stageDir),
 		zap.String("temp dir", buildHandler.tempDir),
-		zap.String("etcdKey", buildHandler.agentEntry.Key),
-		zap.String("zstdPath", buildHandler.zstdPath),
+		zap.String("etcdKey", buildHandler.agentKey),
 		zap.String("scriptPath", buildHandler.scriptPath),
 	)
 
@@ -117,9 +191,41 @@ func start(logger *zap.Logger) error {
 	return nil
 }
 
-func getBuilderTmpDir() (string, error) {
-	builderTmpDir := os.Getenv("APP_BUILDER_TMP_DIR")
+// published schema of x-build-request (clients can validate request before upload)
+func handleBuildRequestSchemaRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET supported", http.StatusMethodNotAllowed)
+		return
+	}
 
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	_, _ = w.Write([]byte(schema.BuildRequestSchema))
+}
+
+// scheduling policy: "priority" or "fair" (fair share across clients, to not allow one client to starve everyone else)
+func createQueue(configManager *config.Manager) (gopool.Queue, error) {
+	policy := configManager.Get().SchedulingPolicy
+	switch policy {
+	case "priority":
+		return gopool.NewPriorityQueue(), nil
+	case "fair":
+		// weights are live-reloadable, so, current configuration is used on each call
+		weight := func(tenant string) float64 {
+			value, ok := configManager.Get().TenantWeights[tenant]
+			if !ok {
+				return 1
+			}
+			return value
+		}
+		// waiting for a minute is equivalent to one job done for the client
+		return gopool.NewFairQueue(weight, 1*time.Minute), nil
+	default:
+		return nil, fmt.Errorf("unknown scheduling policy: %s", policy)
+	}
+}
+
+func getBuilderTmpDir(builderTmpDir string) (string, error) {
 	if builderTmpDir == "" {
 		builderTmpDir = internal.GetBuilderDirectory("tmp")
 	} else {
@@ -129,51 +235,9 @@ func getBuilderTmpDir() (string, error) {
 		}
 
 		if builderTmpDir == os.TempDir() || strings.HasPrefix(homeDir, builderTmpDir) || builderTmpDir == "/" {
-			return "", fmt.Errorf("%s cannot be used as APP_BUILDER_TMP_DIR because this dir will be emptied", builderTmpDir)
+			return "", fmt.Errorf("%s cannot be used as tmp dir (APP_BUILDER_TMP_DIR) because this dir will be emptied", builderTmpDir)
 		}
 	}
 
 	return builderTmpDir, nil
 }
-
-func getAgentKey(port string, logger *zap.Logger) (string, error) {
-	ip, err := getExternalPublicIp(logger)
-	if err != nil {
-		return "", errors.WithStack(err)
-	}
-	return ip + ":" + port, nil
-}
-
-func getExternalPublicIp(logger *zap.Logger) (string, error) {
-	explicit := os.Getenv("BUILDER_HOST")
-	if explicit != "" {
-		explicit = strings.TrimSpace(explicit)
-		logger.Debug("host specified explicitly via env", zap.String("host", explicit))
-		return explicit, nil
-	}
-
-	ipType := ""
-	preferredIpVersion := os.Getenv("PREFERRED_IP_VERSION")
-	if len(preferredIpVersion) == 1 {
-		ipType = "ipv" + preferredIpVersion + "."
-	}
-
-	//noinspection SpellCheckingInspection
-	url := "https://" + ipType + "myexternalip.com/raw"
-	logger.Debug("get external ip", zap.String("url", url))
-	response, err := http.Get(url)
-	if err != nil {
-		return "", errors.WithStack(err)
-	}
-
-	responseBytes, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return "", errors.WithStack(err)
-	}
-
-	responseText := string(responseBytes)
-	if response.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("cannot get external public ip - status: %d, response: %s", response.StatusCode, responseText)
-	}
-	return strings.TrimSpace(responseText), nil
-}
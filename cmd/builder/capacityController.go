@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	"go.uber.org/zap"
+)
+
+const capacityCheckInterval = 10 * time.Second
+
+// snap builds on RAM disk can take a lot of memory, so, worker count is decreased if free memory or disk space is less than threshold
+const minFreeMemory = 1024 * 1024 * 1024
+const minFreeDiskSpace = 2 * 1024 * 1024 * 1024
+
+// worker count is increased only if free memory and disk space are greater than threshold * factor (to not change worker count back and forth)
+const capacityRecoveryFactor = 2
+
+// CapacityController adjusts worker count according to memory and disk pressure and advertises effective capacity to the registry.
+type CapacityController struct {
+	handler *BuildHandler
+
+	maxWorkerCount int
+	dirs           []string
+
+	logger *zap.Logger
+}
+
+func NewCapacityController(handler *BuildHandler, maxWorkerCount int) *CapacityController {
+	return &CapacityController{
+		handler:        handler,
+		maxWorkerCount: maxWorkerCount,
+		dirs:           []string{handler.tempDir, handler.stageDir},
+		logger:         handler.logger.Named("capacity"),
+	}
+}
+
+// Start returns function to stop controller.
+func (t *CapacityController) Start() func() {
+	ticker := time.NewTicker(capacityCheckInterval)
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				t.check()
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(stop)
+	}
+}
+
+func (t *CapacityController) check() {
+	pool := t.handler.pool
+	current := pool.GetWorkerCount()
+
+	pressure := t.getPressure()
+	newCount := current
+	if pressure == pressureHigh && current > 1 {
+		newCount = current - 1
+	} else if pressure == pressureNone && current < t.maxWorkerCount {
+		newCount = current + 1
+	}
+
+	if newCount == current {
+		return
+	}
+
+	pool.SetWorkerCount(newCount)
+
+	// router computes agent weight using cpu count, so, advertise cpu count proportionally to effective worker count
+	t.handler.setAgentCapacity(runtime.NumCPU() * newCount / t.maxWorkerCount)
+}
+
+type pressureLevel int
+
+const (
+	// free resources are greater than threshold * capacityRecoveryFactor
+	pressureNone pressureLevel = iota
+	// free resources are between threshold and threshold * capacityRecoveryFactor
+	pressureModerate
+	// free resources are less than threshold
+	pressureHigh
+)
+
+func (t *CapacityController) getPressure() pressureLevel {
+	result := pressureNone
+
+	freeMemory, err := getAvailableMemory()
+	if err != nil {
+		t.logger.Debug("cannot get available memory", zap.Error(err))
+	} else {
+		result = maxPressure(result, computePressure(freeMemory, minFreeMemory))
+		if result == pressureHigh {
+			t.logger.Warn("memory pressure", zap.Uint64("available", freeMemory))
+		}
+	}
+
+	for _, dir := range t.dirs {
+		freeSpace, err := getFreeDiskSpace(dir)
+		if err != nil {
+			t.logger.Error("cannot get free disk space", zap.String("dir", dir), zap.Error(err))
+			continue
+		}
+
+		pressure := computePressure(freeSpace, minFreeDiskSpace)
+		if pressure == pressureHigh {
+			t.logger.Warn("disk pressure", zap.String("dir", dir), zap.Uint64("available", freeSpace))
+		}
+		result = maxPressure(result, pressure)
+	}
+	return result
+}
+
+func computePressure(free uint64, threshold uint64) pressureLevel {
+	if free < threshold {
+		return pressureHigh
+	} else if free < threshold*capacityRecoveryFactor {
+		return pressureModerate
+	} else {
+		return pressureNone
+	}
+}
+
+func maxPressure(a pressureLevel, b pressureLevel) pressureLevel {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func getFreeDiskSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(dir, &stat)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// linux only (MemAvailable from /proc/meminfo), error on other OS
+func getAvailableMemory() (uint64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	defer util.Close(file)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "MemAvailable:") {
+			continue
+		}
+
+		// MemAvailable:   12345678 kB
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			break
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		return value * 1024, nil
+	}
+
+	if scanner.Err() != nil {
+		return 0, errors.WithStack(scanner.Err())
+	}
+	return 0, errors.New("MemAvailable is not found in /proc/meminfo")
+}
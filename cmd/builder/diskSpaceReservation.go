@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/develar/errors"
+	"go.uber.org/zap"
+)
+
+// unpacked project, electron and artifacts take more space than uploaded compressed archive
+const reservedDiskSpacePerUploadedByte = 4
+
+// used if client doesn't specify expected upload size
+const defaultExpectedUploadSize = 128 * 1024 * 1024
+
+var errInsufficientDiskSpace = errors.New("not enough free disk space to accept build")
+
+// upload is larger than declared (space is reserved for the declared size) or than max request body
+var errUploadTooLarge = errors.New("upload is too large")
+
+// DiskSpaceReservation tracks disk space reserved by accepted, but not yet cleaned up jobs.
+// Reserved space is not adjusted when files are actually written, so, estimation is pessimistic.
+type DiskSpaceReservation struct {
+	dir      string
+	reserved uint64
+	lock     sync.Mutex
+
+	getFreeSpace func(dir string) (uint64, error)
+}
+
+func NewDiskSpaceReservation(dir string) *DiskSpaceReservation {
+	return &DiskSpaceReservation{
+		dir:          dir,
+		getFreeSpace: getFreeDiskSpace,
+	}
+}
+
+// Reserve returns errInsufficientDiskSpace if space cannot be reserved. Returned release function can be called several times.
+func (t *DiskSpaceReservation) Reserve(size uint64) (func(), error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	free, err := t.getFreeSpace(t.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if free < minFreeDiskSpace || free-minFreeDiskSpace < t.reserved+size {
+		return nil, errInsufficientDiskSpace
+	}
+
+	t.reserved += size
+
+	var releaseOnce sync.Once
+	return func() {
+		releaseOnce.Do(func() {
+			t.lock.Lock()
+			defer t.lock.Unlock()
+			t.reserved -= size
+		})
+	}, nil
+}
+
+func (t *DiskSpaceReservation) GetReserved() uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.reserved
+}
+
+// reserveDiskSpace writes error response and returns nil if space cannot be reserved.
+// Returned max upload size is the declared size, because space is reserved for it (max request body if size is not declared).
+func (t *BuildHandler) reserveDiskSpace(w http.ResponseWriter, r *http.Request, logger *zap.Logger) (func(), int64) {
+	maxRequestBody := t.configManager.Get().MaxRequestBody
+	maxUploadSize := maxRequestBody
+	expectedSize := uint64(defaultExpectedUploadSize)
+	rawExpectedSize := r.Header.Get("x-expected-size")
+	if rawExpectedSize != "" {
+		var err error
+		expectedSize, err = strconv.ParseUint(rawExpectedSize, 10, 64)
+		if err != nil {
+			writeJsonError(w, http.StatusBadRequest, "header x-expected-size is not a valid number", "invalidExpectedSize")
+			return nil, 0
+		}
+
+		if expectedSize > uint64(maxRequestBody) {
+			writeJsonError(w, http.StatusRequestEntityTooLarge, "upload is too large", "uploadTooLarge")
+			return nil, 0
+		}
+		maxUploadSize = int64(expectedSize)
+	}
+
+	release, err := t.diskSpaceReservation.Reserve(expectedSize * reservedDiskSpacePerUploadedByte)
+	if err == nil {
+		return release, maxUploadSize
+	}
+
+	if err == errInsufficientDiskSpace {
+		logger.Warn("reject build", zap.Error(err), zap.Uint64("expectedSize", expectedSize), zap.Uint64("reserved", t.diskSpaceReservation.GetReserved()))
+		writeJsonError(w, http.StatusInsufficientStorage, err.Error(), "insufficientDiskSpace")
+	} else {
+		logger.Error("cannot reserve disk space", zap.Error(err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+	return nil, 0
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/electronuserland/electron-build-service/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
@@ -14,7 +17,21 @@ func (t *BuildHandler) HandleDownloadRequest(w http.ResponseWriter, r *http.Requ
 	jobId := r.URL.Path[len(baseDownloadPath):]
 	jobId = jobId[0:strings.Index(jobId, "/")]
 
+	_, span := tracing.StartServerSpan(r, "download")
+	span.SetAttributes(attribute.String("job.id", jobId), attribute.String("http.range", r.Header.Get("Range")))
+	defer span.End()
+
 	t.logger.Debug("download", zap.String("file", r.URL.Path), zap.String("range", r.Header.Get("Range")))
-	file := filepath.Join(t.stageDir, jobId, outDirName, r.URL.Path[(len(baseDownloadPath)+len(jobId)+1 /* slash */):])
+	name := r.URL.Path[(len(baseDownloadPath) + len(jobId) + 1 /* slash */):]
+	if name == provenanceFileName {
+		// attestation is stored outside of out dir (see ProvenanceSigner)
+		file := filepath.Join(t.stageDir, jobId, provenanceFileName)
+		if _, err := os.Stat(file); err == nil {
+			http.ServeFile(w, r, file)
+			return
+		}
+	}
+
+	file := filepath.Join(t.stageDir, jobId, outDirName, name)
 	http.ServeFile(w, r, file)
 }
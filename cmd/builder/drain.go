@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/develar/errors"
+	"github.com/electronuserland/electron-build-service/internal"
+	"go.uber.org/zap"
+)
+
+// in drain mode agent doesn't accept new builds, but lets accepted jobs to complete until deadline
+type drainState struct {
+	isDraining bool
+	startTime  time.Time
+	deadline   time.Time
+	// aborts remaining jobs on deadline
+	timer *time.Timer
+}
+
+type DrainStatus struct {
+	IsDraining bool       `json:"draining"`
+	StartTime  *time.Time `json:"startTime,omitempty"`
+	Deadline   *time.Time `json:"deadline,omitempty"`
+
+	// uploading, queued or building
+	ActiveJobs  int `json:"activeJobs"`
+	PendingJobs int `json:"pendingJobs"`
+	// active jobs and jobs whose artifacts are being downloaded
+	TrackedJobs int `json:"trackedJobs"`
+
+	// true if all accepted jobs are built
+	IsCompleted bool `json:"completed"`
+}
+
+func (t *BuildHandler) isDraining() bool {
+	t.drainLock.Lock()
+	defer t.drainLock.Unlock()
+	return t.drain.isDraining
+}
+
+func (t *BuildHandler) startDrain(timeout time.Duration) {
+	t.drainLock.Lock()
+	defer t.drainLock.Unlock()
+
+	now := time.Now()
+	if t.drain.isDraining {
+		// deadline can be changed
+		t.drain.timer.Stop()
+	} else {
+		t.drain.isDraining = true
+		t.drain.startTime = now
+		t.unregisterAgent()
+	}
+
+	t.drain.deadline = now.Add(timeout)
+	t.drain.timer = time.AfterFunc(timeout, t.abortRemainingJobs)
+	t.logger.Info("drain started", zap.Duration("timeout", timeout), zap.Int("activeJobs", int(t.runningJobCount.Load())))
+}
+
+func (t *BuildHandler) stopDrain() error {
+	t.drainLock.Lock()
+	defer t.drainLock.Unlock()
+
+	if !t.drain.isDraining {
+		return nil
+	}
+
+	err := t.registerAgent()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	t.drain.timer.Stop()
+	t.drain = drainState{}
+	t.logger.Info("drain stopped")
+	return nil
+}
+
+func (t *BuildHandler) abortRemainingJobs() {
+	// uploads are aborted too - otherwise client can keep agent busy after deadline by slow upload
+	t.jobsLock.RLock()
+	jobs := make([]*BuildJob, 0, len(t.jobs)+len(t.uploadingJobs))
+	for _, job := range t.uploadingJobs {
+		jobs = append(jobs, job)
+	}
+	for _, job := range t.jobs {
+		jobs = append(jobs, job)
+	}
+	t.jobsLock.RUnlock()
+
+	t.logger.Warn("drain deadline exceeded, abort remaining jobs", zap.Int("count", len(jobs)))
+	for _, job := range jobs {
+		job.Cancel()
+	}
+}
+
+func (t *BuildHandler) getDrainStatus() DrainStatus {
+	t.drainLock.Lock()
+	state := t.drain
+	t.drainLock.Unlock()
+
+	t.jobsLock.RLock()
+	trackedJobs := len(t.jobs)
+	t.jobsLock.RUnlock()
+
+	activeJobs := int(t.runningJobCount.Load())
+	status := DrainStatus{
+		IsDraining:  state.isDraining,
+		ActiveJobs:  activeJobs,
+		PendingJobs: t.pool.GetPendingJobCount(),
+		TrackedJobs: trackedJobs,
+		IsCompleted: state.isDraining && activeJobs == 0,
+	}
+	if state.isDraining {
+		status.StartTime = &state.startTime
+		status.Deadline = &state.deadline
+	}
+	return status
+}
+
+// HandleDrainRequest handles /admin/drain:
+// GET - drain status, POST - start drain (optional query parameter timeout, e.g. 10m), DELETE - stop drain.
+func (t *BuildHandler) HandleDrainRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		// status only
+
+	case http.MethodPost:
+		timeout := time.Duration(t.configManager.Get().JobMaxTime)
+		rawTimeout := r.URL.Query().Get("timeout")
+		if rawTimeout != "" {
+			var err error
+			timeout, err = time.ParseDuration(rawTimeout)
+			if err != nil || timeout <= 0 {
+				writeJsonError(w, http.StatusBadRequest, "timeout is not a valid duration", "invalidTimeout")
+				return
+			}
+		}
+		t.startDrain(timeout)
+
+	case http.MethodDelete:
+		err := t.stopDrain()
+		if err != nil {
+			t.logger.Error("cannot stop drain", zap.Error(err))
+			writeJsonError(w, http.StatusInternalServerError, "cannot register agent", "registrationFailed")
+			return
+		}
+
+	default:
+		http.Error(w, "only GET, POST and DELETE supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	internal.WriteJson(w, t.getDrainStatus(), t.logger)
+}
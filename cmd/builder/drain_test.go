@@ -0,0 +1,217 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/electronuserland/electron-build-service/internal/config"
+	"github.com/electronuserland/electron-build-service/internal/gopool"
+	"github.com/json-iterator/go"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+const testAdminToken = "admin-secret"
+
+const testBuildRequest = `{"platform":"linux","targets":[{"name":"deb","arch":"x64","unpackedDirName":"linux-unpacked"}]}`
+
+// createTestConfigManager creates manager with configuration from the given flags only (env is ignored)
+func createTestConfigManager(t *testing.T, args ...string) *config.Manager {
+	configManager, err := config.NewManager(args, func(string) (string, bool) {
+		return "", false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return configManager
+}
+
+// createTestBuildHandler creates handler with admin API enabled, agent is not registered (agent key is empty)
+func createTestBuildHandler(t *testing.T) (*BuildHandler, *http.ServeMux) {
+	configManager := createTestConfigManager(t, "-admin-token", testAdminToken)
+
+	stageDir, err := ioutil.TempDir("", "stage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(stageDir)
+	})
+
+	handler := &BuildHandler{
+		configManager:   configManager,
+		logger:          zap.NewNop(),
+		stageDir:        stageDir,
+		runningJobCount: atomic.NewInt32(0),
+
+		buildDurationStats: NewBuildDurationStats(),
+		diskSpaceReservation: &DiskSpaceReservation{
+			dir: stageDir,
+			getFreeSpace: func(dir string) (uint64, error) {
+				return math.MaxUint64, nil
+			},
+		},
+		jobs:          make(map[string]*BuildJob),
+		uploadingJobs: make(map[string]*BuildJob),
+	}
+	handler.CreateAndStartQueue(1, gopool.NewPriorityQueue())
+	t.Cleanup(handler.queueCancel)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/build", handler.HandleBuildRequest)
+	mux.HandleFunc(baseJobPath, handler.HandleJobRequest)
+	handler.configureAdmin(mux)
+	return handler, mux
+}
+
+// serve sends request authorized by the given admin token (not authorized if empty)
+func serve(mux *http.ServeMux, method string, path string, adminToken string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if adminToken != "" {
+		r.Header.Set("Authorization", "Bearer "+adminToken)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+func readDrainStatus(t *testing.T, w *httptest.ResponseRecorder) DrainStatus {
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d %s", w.Code, w.Body.String())
+	}
+
+	var status DrainStatus
+	err := jsoniter.ConfigFastest.Unmarshal(w.Body.Bytes(), &status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func getUploadingJobCount(handler *BuildHandler) int {
+	handler.jobsLock.RLock()
+	defer handler.jobsLock.RUnlock()
+	return len(handler.uploadingJobs)
+}
+
+func TestDrainRequestIsAuthorized(t *testing.T) {
+	handler, mux := createTestBuildHandler(t)
+
+	for _, token := range []string{"", "wrong"} {
+		w := serve(mux, http.MethodPost, "/admin/drain", token)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%q: unauthorized request must be rejected: %d", token, w.Code)
+		}
+	}
+
+	if handler.isDraining() {
+		t.Errorf("drain must be not started by unauthorized request")
+	}
+}
+
+func TestDrainRequest(t *testing.T) {
+	handler, mux := createTestBuildHandler(t)
+
+	status := readDrainStatus(t, serve(mux, http.MethodGet, "/admin/drain", testAdminToken))
+	if status.IsDraining || status.Deadline != nil || status.IsCompleted {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	w := serve(mux, http.MethodPost, "/admin/drain?timeout=soon", testAdminToken)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalidTimeout") {
+		t.Errorf("invalid timeout must be rejected: %d %s", w.Code, w.Body.String())
+	}
+
+	status = readDrainStatus(t, serve(mux, http.MethodPost, "/admin/drain?timeout=1h", testAdminToken))
+	if !status.IsDraining || status.Deadline == nil || !status.IsCompleted {
+		t.Fatalf("drain must be started: %+v", status)
+	}
+	deadline := *status.Deadline
+
+	// new builds are rejected
+	w = serve(mux, http.MethodPost, "/v2/build", "")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "draining") {
+		t.Errorf("build must be rejected in drain mode: %d %s", w.Code, w.Body.String())
+	}
+
+	// deadline can be changed
+	status = readDrainStatus(t, serve(mux, http.MethodPost, "/admin/drain?timeout=2h", testAdminToken))
+	if !status.Deadline.After(deadline) {
+		t.Errorf("deadline must be changed: %v, previous %v", status.Deadline, deadline)
+	}
+
+	status = readDrainStatus(t, serve(mux, http.MethodDelete, "/admin/drain", testAdminToken))
+	if status.IsDraining || handler.isDraining() {
+		t.Errorf("drain must be stopped: %+v", status)
+	}
+
+	// accepted again (rejected only because build request is not specified)
+	w = serve(mux, http.MethodPost, "/v2/build", "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("build must be accepted after drain: %d %s", w.Code, w.Body.String())
+	}
+
+	w = serve(mux, http.MethodPut, "/admin/drain", testAdminToken)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status: %d", w.Code)
+	}
+}
+
+func TestDrainDeadlineAbortsUpload(t *testing.T) {
+	handler, mux := createTestBuildHandler(t)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// client starts upload, but doesn't send anything (upload is stalled)
+	bodyReader, bodyWriter := io.Pipe()
+	defer bodyWriter.Close()
+
+	request, err := http.NewRequest(http.MethodPost, server.URL+"/v2/build", bodyReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("x-build-request", testBuildRequest)
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		response, err := server.Client().Do(request)
+		if err != nil {
+			t.Error(err)
+			close(responses)
+			return
+		}
+		responses <- response
+	}()
+
+	// job is not yet added to queue, but must be aborted on deadline
+	for start := time.Now(); getUploadingJobCount(handler) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("upload is not started")
+		}
+	}
+	handler.startDrain(10 * time.Millisecond)
+	defer func() {
+		_ = handler.stopDrain()
+	}()
+
+	select {
+	case response, ok := <-responses:
+		if !ok {
+			return
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		_ = response.Body.Close()
+		if response.StatusCode != http.StatusServiceUnavailable || !strings.Contains(string(body), "cancelled") {
+			t.Errorf("upload must be aborted: %d %s", response.StatusCode, body)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("upload is not aborted on drain deadline")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/tomasen/realip"
+	"go.uber.org/zap"
+)
+
+const baseJobPath = "/v2/jobs/"
+
+func (t *BuildHandler) addJob(buildJob *BuildJob) {
+	t.jobsLock.Lock()
+	defer t.jobsLock.Unlock()
+
+	t.jobs[buildJob.id] = buildJob
+	jobPublisher := t.getJobPublisher()
+	if jobPublisher != nil {
+		jobPublisher.Publish(buildJob.GetSummary())
+	}
+}
+
+func (t *BuildHandler) removeJob(buildJob *BuildJob) {
+	t.jobsLock.Lock()
+	defer t.jobsLock.Unlock()
+
+	delete(t.jobs, buildJob.id)
+	jobPublisher := t.getJobPublisher()
+	if jobPublisher != nil {
+		jobPublisher.Remove(buildJob.id)
+	}
+}
+
+func (t *BuildHandler) addUploadingJob(buildJob *BuildJob) {
+	t.jobsLock.Lock()
+	defer t.jobsLock.Unlock()
+	t.uploadingJobs[buildJob.id] = buildJob
+}
+
+func (t *BuildHandler) removeUploadingJob(buildJob *BuildJob) {
+	t.jobsLock.Lock()
+	defer t.jobsLock.Unlock()
+	delete(t.uploadingJobs, buildJob.id)
+}
+
+// publishJob publishes job summary to the registry on job state change (to allow router to find which agent owns job).
+// Publish is performed under lock and only for registered job to ensure that removed job will be not published again.
+func (t *BuildHandler) publishJob(buildJob *BuildJob) {
+	jobPublisher := t.getJobPublisher()
+	if jobPublisher == nil {
+		return
+	}
+
+	t.jobsLock.RLock()
+	defer t.jobsLock.RUnlock()
+
+	if t.jobs[buildJob.id] == buildJob {
+		jobPublisher.Publish(buildJob.GetSummary())
+	}
+}
+
+func (t *BuildHandler) getJob(jobId string) *BuildJob {
+	t.jobsLock.RLock()
+	defer t.jobsLock.RUnlock()
+	return t.jobs[jobId]
+}
+
+// HandleJobRequest handles job control requests - /v2/jobs/{id}/cancel.
+// Request must be authorized by job token (header x-job-token), that is sent to client in the build response headers.
+// Completed or already cancelled job cannot be cancelled (409).
+func (t *BuildHandler) HandleJobRequest(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path[len(baseJobPath):], "/"), "/")
+	if len(path) != 2 || path[1] != "cancel" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "only POST supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobId := path[0]
+	logger := t.logger.With(zap.String("jobId", jobId), zap.String("ip", realip.FromRequest(r)))
+
+	buildJob := t.getJob(jobId)
+	if buildJob == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	token := r.Header.Get("x-job-token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(buildJob.token)) != 1 {
+		logger.Warn("cannot cancel job", zap.String("reason", "invalid token"))
+		http.Error(w, "invalid job token", http.StatusForbidden)
+		return
+	}
+
+	// result is already sent to client, artifacts are being downloaded
+	if buildJob.completeTime.Load() != 0 {
+		writeJsonError(w, http.StatusConflict, "job is already completed", "alreadyCompleted")
+		return
+	}
+
+	if !buildJob.Cancel() {
+		writeJsonError(w, http.StatusConflict, "job is already cancelled", "alreadyCancelled")
+		return
+	}
+
+	logger.Info("job cancel requested")
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(`{"status": "cancelled"}`))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sendCancelRequest sends cancel request authorized by the given job token (not authorized if empty)
+func sendCancelRequest(mux *http.ServeMux, method string, path string, jobToken string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if jobToken != "" {
+		r.Header.Set("x-job-token", jobToken)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+func TestCancelJob(t *testing.T) {
+	handler, mux := createTestBuildHandler(t)
+	_, ctx := addTestJob(handler, "job1", "job-secret")
+	addTestJob(handler, "job2", "other-secret")
+
+	// token of another job and admin token are not accepted
+	for _, token := range []string{"", "wrong", "other-secret", testAdminToken} {
+		w := sendCancelRequest(mux, http.MethodPost, "/v2/jobs/job1/cancel", token)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%q: unauthorized request must be rejected: %d", token, w.Code)
+		}
+	}
+	if ctx.Err() != nil {
+		t.Fatal("job must be not cancelled by unauthorized request")
+	}
+
+	for _, path := range []string{"/v2/jobs/unknown/cancel", "/v2/jobs/job1", "/v2/jobs/job1/kill", "/v2/jobs/job1/cancel/now"} {
+		w := sendCancelRequest(mux, http.MethodPost, path, "job-secret")
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: not found expected: %d", path, w.Code)
+		}
+	}
+
+	w := sendCancelRequest(mux, http.MethodGet, "/v2/jobs/job1/cancel", "job-secret")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status: %d", w.Code)
+	}
+
+	w = sendCancelRequest(mux, http.MethodPost, "/v2/jobs/job1/cancel", "job-secret")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "cancelled") {
+		t.Errorf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	if ctx.Err() == nil {
+		t.Errorf("job context must be cancelled")
+	}
+
+	w = sendCancelRequest(mux, http.MethodPost, "/v2/jobs/job1/cancel", "job-secret")
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "alreadyCancelled") {
+		t.Errorf("already cancelled job must be reported: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCancelCompletedJob(t *testing.T) {
+	handler, mux := createTestBuildHandler(t)
+	buildJob, ctx := addTestJob(handler, "job1", "job-secret")
+	buildJob.startTime.Store(time.Now().UnixNano())
+	buildJob.completeTime.Store(time.Now().UnixNano())
+
+	// artifacts are being downloaded
+	w := sendCancelRequest(mux, http.MethodPost, "/v2/jobs/job1/cancel", "job-secret")
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "alreadyCompleted") {
+		t.Errorf("completed job must be not cancelled: %d %s", w.Code, w.Body.String())
+	}
+	if ctx.Err() != nil {
+		t.Errorf("download must be not aborted")
+	}
+}
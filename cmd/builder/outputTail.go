@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+const maxOutputTailSize = 64 * 1024
+
+// outputTail keeps the last lines of builder output (to inspect running or hung job).
+type outputTail struct {
+	data    []byte
+	maxSize int
+	lock    sync.Mutex
+}
+
+func newOutputTail(maxSize int) *outputTail {
+	return &outputTail{maxSize: maxSize}
+}
+
+func (t *outputTail) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.data = append(t.data, p...)
+	if len(t.data) > t.maxSize {
+		overflow := t.data[len(t.data)-t.maxSize:]
+		// do not keep partial line
+		newLineIndex := bytes.IndexByte(overflow, '\n')
+		if newLineIndex >= 0 && newLineIndex < len(overflow)-1 {
+			overflow = overflow[newLineIndex+1:]
+		}
+		t.data = append([]byte(nil), overflow...)
+	}
+	return len(p), nil
+}
+
+func (t *outputTail) Bytes() []byte {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]byte(nil), t.data...)
+}
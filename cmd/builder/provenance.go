@@ -0,0 +1,335 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/develar/app-builder/pkg/download"
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	"github.com/json-iterator/go"
+	"go.uber.org/zap"
+)
+
+const provenanceFileName = "provenance.json"
+
+const inTotoPayloadType = "application/vnd.in-toto+json"
+
+// ProvenanceSigner creates in-toto attestations (SLSA provenance) for build results.
+// Attestation is written to job dir (not to out dir, to not overwrite or be confused with artifact),
+// and is served at /v2/download/{jobId}/provenance.json (as artifacts, until client closes connection). Name is reserved - attestation is not created if artifact has the same name.
+type ProvenanceSigner struct {
+	signer crypto.Signer
+	// sha256 of the PKIX public key
+	keyId string
+
+	builderCliVersion string
+	builderCliDigest  string
+
+	// electron zip file -> digest, zip in the cache is not changed (but can be re-downloaded, so, size and modification time are checked)
+	electronDigests     map[string]fileDigest
+	electronDigestsLock sync.Mutex
+}
+
+type fileDigest struct {
+	size         int64
+	modification time.Time
+	digest       string
+}
+
+// keyFile: PEM encoded private key (PKCS#8 Ed25519, ECDSA or RSA, or SEC 1 EC) to sign attestations. Provenance is not generated if not set.
+func createProvenanceSigner(keyFile string, scriptPath string, logger *zap.Logger) (*ProvenanceSigner, error) {
+	if keyFile == "" {
+		logger.Info("provenance is disabled", zap.String("reason", "provenanceKey is not set"))
+		return nil, nil
+	}
+
+	signer, err := readPrivateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	keyIdHash := sha256.Sum256(publicKey)
+	result := &ProvenanceSigner{
+		signer:          signer,
+		keyId:           hex.EncodeToString(keyIdHash[:]),
+		electronDigests: make(map[string]fileDigest),
+	}
+
+	// scriptPath is node_modules/app-builder-lib/out/remoteBuilder/builder-cli.js
+	result.builderCliVersion, err = readPackageVersion(filepath.Join(filepath.Dir(scriptPath), "../../package.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	result.builderCliDigest, err = computeFileDigest(scriptPath)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("provenance is enabled", zap.String("keyId", result.keyId), zap.String("builderCliVersion", result.builderCliVersion))
+	return result, nil
+}
+
+func readPrivateKey(file string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", file)
+	}
+
+	var key interface{}
+	if block.Type == "EC PRIVATE KEY" {
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type: %T", key)
+	}
+	return signer, nil
+}
+
+func readPackageVersion(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	var packageJson struct {
+		Version string `json:"version"`
+	}
+	err = jsoniter.ConfigFastest.Unmarshal(data, &packageJson)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot parse %s", file)
+	}
+	return packageJson.Version, nil
+}
+
+func computeFileDigest(file string) (string, error) {
+	reader, err := os.Open(file)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	defer util.Close(reader)
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, reader)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// getElectronDigest returns digest of the electron zip used by unpackElectron (file is located in the app-builder download cache)
+func (t *ProvenanceSigner) getElectronDigest(buildRequest *BuildRequest) (string, error) {
+	options := buildRequest.ElectronDownload
+	cacheDir, err := download.GetCacheDirectory("electron", "ELECTRON_CACHE", false)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	fileName := options.CustomFilename
+	if fileName == "" {
+		fileName = getElectronFileName(options.Version, options.Platform, options.Arch)
+	}
+	file := filepath.Join(cacheDir, fileName)
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	t.electronDigestsLock.Lock()
+	cached, ok := t.electronDigests[file]
+	t.electronDigestsLock.Unlock()
+	if ok && cached.size == info.Size() && cached.modification.Equal(info.ModTime()) {
+		return cached.digest, nil
+	}
+
+	digest, err := computeFileDigest(file)
+	if err != nil {
+		return "", err
+	}
+
+	t.electronDigestsLock.Lock()
+	t.electronDigests[file] = fileDigest{size: info.Size(), modification: info.ModTime(), digest: digest}
+	t.electronDigestsLock.Unlock()
+	return digest, nil
+}
+
+func getElectronFileName(version string, platform string, arch string) string {
+	return "electron-v" + version + "-" + platform + "-" + arch + ".zip"
+}
+
+// https://github.com/in-toto/attestation/blob/main/spec/v0.1.0/statement.md
+type inTotoStatement struct {
+	Type          string              `json:"_type"`
+	Subject       []inTotoSubject     `json:"subject"`
+	PredicateType string              `json:"predicateType"`
+	Predicate     provenancePredicate `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name   string    `json:"name"`
+	Digest digestSet `json:"digest"`
+}
+
+type digestSet struct {
+	Sha256 string `json:"sha256"`
+}
+
+// https://slsa.dev/provenance/v0.2
+type provenancePredicate struct {
+	Builder struct {
+		Id string `json:"id"`
+	} `json:"builder"`
+	BuildType  string `json:"buildType"`
+	Invocation struct {
+		Parameters *BuildRequest `json:"parameters"`
+	} `json:"invocation"`
+	Metadata struct {
+		BuildInvocationId string    `json:"buildInvocationId"`
+		BuildStartedOn    time.Time `json:"buildStartedOn"`
+		BuildFinishedOn   time.Time `json:"buildFinishedOn"`
+		Completeness      struct {
+			Parameters  bool `json:"parameters"`
+			Environment bool `json:"environment"`
+			Materials   bool `json:"materials"`
+		} `json:"completeness"`
+		Reproducible bool `json:"reproducible"`
+	} `json:"metadata"`
+	Materials []provenanceMaterial `json:"materials"`
+}
+
+type provenanceMaterial struct {
+	Uri    string     `json:"uri"`
+	Digest *digestSet `json:"digest,omitempty"`
+}
+
+// https://github.com/secure-systems-lab/dsse/blob/master/envelope.md
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyId string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+func (t *ProvenanceSigner) createStatement(buildJob *BuildJob, result *BuildJobResult, startTime time.Time, finishTime time.Time) (*inTotoStatement, error) {
+	statement := &inTotoStatement{
+		Type:          "https://in-toto.io/Statement/v0.1",
+		PredicateType: "https://slsa.dev/provenance/v0.2",
+	}
+
+	for index, file := range result.files {
+		statement.Subject = append(statement.Subject, inTotoSubject{Name: file, Digest: digestSet{Sha256: result.fileDigests[index]}})
+	}
+
+	predicate := &statement.Predicate
+	predicate.Builder.Id = "https://github.com/electron-userland/electron-build-service/agent/" + buildJob.handler.getAgentAddress()
+	predicate.BuildType = "https://github.com/electron-userland/electron-build-service/remote-build@v2"
+	predicate.Invocation.Parameters = buildJob.buildRequest
+	predicate.Metadata.BuildInvocationId = buildJob.id
+	predicate.Metadata.BuildStartedOn = startTime.UTC()
+	predicate.Metadata.BuildFinishedOn = finishTime.UTC()
+	predicate.Metadata.Completeness.Parameters = true
+	predicate.Metadata.Completeness.Materials = true
+
+	predicate.Materials = append(predicate.Materials,
+		provenanceMaterial{Uri: "upload:" + buildJob.id, Digest: &digestSet{Sha256: buildJob.inputDigest}},
+		provenanceMaterial{Uri: "pkg:npm/app-builder-lib@" + t.builderCliVersion, Digest: &digestSet{Sha256: t.builderCliDigest}},
+	)
+
+	electronOptions := buildJob.buildRequest.ElectronDownload
+	if electronOptions.Version != "" {
+		digest, err := t.getElectronDigest(buildJob.buildRequest)
+		if err != nil {
+			return nil, err
+		}
+
+		predicate.Materials = append(predicate.Materials, provenanceMaterial{
+			Uri:    "pkg:github/electron/electron@v" + electronOptions.Version + "#" + getElectronFileName(electronOptions.Version, electronOptions.Platform, electronOptions.Arch),
+			Digest: &digestSet{Sha256: digest},
+		})
+	}
+	return statement, nil
+}
+
+// sign returns DSSE envelope with the statement as payload
+func (t *ProvenanceSigner) sign(statement *inTotoStatement) ([]byte, error) {
+	payload, err := jsoniter.ConfigFastest.Marshal(statement)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// DSSE pre-authentication encoding
+	message := []byte(fmt.Sprintf("DSSEv1 %d %s %d ", len(inTotoPayloadType), inTotoPayloadType, len(payload)))
+	message = append(message, payload...)
+
+	var signature []byte
+	if _, ok := t.signer.Public().(ed25519.PublicKey); ok {
+		// Ed25519 signs message itself
+		signature, err = t.signer.Sign(rand.Reader, message, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(message)
+		signature, err = t.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	envelope := dsseEnvelope{
+		PayloadType: inTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []dsseSignature{{KeyId: t.keyId, Sig: base64.StdEncoding.EncodeToString(signature)}},
+	}
+	data, err := jsoniter.ConfigFastest.Marshal(envelope)
+	return data, errors.WithStack(err)
+}
+
+// writeProvenance writes signed attestation to the job dir
+func (t *ProvenanceSigner) writeProvenance(buildJob *BuildJob, result *BuildJobResult, startTime time.Time) error {
+	statement, err := t.createStatement(buildJob, result, startTime, time.Now())
+	if err != nil {
+		return err
+	}
+
+	data, err := t.sign(statement)
+	if err != nil {
+		return err
+	}
+
+	return errors.WithStack(ioutil.WriteFile(filepath.Join(buildJob.projectDir, provenanceFileName), data, 0600))
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/json-iterator/go"
+	"go.uber.org/zap"
+)
+
+// createTestSigner writes key and builder-cli package layout (node_modules/app-builder-lib/out/remoteBuilder/builder-cli.js) to dir
+func createTestSigner(t *testing.T, dir string, key crypto.Signer) *ProvenanceSigner {
+	keyData, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyData}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	libDir := filepath.Join(dir, "node_modules", "app-builder-lib")
+	scriptPath := filepath.Join(libDir, "out", "remoteBuilder", "builder-cli.js")
+	err = os.MkdirAll(filepath.Dir(scriptPath), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(libDir, "package.json"), []byte(`{"name": "app-builder-lib", "version": "22.5.1"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(scriptPath, []byte("console.log('build')\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := createProvenanceSigner(keyFile, scriptPath, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestProvenance(t *testing.T) {
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []crypto.Signer{ed25519Key, ecdsaKey} {
+		t.Run(fmt.Sprintf("%T", key), func(t *testing.T) {
+			testProvenance(t, key)
+		})
+	}
+}
+
+func testProvenance(t *testing.T, key crypto.Signer) {
+	dir, err := ioutil.TempDir("", "provenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	signer := createTestSigner(t, dir, key)
+	if signer.builderCliVersion != "22.5.1" {
+		t.Errorf("builder cli version: %s", signer.builderCliVersion)
+	}
+
+	buildJob := &BuildJob{
+		id:           "job1",
+		projectDir:   dir,
+		inputDigest:  "aa",
+		handler:      &BuildHandler{agentAddress: "10.0.0.1:443"},
+		buildRequest: &BuildRequest{Platform: "linux", Targets: []TargetInfo{{Name: "snap", Arch: "x64", UnpackedDirName: "linux-unpacked"}}},
+	}
+	result := &BuildJobResult{files: []string{"app.snap", "latest-linux.yml"}, fileDigests: []string{"bb", "cc"}}
+	startTime := time.Date(2020, 4, 12, 10, 0, 0, 0, time.UTC)
+	err = signer.writeProvenance(buildJob, result, startTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// attestation is not in out dir, so, doesn't overwrite artifact
+	data, err := ioutil.ReadFile(filepath.Join(dir, provenanceFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var envelope dsseEnvelope
+	err = jsoniter.ConfigFastest.Unmarshal(data, &envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.PayloadType != inTotoPayloadType || len(envelope.Signatures) != 1 {
+		t.Fatalf("unexpected envelope: %+v", envelope)
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyIdHash := sha256.Sum256(publicKey)
+	if envelope.Signatures[0].KeyId != hex.EncodeToString(keyIdHash[:]) {
+		t.Errorf("key id: %s", envelope.Signatures[0].KeyId)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(envelope.Signatures[0].Sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// signature is verified over pre-authentication encoding (not over payload)
+	message := []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(inTotoPayloadType), inTotoPayloadType, len(payload), payload))
+	if !verifySignature(key.Public(), message, signature) {
+		t.Fatal("signature is not valid")
+	}
+	if verifySignature(key.Public(), payload, signature) {
+		t.Fatal("signature must be not valid for payload without pre-authentication encoding")
+	}
+
+	var statement inTotoStatement
+	err = jsoniter.ConfigFastest.Unmarshal(payload, &statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if statement.Type != "https://in-toto.io/Statement/v0.1" || statement.PredicateType != "https://slsa.dev/provenance/v0.2" {
+		t.Errorf("unexpected statement type: %s %s", statement.Type, statement.PredicateType)
+	}
+	if len(statement.Subject) != 2 || statement.Subject[0].Name != "app.snap" || statement.Subject[0].Digest.Sha256 != "bb" || statement.Subject[1].Digest.Sha256 != "cc" {
+		t.Errorf("unexpected subject: %+v", statement.Subject)
+	}
+
+	predicate := statement.Predicate
+	if predicate.Builder.Id != "https://github.com/electron-userland/electron-build-service/agent/10.0.0.1:443" || predicate.Metadata.BuildInvocationId != "job1" || !predicate.Metadata.BuildStartedOn.Equal(startTime) {
+		t.Errorf("unexpected predicate: %+v", predicate)
+	}
+	if predicate.Invocation.Parameters == nil || predicate.Invocation.Parameters.Targets[0].Name != "snap" {
+		t.Errorf("build request is not recorded: %+v", predicate.Invocation.Parameters)
+	}
+
+	// electron is not downloaded - upload and builder cli only
+	if len(predicate.Materials) != 2 || predicate.Materials[0].Uri != "upload:job1" || predicate.Materials[0].Digest.Sha256 != "aa" || predicate.Materials[1].Uri != "pkg:npm/app-builder-lib@22.5.1" || predicate.Materials[1].Digest.Sha256 != signer.builderCliDigest {
+		t.Errorf("unexpected materials: %+v", predicate.Materials)
+	}
+}
+
+func verifySignature(publicKey crypto.PublicKey, message []byte, signature []byte) bool {
+	switch key := publicKey.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, message, signature)
+	case *ecdsa.PublicKey:
+		var ecdsaSignature struct {
+			R, S *big.Int
+		}
+		_, err := asn1.Unmarshal(signature, &ecdsaSignature)
+		if err != nil {
+			return false
+		}
+		digest := sha256.Sum256(message)
+		return ecdsa.Verify(key, digest[:], ecdsaSignature.R, ecdsaSignature.S)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/develar/errors"
+	"github.com/electronuserland/electron-build-service/internal/health"
+	"go.uber.org/zap"
+)
+
+const readinessCheckInterval = 10 * time.Second
+
+// less than capacity controller threshold - agent is not ready only if even one upload cannot be accepted
+const minReadyFreeDiskSpace = 1024 * 1024 * 1024
+
+// spawning process on each probe request is expensive
+const executableCheckTtl = 1 * time.Minute
+
+// checks that don't decide whether agent entry is withdrawn (reported in /readyz only):
+// agent entry and job publisher re-register themselves if registry is not available (and entry cannot be withdrawn anyway),
+// router registry view (if router is enabled in the build agent) doesn't affect ability to build.
+var registryReadinessChecks = map[string]bool{"registry": true, "routerRegistry": true}
+
+// Archive is extracted in-process (see archive.ExtractTarZstd), so, zstd executable is not checked.
+func (t *BuildHandler) addReadinessChecks(checker *health.Checker) {
+	checker.AddReadinessCheck("registry", t.checkRegistry)
+	checker.AddReadinessCheck("disk", t.checkDiskSpace)
+	checker.AddReadinessCheck("node", health.Cached(executableCheckTtl, checkNode))
+	checker.AddReadinessCheck("builderCli", t.checkBuilderCli)
+	checker.AddReadinessCheck("pool", t.checkPool)
+}
+
+func (t *BuildHandler) checkRegistry(_ context.Context) error {
+	jobPublisher := t.getJobPublisher()
+	if jobPublisher == nil {
+		return errors.New("agent is not registered")
+	}
+	if !jobPublisher.IsAlive() {
+		return errors.New("registry lease is not renewed")
+	}
+
+	t.agentLock.Lock()
+	agentEntry := t.agentEntry
+	t.agentLock.Unlock()
+	// agent entry is nil if withdrawn (not ready or draining)
+	if agentEntry != nil && !agentEntry.IsAlive() {
+		return errors.New("agent entry lease is not renewed")
+	}
+	return nil
+}
+
+func (t *BuildHandler) checkDiskSpace(_ context.Context) error {
+	for _, dir := range []string{t.tempDir, t.stageDir} {
+		freeSpace, err := getFreeDiskSpace(dir)
+		if err != nil {
+			return err
+		}
+
+		if freeSpace < minReadyFreeDiskSpace {
+			return fmt.Errorf("free space in %s (%d MB) is less than %d MB", dir, freeSpace/(1024*1024), minReadyFreeDiskSpace/(1024*1024))
+		}
+	}
+	return nil
+}
+
+func checkNode(ctx context.Context) error {
+	output, err := exec.CommandContext(ctx, "node", "--version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("node is not runnable: %v (output: %s)", err, output)
+	}
+	return nil
+}
+
+func (t *BuildHandler) checkBuilderCli(_ context.Context) error {
+	_, err := os.Stat(t.scriptPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (t *BuildHandler) checkPool(_ context.Context) error {
+	if t.pool.IsClosed() {
+		return errors.New("pool is closed")
+	}
+	if t.isDraining() {
+		return errors.New("agent is draining")
+	}
+	return nil
+}
+
+// watchReadiness withdraws agent entry from the registry while agent cannot build (so, router doesn't select it) and registers it again when ready.
+// Returns function to stop watching (waits until current check is completed, so, agent is not registered again after stop).
+func (t *BuildHandler) watchReadiness(checker *health.Checker) func() {
+	ticker := time.NewTicker(readinessCheckInterval)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		isReady := true
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				isReady = t.applyReadiness(checker, isReady)
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(stop)
+		<-done
+	}
+}
+
+func (t *BuildHandler) applyReadiness(checker *health.Checker, wasReady bool) bool {
+	var results []health.Result
+	for _, result := range checker.CheckReadiness(context.Background()) {
+		if !registryReadinessChecks[result.Name] {
+			results = append(results, result)
+		}
+	}
+	isReady := health.IsPassed(results)
+	if isReady {
+		if !wasReady {
+			t.logger.Info("agent is ready")
+		}
+
+		err := t.registerAgentIfNotDraining()
+		if err != nil {
+			t.logger.Error("cannot register agent", zap.Error(err))
+		}
+		return true
+	}
+
+	if wasReady {
+		t.logger.Warn("agent is not ready, agent entry is withdrawn", zap.Strings("failedChecks", health.GetFailed(results)))
+	}
+	t.unregisterAgent()
+	return false
+}
+
+// registerAgentIfNotDraining registers agent under drain lock, so, agent is not registered again if drain is started concurrently
+func (t *BuildHandler) registerAgentIfNotDraining() error {
+	t.drainLock.Lock()
+	defer t.drainLock.Unlock()
+
+	if t.drain.isDraining {
+		return nil
+	}
+	return t.registerAgent()
+}
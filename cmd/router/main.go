@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	"github.com/electronuserland/electron-build-service/internal"
+	"github.com/electronuserland/electron-build-service/internal/config"
+	"github.com/electronuserland/electron-build-service/internal/health"
+	"github.com/electronuserland/electron-build-service/internal/router"
+	"github.com/electronuserland/electron-build-service/internal/tracing"
+	"go.uber.org/zap"
+)
+
+// Dedicated router: selects build agent for client (/find-build-agent) and proxies downloads to agent that owns the job, doesn't build.
+// Configuration is the same as for build agent (only relevant fields are used), routing in agents can be disabled by flag -router=false.
+func main() {
+	configManager, err := config.NewManager(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	logger := internal.CreateLogger(configManager.Get().LogEncoding)
+	defer func() {
+		err := logger.Sync()
+		if err != nil {
+			log.Printf("cannot sync logger: %s", err)
+		}
+	}()
+
+	err = start(configManager, logger)
+	if err != nil {
+		logger.Fatal("cannot start", zap.Error(err))
+	}
+}
+
+func start(configManager *config.Manager, logger *zap.Logger) error {
+	configuration := configManager.Get()
+
+	shutdownTracing, err := tracing.Configure("electron-build-service-router", logger)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer shutdownTracing()
+
+	checker := health.NewChecker()
+	agentRegistry, err := router.Configure(configManager, checker, true, logger)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.Close(agentRegistry)
+
+	server := internal.ListenAndServe(internal.ServerOptions{
+		Port:         configuration.Port,
+		UseSsl:       configuration.UseSsl,
+		ClientCaFile: configuration.TlsClientCa,
+		Health:       checker,
+	}, logger)
+
+	stopConfigWatch := configManager.WatchSignal(logger)
+	defer stopConfigWatch()
+
+	logger.Info("started", zap.String("port", configuration.Port), zap.String("etcdEndpoint", configuration.EtcdEndpoint))
+
+	// router requests are short, no need to wait long
+	internal.WaitUntilTerminated(server, 10*time.Second, nil, logger)
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/develar/errors"
+)
+
+// ingest extracts completed builds from log files, writes them to the sink and prints build time report
+func ingest(args []string) error {
+	flags := flag.NewFlagSet("ingest", flag.ExitOnError)
+	sourceName := flags.String("source", "zap", "log format: zap (builder JSON logs) or papertrail (Papertrail TSV archives)")
+	logDir := flags.String("dir", "", "directory with log files (gzipped files are supported)")
+	sinkName := flags.String("sink", "csv", "where to write builds: mysql, postgres, sqlite or csv")
+	dsn := flags.String("dsn", "-", `database connection string (e.g. "root@/logs" for mysql) or file for csv ("-" for stdout)`)
+	_ = flags.Parse(args)
+
+	if *logDir == "" {
+		return errors.New("log directory is not specified (-dir)")
+	}
+
+	source, err := createLogSource(*sourceName)
+	if err != nil {
+		return err
+	}
+
+	sink, err := createSink(*sinkName, *dsn)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = sink.Close()
+	}()
+
+	report := newDurationReport()
+	// log exports can overlap
+	ingestedJobIds := make(map[string]bool)
+	err = processLogDir(*logDir, source, func(entry *BuildEntry) error {
+		if ingestedJobIds[entry.JobId] {
+			return nil
+		}
+
+		ingestedJobIds[entry.JobId] = true
+		report.Add(entry.Targets, entry.Duration)
+		return sink.Write(entry)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = sink.Commit()
+	if err != nil {
+		return err
+	}
+
+	// report is printed to stderr, because stdout can be used by csv sink
+	_, _ = fmt.Fprintf(os.Stderr, "ingested %d builds\n\n", len(ingestedJobIds))
+	return report.Print(os.Stderr)
+}
@@ -1,206 +1,171 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"github.com/develar/app-builder/pkg/util"
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
 	"sort"
 	"strings"
+	"text/tabwriter"
 	"time"
 
-	"database/sql"
-
 	"github.com/develar/errors"
-	"github.com/develar/go-fs-util"
-	_ "github.com/go-sql-driver/mysql"
-	"github.com/valyala/tsvreader"
+	"github.com/electronuserland/electron-build-service/internal"
+	"github.com/electronuserland/electron-build-service/internal/buildHistory"
+	"github.com/json-iterator/go"
 )
 
-const logDir = "/Volumes/test/logs"
-
 func main() {
-	err := do("logs")
-	if err != nil {
-		log.Fatalf("%v", err)
+	args := os.Args[1:]
+	command := "query"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command = args[0]
+		args = args[1:]
 	}
-}
 
-func do(databaseName string) error {
-	files, err := getSortedFileNames()
-	if err != nil {
-		return err
+	var err error
+	switch command {
+	case "query":
+		err = query(args)
+	case "ingest":
+		err = ingest(args)
+	default:
+		err = fmt.Errorf("unknown command: %s (expected query or ingest)", command)
 	}
-
-	db, err := sql.Open("mysql", "root@/"+databaseName)
 	if err != nil {
-		return err
+		log.Fatalf("%v", err)
 	}
+}
 
-	defer util.Close(db)
-
-	insertStatement, err := db.Prepare("INSERT INTO logs.builds VALUES( ?, ?, ?, ?)")
+// query prints build history records written by build agent
+func query(args []string) error {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	dbFile := flags.String("db", filepath.Join(internal.GetBuilderDirectory("history"), "history.db"), "build history database")
+	since := flags.Duration("since", 24*time.Hour, "show jobs completed within the given duration (0 to show all)")
+	tenant := flags.String("tenant", "", "filter by tenant")
+	target := flags.String("target", "", "filter by target (e.g. snap)")
+	limit := flags.Int("limit", 0, "max number of the latest jobs to show")
+	format := flags.String("format", "table", "output format: table, json or summary")
+	_ = flags.Parse(args)
+
+	store, err := buildHistory.NewBoltStore(*dbFile)
 	if err != nil {
 		return err
 	}
-	defer util.Close(insertStatement)
 
-	tx, err := db.Begin()
-	if err != nil {
-		return err
+	filter := buildHistory.Filter{
+		Tenant: *tenant,
+		Target: *target,
+		Limit:  *limit,
 	}
-
-	err = process(files, insertStatement)
-	if err != nil {
-		_ = tx.Rollback()
-		return err
+	if *since > 0 {
+		filter.Since = time.Now().Add(-*since)
 	}
 
-	err = tx.Commit()
+	records, err := store.Query(filter)
 	if err != nil {
 		return err
 	}
-	return nil
-}
-
-func getSortedFileNames() ([]string, error) {
-	files, err := fsutil.ReadDirContent(logDir)
-	if err != nil {
-		return nil, err
-	}
 
-	list := make([]string, 0)
-	for _, file := range files {
-		if strings.HasSuffix(file, ".tsv") {
-			list = append(list, file)
+	switch *format {
+	case "table":
+		return printTable(records)
+	case "json":
+		encoder := jsoniter.ConfigFastest.NewEncoder(os.Stdout)
+		for _, record := range records {
+			err = encoder.Encode(record)
+			if err != nil {
+				return errors.WithStack(err)
+			}
 		}
+		return nil
+	case "summary":
+		return printSummary(records)
+	default:
+		return fmt.Errorf("unknown format: %s", *format)
 	}
-
-	sort.Strings(list)
-
-	return list, nil
 }
 
-func process(files []string, insertStatement *sql.Stmt) error {
-	for _, file := range files {
-		if !strings.HasSuffix(file, ".tsv") {
-			continue
+func printTable(records []*buildHistory.Record) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "JOB\tCOMPLETED\tTENANT\tTARGETS\tELECTRON\tQUEUE\tUPLOAD\tBUILD\tDOWNLOAD\tARTIFACTS\tOUTCOME")
+	for _, record := range records {
+		var artifactSize int64
+		for _, size := range record.ArtifactSizes {
+			artifactSize += size
 		}
 
-		file, err := os.Open(filepath.Join(logDir, file))
-		if err != nil {
-			return err
+		outcome := record.Outcome
+		if record.ErrorClass != "" {
+			outcome += " (" + record.ErrorClass + ")"
 		}
 
-		err = processFile(file, insertStatement)
-		if err != nil {
-			return err
-		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			record.JobId,
+			record.CompletedAt.Local().Format("2006-01-02 15:04:05"),
+			record.Tenant,
+			strings.Join(record.Targets, ",")+" ("+strings.Join(record.Archs, ",")+")",
+			record.ElectronVersion,
+			formatMilliseconds(record.QueueTime),
+			formatMilliseconds(record.UploadTime),
+			formatMilliseconds(record.BuildTime),
+			formatMilliseconds(record.DownloadTime),
+			formatSize(artifactSize),
+			outcome,
+		)
 	}
-
-	return nil
+	return errors.WithStack(w.Flush())
 }
 
-func processFile(file *os.File, insertStatement *sql.Stmt) error {
-	defer util.Close(file)
-
-	oldBuildRe := regexp.MustCompile(`^Build \(([^)]+)\): (.+)`)
-	oldTargetRe := regexp.MustCompile(`.+target=([a-zA-Z]+).+file=/stage/([^/]+)/.+`)
-
-	var targets []string
-	jobIdToTargets := make(map[string][]string)
-
-	r := tsvreader.New(file)
-	for r.Next() {
-		// https://help.papertrailapp.com/kb/how-it-works/permanent-log-archives/
-
-		// unique Papertrail event ID (64-bit integer as JSON string)
-		r.SkipCol()
-
-		// generated_at time
-		generatedAtString := r.String()
-		// received_at time
-		r.SkipCol()
-
-		// source_id
-		r.SkipCol()
-		// source_name
-		r.SkipCol()
-		// source_ip
-		r.SkipCol()
-
-		// facility_name
-		r.SkipCol()
-		// severity_name
-		r.SkipCol()
-
-		// program
-		r.SkipCol()
-
-		// message
-		message := r.String()
-
-		if strings.Contains(message, "• building") && !strings.Contains(message, "• building embedded") {
-			t := oldTargetRe.FindStringSubmatch(message)
-			jobId := t[2]
-			jobIdToTargets[jobId] = append(jobIdToTargets[jobId], strings.ToLower(t[1]))
-			continue
+// printSummary prints job count, outcomes and build time percentiles per target set
+func printSummary(records []*buildHistory.Record) error {
+	outcomes := make(map[string]int)
+	report := newDurationReport()
+	for _, record := range records {
+		outcome := record.Outcome
+		if record.ErrorClass != "" {
+			outcome += " (" + record.ErrorClass + ")"
 		}
+		outcomes[outcome]++
 
-		if strings.HasPrefix(message, "Building AppImage ") {
-			targets = append(targets, "appimage")
-			continue
-		} else if strings.HasPrefix(message, "Building Snap ") {
-			targets = append(targets, "snap")
-			continue
-		} else if strings.HasPrefix(message, "Building deb") {
-			targets = append(targets, "snap")
-			continue
-		} else if !strings.HasPrefix(message, "Build (") {
-			continue
+		if record.Outcome == buildHistory.OutcomeSuccess {
+			report.Add(record.Targets, time.Duration(record.BuildTime)*time.Millisecond)
 		}
+	}
 
-		results := oldBuildRe.FindStringSubmatch(message)
-		jobId := results[1]
-
-		targetsFromMap := jobIdToTargets[jobId]
-		if len(targetsFromMap) > 0 {
-			targets = targetsFromMap
-		}
-
-		if len(targets) == 0 {
-			if jobId == "j-vd1zSZ" || jobId == "k-aWMBbb" || jobId == "7e-sESlo9" || jobId == "c-lC0xlU" || jobId == "7-yXAI1r" {
-				// job completed with error
-				continue
-			}
-
-			return fmt.Errorf("no targets")
-		}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "jobs\t%d\n\n", len(records))
+	for _, key := range sortedKeys(outcomes) {
+		_, _ = fmt.Fprintf(w, "%s\t%d\n", key, outcomes[key])
+	}
 
-		completedAt, err := time.Parse("2006-01-02T15:04:05", generatedAtString)
-		if err != nil {
-			return errors.WithStack(err)
-		}
+	err := w.Flush()
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-		duration, err := time.ParseDuration(strings.Replace(results[2], " ", "", -1))
-		if err != nil {
-			return errors.WithStack(err)
-		}
+	_, _ = fmt.Println()
+	return report.Print(os.Stdout)
+}
 
-		hours := int(duration.Hours())
-		minutes := int(duration.Minutes()) % 60
-		seconds := int(duration.Seconds()) % 60
-		milliseconds := int(duration/time.Millisecond) - (seconds * 1000) - (minutes * 60000) - (hours * 3600000)
+func sortedKeys(m map[string]int) []string {
+	result := make([]string, 0, len(m))
+	for key := range m {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
 
-		_, err = insertStatement.Exec(jobId, fmt.Sprintf("%d:%d:%d.%d", hours, minutes, seconds, milliseconds), completedAt, strings.Join(targets, ","))
-		if err != nil {
-			return errors.WithStack(err)
-		}
+func formatMilliseconds(value int64) string {
+	return formatDuration(time.Duration(value) * time.Millisecond)
+}
 
-		targets = nil
+func formatSize(size int64) string {
+	if size == 0 {
+		return "-"
 	}
-
-	return nil
+	return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
 }
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/develar/errors"
+	"github.com/valyala/tsvreader"
+)
+
+var oldBuildRe = regexp.MustCompile(`^Build \(([^)]+)\): (.+)`)
+var oldTargetRe = regexp.MustCompile(`.+target=([a-zA-Z]+).+file=/stage/([^/]+)/.+`)
+
+// papertrailLogSource processes Papertrail archives (https://help.papertrailapp.com/kb/how-it-works/permanent-log-archives/).
+// Message can be either in the old builder format ("Build (id): duration") or zap JSON.
+type papertrailLogSource struct {
+	// old format: targets of the job being built, job id is not logged for all messages
+	targets        []string
+	jobIdToTargets map[string][]string
+}
+
+func (t *papertrailLogSource) IsLogFile(name string) bool {
+	return strings.HasSuffix(name, ".tsv")
+}
+
+func (t *papertrailLogSource) Process(reader io.Reader, consumer func(entry *BuildEntry) error) error {
+	if t.jobIdToTargets == nil {
+		t.jobIdToTargets = make(map[string][]string)
+	}
+
+	r := tsvreader.New(reader)
+	for r.Next() {
+		// unique Papertrail event ID (64-bit integer as JSON string)
+		r.SkipCol()
+
+		// generated_at time
+		generatedAtString := r.String()
+		// received_at time
+		r.SkipCol()
+
+		// source_id
+		r.SkipCol()
+		// source_name
+		r.SkipCol()
+		// source_ip
+		r.SkipCol()
+
+		// facility_name
+		r.SkipCol()
+		// severity_name
+		r.SkipCol()
+
+		// program
+		r.SkipCol()
+
+		// message
+		message := r.String()
+
+		entry, err := t.processMessage(message, generatedAtString)
+		if err != nil {
+			return err
+		}
+
+		if entry != nil {
+			err = consumer(entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return errors.WithStack(r.Error())
+}
+
+func (t *papertrailLogSource) processMessage(message string, generatedAtString string) (*BuildEntry, error) {
+	if strings.HasPrefix(message, "{") {
+		return parseZapLine([]byte(message))
+	}
+
+	if strings.Contains(message, "• building") && !strings.Contains(message, "• building embedded") {
+		result := oldTargetRe.FindStringSubmatch(message)
+		if result != nil {
+			jobId := result[2]
+			t.jobIdToTargets[jobId] = append(t.jobIdToTargets[jobId], strings.ToLower(result[1]))
+		}
+		return nil, nil
+	}
+
+	if strings.HasPrefix(message, "Building AppImage ") {
+		t.targets = append(t.targets, "appimage")
+		return nil, nil
+	} else if strings.HasPrefix(message, "Building Snap ") {
+		t.targets = append(t.targets, "snap")
+		return nil, nil
+	} else if strings.HasPrefix(message, "Building deb") {
+		t.targets = append(t.targets, "deb")
+		return nil, nil
+	} else if !strings.HasPrefix(message, "Build (") {
+		return nil, nil
+	}
+
+	results := oldBuildRe.FindStringSubmatch(message)
+	if results == nil {
+		return nil, nil
+	}
+
+	jobId := results[1]
+	targets := t.targets
+	t.targets = nil
+
+	targetsFromMap := t.jobIdToTargets[jobId]
+	if len(targetsFromMap) > 0 {
+		targets = targetsFromMap
+		delete(t.jobIdToTargets, jobId)
+	}
+
+	if len(targets) == 0 {
+		// job completed with error
+		return nil, nil
+	}
+
+	completedAt, err := time.Parse("2006-01-02T15:04:05", generatedAtString)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	duration, err := time.ParseDuration(strings.Replace(results[2], " ", "", -1))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &BuildEntry{
+		JobId:       jobId,
+		CompletedAt: completedAt,
+		Duration:    duration,
+		Targets:     targets,
+	}, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Papertrail archive line: id, generated_at, received_at, source_id, source_name, source_ip, facility_name, severity_name, program, message
+func papertrailLine(generatedAt string, message string) string {
+	return strings.Join([]string{"1136412351837462528", generatedAt, generatedAt, "5542151642", "builder-1", "10.0.0.1", "User", "Info", "builder", message}, "\t")
+}
+
+func TestPapertrailLogSource(t *testing.T) {
+	log := strings.Join([]string{
+		// old format, targets are logged with job id
+		papertrailLine("2018-11-05T12:00:01", "  • building        target=snap arch=x64 file=/stage/job1/dist/app_1.0.0_amd64.snap"),
+		papertrailLine("2018-11-05T12:00:02", "  • building        target=AppImage arch=x64 file=/stage/job1/dist/App-1.0.0.AppImage"),
+		papertrailLine("2018-11-05T12:00:02", "  • building embedded block map  file=/stage/job1/dist/App-1.0.0.AppImage"),
+		papertrailLine("2018-11-05T12:00:30", "Build (job1): 1m 2.5s"),
+		// older format, targets are logged without job id
+		papertrailLine("2018-11-05T12:01:00", "Building deb"),
+		papertrailLine("2018-11-05T12:01:30", "Build (job2): 30.1s"),
+		// failed build - no targets
+		papertrailLine("2018-11-05T12:02:00", "Build (job3): 5s"),
+		papertrailLine("2018-11-05T12:03:00", "unrelated message"),
+		// zap JSON
+		papertrailLine("2020-04-12T10:15:30", `{"level":"info","ts":1586686530,"msg":"job completed","jobId":"job4","duration":12,"targets":["rpm"]}`),
+	}, "\n") + "\n"
+
+	var entries []BuildEntry
+	err := (&papertrailLogSource{}).Process(strings.NewReader(log), func(entry *BuildEntry) error {
+		entries = append(entries, *entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []BuildEntry{
+		{JobId: "job1", CompletedAt: time.Date(2018, 11, 5, 12, 0, 30, 0, time.UTC), Duration: 62500 * time.Millisecond, Targets: []string{"snap", "appimage"}},
+		{JobId: "job2", CompletedAt: time.Date(2018, 11, 5, 12, 1, 30, 0, time.UTC), Duration: 30100 * time.Millisecond, Targets: []string{"deb"}},
+		{JobId: "job4", CompletedAt: time.Unix(1586686530, 0), Duration: 12 * time.Second, Targets: []string{"rpm"}},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %+v\nactual   %+v", expected, entries)
+	}
+	for index := range entries {
+		if !entries[index].CompletedAt.Equal(expected[index].CompletedAt) {
+			t.Errorf("%s: completedAt: expected %s, actual %s", expected[index].JobId, expected[index].CompletedAt, entries[index].CompletedAt)
+		}
+		// location is not compared
+		entries[index].CompletedAt = expected[index].CompletedAt
+		if !reflect.DeepEqual(entries[index], expected[index]) {
+			t.Errorf("expected %+v\nactual   %+v", expected[index], entries[index])
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/develar/errors"
+)
+
+// durationReport collects build durations per target set (targets are built together, so, duration cannot be split per target)
+type durationReport struct {
+	durations map[string][]time.Duration
+}
+
+func newDurationReport() *durationReport {
+	return &durationReport{durations: make(map[string][]time.Duration)}
+}
+
+func (t *durationReport) Add(targets []string, duration time.Duration) {
+	sortedTargets := append([]string(nil), targets...)
+	sort.Strings(sortedTargets)
+	key := strings.Join(sortedTargets, ",")
+	t.durations[key] = append(t.durations[key], duration)
+}
+
+func (t *durationReport) Print(writer io.Writer) error {
+	keys := make([]string, 0, len(t.durations))
+	for key := range t.durations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "TARGETS\tBUILDS\tP50\tP95")
+	for _, key := range keys {
+		durations := t.durations[key]
+		sort.Slice(durations, func(i, j int) bool {
+			return durations[i] < durations[j]
+		})
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", key, len(durations), formatDuration(percentile(durations, 0.5)), formatDuration(percentile(durations, 0.95)))
+	}
+	return errors.WithStack(w.Flush())
+}
+
+// percentile uses nearest-rank method, durations must be sorted
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+
+	index := int(math.Ceil(p*float64(len(durations)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return durations[index]
+}
+
+func formatDuration(duration time.Duration) string {
+	if duration == 0 {
+		return "-"
+	}
+	return duration.Round(100 * time.Millisecond).String()
+}
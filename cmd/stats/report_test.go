@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	durations := []time.Duration{1 * time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Second, 5 * time.Second, 6 * time.Second, 7 * time.Second, 8 * time.Second, 9 * time.Second, 10 * time.Second}
+	testCases := []struct {
+		durations []time.Duration
+		p         float64
+		expected  time.Duration
+	}{
+		{durations, 0.5, 5 * time.Second},
+		{durations, 0.95, 10 * time.Second},
+		{durations, 0.9, 9 * time.Second},
+		{durations, 0, 1 * time.Second},
+		{durations, 1, 10 * time.Second},
+		{[]time.Duration{7 * time.Second}, 0.95, 7 * time.Second},
+		{[]time.Duration{1 * time.Second, 2 * time.Second}, 0.5, 1 * time.Second},
+		{nil, 0.5, 0},
+	}
+
+	for _, testCase := range testCases {
+		actual := percentile(testCase.durations, testCase.p)
+		if actual != testCase.expected {
+			t.Errorf("p%v of %v: expected %s, actual %s", testCase.p*100, testCase.durations, testCase.expected, actual)
+		}
+	}
+}
+
+func TestDurationReport(t *testing.T) {
+	report := newDurationReport()
+	report.Add([]string{"snap", "appimage"}, 90*time.Second)
+	// target order doesn't matter
+	report.Add([]string{"appimage", "snap"}, 30*time.Second)
+	report.Add([]string{"deb"}, 12340*time.Millisecond)
+
+	var buffer bytes.Buffer
+	err := report.Print(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "TARGETS        BUILDS  P50    P95\n" +
+		"appimage,snap  2       30s    1m30s\n" +
+		"deb            1       12.3s  12.3s\n"
+	if buffer.String() != expected {
+		t.Errorf("expected\n%s\nactual\n%s", expected, buffer.String())
+	}
+}
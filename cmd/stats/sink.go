@@ -0,0 +1,165 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/develar/errors"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Sink stores build entries. Entries are not visible until Commit is called.
+type Sink interface {
+	Write(entry *BuildEntry) error
+	Commit() error
+	// Close discards not committed entries
+	Close() error
+}
+
+// createSink creates sink by name. DSN is a database connection string or, for CSV, file path ("-" for stdout).
+func createSink(name string, dsn string) (Sink, error) {
+	switch name {
+	case "csv":
+		return newCsvSink(dsn)
+	case "mysql":
+		return newSqlSink("mysql", dsn, "INSERT IGNORE INTO builds VALUES (?, ?, ?, ?, ?)")
+	case "postgres":
+		return newSqlSink("postgres", dsn, "INSERT INTO builds VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING")
+	case "sqlite":
+		return newSqlSink("sqlite3", dsn, "INSERT OR IGNORE INTO builds VALUES (?, ?, ?, ?, ?)")
+	default:
+		return nil, errors.Errorf("unknown sink: %s", name)
+	}
+}
+
+// the same job can be ingested several times (e.g. overlapping log exports), so, job id is a primary key and duplicates are ignored
+const createBuildsTable = `CREATE TABLE IF NOT EXISTS builds (
+  job_id VARCHAR(64) PRIMARY KEY,
+  completed_at TIMESTAMP NOT NULL,
+  duration_ms BIGINT NOT NULL,
+  targets VARCHAR(255) NOT NULL,
+  artifact_size BIGINT NOT NULL
+)`
+
+type sqlSink struct {
+	db              *sql.DB
+	tx              *sql.Tx
+	insertStatement *sql.Stmt
+}
+
+func newSqlSink(driverName string, dsn string, insertSql string) (*sqlSink, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sink := &sqlSink{db: db}
+	err = sink.init(insertSql)
+	if err != nil {
+		_ = sink.Close()
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (t *sqlSink) init(insertSql string) error {
+	_, err := t.db.Exec(createBuildsTable)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	t.tx, err = t.db.Begin()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	t.insertStatement, err = t.tx.Prepare(insertSql)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (t *sqlSink) Write(entry *BuildEntry) error {
+	_, err := t.insertStatement.Exec(entry.JobId, entry.CompletedAt.UTC(), int64(entry.Duration/time.Millisecond), strings.Join(entry.Targets, ","), sumSizes(entry.FileSizes))
+	return errors.WithStack(err)
+}
+
+func (t *sqlSink) Commit() error {
+	err := t.tx.Commit()
+	t.tx = nil
+	return errors.WithStack(err)
+}
+
+func (t *sqlSink) Close() error {
+	if t.insertStatement != nil {
+		_ = t.insertStatement.Close()
+	}
+	if t.tx != nil {
+		_ = t.tx.Rollback()
+	}
+	return errors.WithStack(t.db.Close())
+}
+
+type csvSink struct {
+	file   io.WriteCloser
+	writer *csv.Writer
+}
+
+func newCsvSink(file string) (*csvSink, error) {
+	var output io.WriteCloser
+	if file == "-" || file == "" {
+		output = os.Stdout
+	} else {
+		var err error
+		output, err = os.Create(file)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	writer := csv.NewWriter(output)
+	err := writer.Write([]string{"job_id", "completed_at", "duration_ms", "targets", "artifact_size"})
+	if err != nil {
+		_ = output.Close()
+		return nil, errors.WithStack(err)
+	}
+	return &csvSink{file: output, writer: writer}, nil
+}
+
+func (t *csvSink) Write(entry *BuildEntry) error {
+	return errors.WithStack(t.writer.Write([]string{
+		entry.JobId,
+		entry.CompletedAt.UTC().Format(time.RFC3339),
+		strconv.FormatInt(int64(entry.Duration/time.Millisecond), 10),
+		strings.Join(entry.Targets, ","),
+		strconv.FormatInt(sumSizes(entry.FileSizes), 10),
+	}))
+}
+
+func (t *csvSink) Commit() error {
+	t.writer.Flush()
+	return errors.WithStack(t.writer.Error())
+}
+
+func (t *csvSink) Close() error {
+	if t.file == os.Stdout {
+		return nil
+	}
+	return errors.WithStack(t.file.Close())
+}
+
+func sumSizes(sizes []int64) int64 {
+	var result int64
+	for _, size := range sizes {
+		result += size
+	}
+	return result
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	"github.com/develar/go-fs-util"
+)
+
+// BuildEntry is a completed build extracted from logs
+type BuildEntry struct {
+	JobId       string
+	CompletedAt time.Time
+	Duration    time.Duration
+	// lower-cased target names
+	Targets   []string
+	FileSizes []int64
+}
+
+// LogSource extracts completed builds from log files of some format
+type LogSource interface {
+	// IsLogFile returns true if file (name without .gz suffix) must be processed by this source
+	IsLogFile(name string) bool
+	Process(reader io.Reader, consumer func(entry *BuildEntry) error) error
+}
+
+func createLogSource(name string) (LogSource, error) {
+	switch name {
+	case "zap":
+		return &zapLogSource{}, nil
+	case "papertrail":
+		return &papertrailLogSource{}, nil
+	default:
+		return nil, errors.Errorf("unknown log source: %s", name)
+	}
+}
+
+// processLogDir processes log files in name order (rotated and archived logs are named by date), gzipped files are supported
+func processLogDir(dir string, source LogSource, consumer func(entry *BuildEntry) error) error {
+	files, err := fsutil.ReadDirContent(dir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	sort.Strings(files)
+	for _, name := range files {
+		if !source.IsLogFile(strings.TrimSuffix(name, ".gz")) {
+			continue
+		}
+
+		err = processLogFile(filepath.Join(dir, name), source, consumer)
+		if err != nil {
+			return errors.Wrapf(err, "cannot process %s", name)
+		}
+	}
+	return nil
+}
+
+func processLogFile(file string, source LogSource, consumer func(entry *BuildEntry) error) error {
+	reader, err := os.Open(file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer util.Close(reader)
+
+	if !strings.HasSuffix(file, ".gz") {
+		return source.Process(reader, consumer)
+	}
+
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer util.Close(gzipReader)
+	return source.Process(gzipReader, consumer)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/develar/errors"
+	"github.com/json-iterator/go"
+)
+
+// zapLogSource processes builder logs in JSON encoding (LOG_ENCODING=json), one entry per line.
+// Both development (T, M) and production (ts, msg) zap key names are supported.
+type zapLogSource struct {
+}
+
+type zapLogEntry struct {
+	Message           string      `json:"M"`
+	ProductionMessage string      `json:"msg"`
+	Time              string      `json:"T"`
+	ProductionTime    float64     `json:"ts"`
+	JobId             string      `json:"jobId"`
+	Duration          interface{} `json:"duration"`
+	Targets           []string    `json:"targets"`
+	Result            string      `json:"result"`
+	FileSizes         []int64     `json:"fileSizes"`
+}
+
+const zapTimeLayout = "2006-01-02T15:04:05.000Z0700"
+
+func (t *zapLogSource) IsLogFile(name string) bool {
+	return strings.HasSuffix(name, ".log") || strings.HasSuffix(name, ".json")
+}
+
+func (t *zapLogSource) Process(reader io.Reader, consumer func(entry *BuildEntry) error) error {
+	scanner := bufio.NewScanner(reader)
+	// result and project info are logged, so, line can be long
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		entry, err := parseZapLine(scanner.Bytes())
+		if err != nil {
+			return err
+		}
+
+		if entry != nil {
+			err = consumer(entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return errors.WithStack(scanner.Err())
+}
+
+// parseZapLine returns nil if line is not a "job completed" entry
+func parseZapLine(line []byte) (*BuildEntry, error) {
+	if len(line) == 0 || line[0] != '{' {
+		return nil, nil
+	}
+
+	var logEntry zapLogEntry
+	err := jsoniter.ConfigFastest.Unmarshal(line, &logEntry)
+	if err != nil {
+		// not a JSON log line
+		return nil, nil
+	}
+
+	if logEntry.Message != "job completed" && logEntry.ProductionMessage != "job completed" {
+		return nil, nil
+	}
+
+	entry := &BuildEntry{
+		JobId:     logEntry.JobId,
+		FileSizes: logEntry.FileSizes,
+		Targets:   logEntry.Targets,
+	}
+
+	if logEntry.Time != "" {
+		entry.CompletedAt, err = time.Parse(zapTimeLayout, logEntry.Time)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	} else {
+		entry.CompletedAt = time.Unix(0, int64(logEntry.ProductionTime*float64(time.Second)))
+	}
+
+	switch duration := logEntry.Duration.(type) {
+	case string:
+		// development config uses string duration encoder
+		entry.Duration, err = time.ParseDuration(duration)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	case float64:
+		// production config encodes duration in seconds
+		entry.Duration = time.Duration(duration * float64(time.Second))
+	default:
+		return nil, errors.Errorf("unexpected duration in entry %s", entry.JobId)
+	}
+
+	if len(entry.Targets) == 0 {
+		// old builder versions do not log targets
+		entry.Targets = guessTargets(logEntry.Result)
+	}
+	return entry, nil
+}
+
+var artifactExtensionToTarget = map[string]string{
+	".appimage": "appimage",
+	".snap":     "snap",
+	".deb":      "deb",
+	".rpm":      "rpm",
+	".pacman":   "pacman",
+	".apk":      "apk",
+	".freebsd":  "freebsd",
+	".p5p":      "p5p",
+	".exe":      "nsis",
+	".zip":      "zip",
+	".7z":       "7z",
+	".xz":       "tar.xz",
+	".gz":       "tar.gz",
+	".bz2":      "tar.bz2",
+	".lz":       "tar.lz",
+}
+
+// guessTargets guesses targets by artifact file extensions (build result is a list of artifacts)
+func guessTargets(rawResult string) []string {
+	if len(rawResult) == 0 || rawResult[0] != '[' {
+		return nil
+	}
+
+	var artifacts []struct {
+		File string `json:"file"`
+	}
+	err := jsoniter.ConfigFastest.UnmarshalFromString(rawResult, &artifacts)
+	if err != nil {
+		return nil
+	}
+
+	targetSet := make(map[string]bool)
+	for _, artifact := range artifacts {
+		target := artifactExtensionToTarget[strings.ToLower(filepath.Ext(artifact.File))]
+		// update info files (e.g. latest-linux.yml) and blockmaps are not targets
+		if target != "" {
+			targetSet[target] = true
+		}
+	}
+
+	result := make([]string, 0, len(targetSet))
+	for target := range targetSet {
+		result = append(result, target)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseZapLine(t *testing.T) {
+	testCases := []struct {
+		name     string
+		line     string
+		expected *BuildEntry
+	}{
+		{
+			name: "development encoding",
+			line: `{"L":"INFO","T":"2020-04-12T10:15:30.123Z","M":"job completed","jobId":"1a2b3c","duration":"1m2.5s","targets":["snap","appimage"],"result":"[{\"file\":\"app.snap\"}]","fileSizes":[52428800,62914560],"projectInfo":"{}"}`,
+			expected: &BuildEntry{
+				JobId:       "1a2b3c",
+				CompletedAt: time.Date(2020, 4, 12, 10, 15, 30, 123000000, time.UTC),
+				Duration:    62500 * time.Millisecond,
+				Targets:     []string{"snap", "appimage"},
+				FileSizes:   []int64{52428800, 62914560},
+			},
+		},
+		{
+			name: "production encoding",
+			line: `{"level":"info","ts":1586686530.5,"logger":"builder","msg":"job completed","jobId":"4d5e6f","duration":95.25,"targets":["deb"],"fileSizes":[1024]}`,
+			expected: &BuildEntry{
+				JobId:       "4d5e6f",
+				CompletedAt: time.Unix(1586686530, 500000000),
+				Duration:    95250 * time.Millisecond,
+				Targets:     []string{"deb"},
+				FileSizes:   []int64{1024},
+			},
+		},
+		{
+			name: "targets are guessed if not logged (old builder)",
+			line: `{"L":"INFO","T":"2019-01-02T03:04:05.000Z","M":"job completed","jobId":"old","duration":"30s","result":"[{\"file\":\"App-1.0.0.AppImage\"},{\"file\":\"latest-linux.yml\"},{\"file\":\"app_1.0.0_amd64.snap\"},{\"file\":\"App-1.0.0.AppImage.blockmap\"}]"}`,
+			expected: &BuildEntry{
+				JobId:       "old",
+				CompletedAt: time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC),
+				Duration:    30 * time.Second,
+				Targets:     []string{"appimage", "snap"},
+			},
+		},
+		{
+			name: "other message",
+			line: `{"L":"INFO","T":"2020-04-12T10:15:30.123Z","M":"uploaded and unpacked","jobId":"1a2b3c","elapsed":"2.1s"}`,
+		},
+		{
+			name: "not a JSON",
+			line: `2020-04-12T10:15:30.123Z	INFO	job completed	{"jobId": "1a2b3c"}`,
+		},
+		{
+			name: "broken JSON",
+			line: `{"M":"job completed",`,
+		},
+		{
+			name: "empty line",
+			line: ``,
+		},
+	}
+
+	for _, testCase := range testCases {
+		entry, err := parseZapLine([]byte(testCase.line))
+		if err != nil {
+			t.Errorf("%s: %v", testCase.name, err)
+			continue
+		}
+
+		if entry != nil && testCase.expected != nil {
+			if !entry.CompletedAt.Equal(testCase.expected.CompletedAt) {
+				t.Errorf("%s: completedAt: expected %s, actual %s", testCase.name, testCase.expected.CompletedAt, entry.CompletedAt)
+			}
+			// location is not compared
+			entry.CompletedAt = testCase.expected.CompletedAt
+		}
+		if !reflect.DeepEqual(entry, testCase.expected) {
+			t.Errorf("%s:\nexpected %+v\nactual   %+v", testCase.name, testCase.expected, entry)
+		}
+	}
+}
+
+func TestParseZapLineInvalidDuration(t *testing.T) {
+	_, err := parseZapLine([]byte(`{"M":"job completed","T":"2020-04-12T10:15:30.123Z","jobId":"1a2b3c","targets":["snap"]}`))
+	if err == nil {
+		t.Error("entry without duration must be rejected")
+	}
+}
+
+func TestGuessTargets(t *testing.T) {
+	testCases := []struct {
+		result   string
+		expected []string
+	}{
+		{`[{"file":"app-1.0.0.x86_64.rpm"},{"file":"app_1.0.0_amd64.deb"},{"file":"app_1.0.0_amd64.deb"}]`, []string{"deb", "rpm"}},
+		{`[{"file":"app-1.0.0.tar.gz"},{"file":"app-1.0.0.tar.xz"},{"file":"App Setup 1.0.0.exe"},{"file":"app-1.0.0.pacman"}]`, []string{"nsis", "pacman", "tar.gz", "tar.xz"}},
+		{`[{"file":"latest-linux.yml"},{"file":"app.AppImage.blockmap"}]`, []string{}},
+		{`[]`, []string{}},
+		{`{"error":"failed"}`, nil},
+		{`[{"file":`, nil},
+		{``, nil},
+	}
+
+	for _, testCase := range testCases {
+		actual := guessTargets(testCase.result)
+		if !reflect.DeepEqual(actual, testCase.expected) {
+			t.Errorf("%q: expected %v, actual %v", testCase.result, testCase.expected, actual)
+		}
+	}
+}
+
+func TestZapLogSource(t *testing.T) {
+	log := strings.Join([]string{
+		`{"L":"INFO","T":"2020-04-12T10:15:29.000Z","M":"uploaded and unpacked","jobId":"a"}`,
+		`{"L":"INFO","T":"2020-04-12T10:15:30.000Z","M":"job completed","jobId":"a","duration":"10s","targets":["snap"]}`,
+		`{"L":"INFO","T":"2020-04-12T10:16:30.000Z","M":"job completed","jobId":"b","duration":"20s","targets":["deb"]}`,
+	}, "\n")
+
+	var jobIds []string
+	err := (&zapLogSource{}).Process(strings.NewReader(log), func(entry *BuildEntry) error {
+		jobIds = append(jobIds, entry.JobId)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(jobIds, []string{"a", "b"}) {
+		t.Errorf("unexpected entries: %v", jobIds)
+	}
+}
@@ -3,120 +3,303 @@ package agentRegistry
 import (
 	"context"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
-	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
 	"github.com/develar/errors"
 	"github.com/electronuserland/electron-build-service/internal"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
 const entryTtl = 1 * time.Minute
 
+// etcd can be not available, do not block shutdown or withdrawal
+const revokeTimeout = 5 * time.Second
+
+// grant and put must not block job processing (entry is updated on each job start and finish)
+const requestTimeout = 5 * time.Second
+
+// delay before the next registration attempt if etcd is not available, doubled on each failed attempt
+const minRegisterRetryDelay = 1 * time.Second
+const maxRegisterRetryDelay = 30 * time.Second
+
+type AgentEntryState int32
+
+const (
+	// lease is being granted (initial registration)
+	AgentEntryRegistering AgentEntryState = iota
+	// entry is published and lease is kept alive
+	AgentEntryRegistered
+	// lease is lost (expired or revoked), agent is being registered again
+	AgentEntryReconnecting
+	AgentEntryClosed
+)
+
+func (t AgentEntryState) String() string {
+	switch t {
+	case AgentEntryRegistering:
+		return "registering"
+	case AgentEntryRegistered:
+		return "registered"
+	case AgentEntryReconnecting:
+		return "reconnecting"
+	case AgentEntryClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// AgentEntry publishes the agent to the registry under own lease.
+// Lease is kept alive using the KeepAlive channel, if lease is lost, agent is registered again (with exponential backoff) using the same client.
 type AgentEntry struct {
 	Key   string
 	store *clientv3.Client
+	ttl   time.Duration
+
+	leaseId *atomic.Int64
+
+	state          *atomic.Int32
+	stateListeners []func(state AgentEntryState)
+	stateLock      sync.Mutex
 
-	timer    *time.Timer
-	isClosed chan bool
-	leaseId  clientv3.LeaseID
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// effective capacity that is advertised instead of cpu count (can be decreased on memory or disk pressure)
+	capacity *atomic.Int32
+	jobCount *atomic.Int32
+
+	// unix nano time of the last successful lease grant or renewal
+	lastRenewTime *atomic.Int64
 
 	logger *zap.Logger
 }
 
-// ttlInSeconds - is the server selected time-to-live, in seconds, for the lease
-func computeRenewLeaseTimerDuration(ttlInSeconds int64) time.Duration {
-	return time.Duration(ttlInSeconds-4 /* seconds is enough for renew */) * time.Second
-}
+// NewAgentEntry registers agent. Error is returned if initial registration failed, subsequent failures are handled by agent entry itself.
+func NewAgentEntry(key string, etcdEndpoint string, logger *zap.Logger) (*AgentEntry, error) {
+	store, err := internal.CreateEtcdClient(etcdEndpoint)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 
-func NewAgentEntry(key string, logger *zap.Logger) (*AgentEntry, error) {
-	return createOrUpdateAgentEntry(key, logger.With(zap.String("key", key)), nil)
+	agentEntry, err := newAgentEntry(key, store, entryTtl, logger)
+	if err != nil {
+		internal.Close(store, logger)
+		return nil, errors.WithStack(err)
+	}
+	return agentEntry, nil
 }
 
-func createOrUpdateAgentEntry(key string, logger *zap.Logger, agentEntry *AgentEntry) (*AgentEntry, error) {
-	logger.Info("register agent")
-	store, err := internal.CreateEtcdClient()
+// newAgentEntry takes ownership of store (closed on Close)
+func newAgentEntry(key string, store *clientv3.Client, ttl time.Duration, logger *zap.Logger) (*AgentEntry, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &AgentEntry{
+		Key:   key,
+		store: store,
+		ttl:   ttl,
+
+		leaseId: atomic.NewInt64(int64(clientv3.NoLease)),
+		state:   atomic.NewInt32(int32(AgentEntryRegistering)),
+
+		cancel: cancel,
+		done:   make(chan struct{}),
+
+		capacity: atomic.NewInt32(int32(runtime.NumCPU())),
+		jobCount: atomic.NewInt32(0),
+
+		lastRenewTime: atomic.NewInt64(0),
+
+		logger: logger.With(zap.String("key", key)),
+	}
+
+	keepAliveChannel, err := t.register(ctx)
 	if err != nil {
+		cancel()
 		return nil, errors.WithStack(err)
 	}
 
-	leaseGrantResponse, err := store.Grant(context.Background(), int64(entryTtl/time.Second))
+	go t.run(ctx, keepAliveChannel)
+	return t, nil
+}
+
+// register grants a new lease and puts the entry with the current capacity and job count
+func (t *AgentEntry) register(ctx context.Context) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	t.logger.Info("register agent")
+
+	requestContext, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	leaseGrantResponse, err := t.store.Grant(requestContext, int64(t.ttl/time.Second))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	timer := time.NewTimer(computeRenewLeaseTimerDuration(leaseGrantResponse.TTL))
-	isClosed := make(chan bool, 1)
+	value := encodeAgentValue(t.capacity.Load(), t.jobCount.Load())
+	_, err = t.store.Put(requestContext, t.Key, value, clientv3.WithLease(leaseGrantResponse.ID))
+	if err != nil {
+		t.revoke(leaseGrantResponse.ID)
+		return nil, errors.WithStack(err)
+	}
 
-	// job count (cannot be more than 127 (actually, router limits to 16 and then returns 503 (and client retry request after at least 30 seconds)))
-	_, err = store.Put(context.Background(), key, string([]byte{byte(runtime.NumCPU()), 0}), clientv3.WithLease(leaseGrantResponse.ID))
+	// keep alive is stopped on ctx cancel (and not on requestContext)
+	keepAliveChannel, err := t.store.KeepAlive(ctx, leaseGrantResponse.ID)
 	if err != nil {
+		t.revoke(leaseGrantResponse.ID)
 		return nil, errors.WithStack(err)
 	}
 
-	go func() {
-		for {
-			select {
-			case <-isClosed:
-				return
-			case <-timer.C:
-				response, err := store.KeepAliveOnce(context.Background(), leaseGrantResponse.ID)
-				if err != nil {
-					if err == rpctypes.ErrLeaseNotFound {
-						logger.Warn("cannot renew the agent entry lease", zap.NamedError("reason", err), zap.String("solution", "agent will be registered again"))
-
-						closeOldErr := agentEntry.Close()
-						if closeOldErr != nil {
-							logger.Warn("cannot unregister old agent", zap.Error(err))
-						}
-
-						// register new
-						_, err = createOrUpdateAgentEntry(key, logger, agentEntry)
-						if err != nil {
-							logger.Warn("cannot register new agent", zap.Error(err))
-						}
-						return
-					}
-
-					logger.Error("cannot renew the agent entry lease", zap.Error(err))
-				}
-
-				timer.Reset(computeRenewLeaseTimerDuration(response.TTL))
-			}
+	t.leaseId.Store(int64(leaseGrantResponse.ID))
+	t.lastRenewTime.Store(time.Now().UnixNano())
+	t.setState(AgentEntryRegistered)
+
+	// update is skipped while not registered, so, put again if job count or capacity was changed during registration
+	if encodeAgentValue(t.capacity.Load(), t.jobCount.Load()) != value {
+		t.put()
+	}
+	return keepAliveChannel, nil
+}
+
+func (t *AgentEntry) run(ctx context.Context, keepAliveChannel <-chan *clientv3.LeaseKeepAliveResponse) {
+	defer close(t.done)
+
+	for {
+		// channel is closed on cancel or if lease cannot be renewed anymore (expired or revoked)
+		for range keepAliveChannel {
+			t.lastRenewTime.Store(time.Now().UnixNano())
+		}
+
+		if ctx.Err() != nil {
+			return
 		}
-	}()
 
-	if agentEntry == nil {
-		agentEntry = &AgentEntry{
-			Key:    key,
-			logger: logger,
-			store:  store,
+		t.logger.Warn("agent entry lease is lost", zap.String("solution", "agent will be registered again"))
+		t.setState(AgentEntryReconnecting)
 
-			leaseId:  leaseGrantResponse.ID,
-			timer:    timer,
-			isClosed: isClosed,
+		keepAliveChannel = t.registerWithRetry(ctx)
+		if keepAliveChannel == nil {
+			return
+		}
+	}
+}
+
+// registerWithRetry returns nil if ctx is cancelled
+func (t *AgentEntry) registerWithRetry(ctx context.Context) <-chan *clientv3.LeaseKeepAliveResponse {
+	delay := minRegisterRetryDelay
+	for {
+		keepAliveChannel, err := t.register(ctx)
+		if err == nil {
+			return keepAliveChannel
+		}
+		if ctx.Err() != nil {
+			return nil
 		}
-	} else {
-		agentEntry.store = store
 
-		agentEntry.leaseId = leaseGrantResponse.ID
-		agentEntry.timer = timer
-		agentEntry.isClosed = isClosed
+		t.logger.Warn("cannot register agent", zap.Error(err), zap.Duration("retryIn", delay))
+		if !sleep(ctx, delay) {
+			return nil
+		}
+		delay = nextRetryDelay(delay)
 	}
-	return agentEntry, nil
+}
+
+// sleep returns false if ctx is cancelled
+func sleep(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func nextRetryDelay(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay > maxRegisterRetryDelay {
+		delay = maxRegisterRetryDelay
+	}
+	return delay
+}
+
+// State returns the current state of the agent entry.
+func (t *AgentEntry) State() AgentEntryState {
+	return AgentEntryState(t.state.Load())
+}
+
+// OnStateChange adds listener that is called on each state change (listener must not block).
+func (t *AgentEntry) OnStateChange(listener func(state AgentEntryState)) {
+	t.stateLock.Lock()
+	defer t.stateLock.Unlock()
+	t.stateListeners = append(t.stateListeners, listener)
+}
+
+func (t *AgentEntry) setState(state AgentEntryState) {
+	t.stateLock.Lock()
+	defer t.stateLock.Unlock()
+
+	oldState := AgentEntryState(t.state.Swap(int32(state)))
+	if oldState == state {
+		return
+	}
+
+	t.logger.Info("agent entry state changed", zap.Stringer("oldState", oldState), zap.Stringer("newState", state))
+	for _, listener := range t.stateListeners {
+		listener(state)
+	}
+}
+
+// job count (cannot be more than 127 (actually, router limits to 16 and then returns 503 (and client retry request after at least 30 seconds)))
+func encodeAgentValue(capacity int32, jobCount int32) string {
+	return string([]byte{byte(capacity), byte(jobCount)})
 }
 
 func (t *AgentEntry) Update(jobCount int) {
-	store := t.store
-	if store == nil {
-		t.logger.Error("cannot update job", zap.String("reason", "store is null"))
+	t.jobCount.Store(int32(jobCount))
+	t.put()
+}
+
+// SetCapacity updates advertised capacity (router uses it to compute agent weight).
+func (t *AgentEntry) SetCapacity(capacity int) {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	if t.capacity.Swap(int32(capacity)) != int32(capacity) {
+		t.put()
+	}
+}
+
+func (t *AgentEntry) put() {
+	// the current values are put on registration
+	if t.State() != AgentEntryRegistered {
 		return
 	}
 
-	_, err := store.Put(context.Background(), t.Key, string([]byte{byte(runtime.NumCPU()), byte(jobCount)}), clientv3.WithLease(t.leaseId))
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	_, err := t.store.Put(ctx, t.Key, encodeAgentValue(t.capacity.Load(), t.jobCount.Load()), clientv3.WithLease(clientv3.LeaseID(t.leaseId.Load())))
+	if err != nil {
+		t.logger.Error("cannot update agent entry", zap.Error(err))
+	}
+}
+
+// IsAlive returns false if lease was not renewed in time (so, entry is likely expired and router doesn't see the agent).
+func (t *AgentEntry) IsAlive() bool {
+	return time.Since(time.Unix(0, t.lastRenewTime.Load())) < t.ttl
+}
+
+func (t *AgentEntry) revoke(leaseId clientv3.LeaseID) {
+	ctx, cancel := context.WithTimeout(context.Background(), revokeTimeout)
+	defer cancel()
+
+	_, err := t.store.Revoke(ctx, leaseId)
 	if err != nil {
-		t.logger.Error("cannot update job", zap.Error(err))
+		t.logger.Warn("cannot revoke lease", zap.Error(err))
 	}
 }
 
@@ -124,10 +307,13 @@ func (t *AgentEntry) Close() error {
 	t.logger.Info("unregister agent")
 	defer internal.Close(t.store, t.logger)
 
-	t.isClosed <- true
-	t.timer.Stop()
+	t.cancel()
+	<-t.done
+	t.setState(AgentEntryClosed)
 
-	_, err := t.store.Revoke(context.Background(), t.leaseId)
+	ctx, cancel := context.WithTimeout(context.Background(), revokeTimeout)
+	defer cancel()
+	_, err := t.store.Revoke(ctx, clientv3.LeaseID(t.leaseId.Load()))
 	if err != nil {
 		return errors.WithStack(err)
 	}
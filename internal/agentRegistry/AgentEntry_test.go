@@ -0,0 +1,231 @@
+package agentRegistry
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/embed"
+	"go.uber.org/zap"
+)
+
+// short ttl to not wait long for lease expiration (etcd doesn't grant less than a few seconds)
+const testEntryTtl = 5 * time.Second
+
+func startEtcd(t *testing.T) (*embed.Etcd, func()) {
+	dir, err := ioutil.TempDir("", "etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// port 0 - any free port
+	clientUrl, _ := url.Parse("http://127.0.0.1:0")
+	peerUrl, _ := url.Parse("http://127.0.0.1:0")
+
+	config := embed.NewConfig()
+	config.Dir = dir
+	config.LCUrls = []url.URL{*clientUrl}
+	config.ACUrls = []url.URL{*clientUrl}
+	config.LPUrls = []url.URL{*peerUrl}
+	config.APUrls = []url.URL{*peerUrl}
+	config.InitialCluster = config.InitialClusterFromName(config.Name)
+
+	server, err := embed.StartEtcd(config)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		server.Close()
+		_ = os.RemoveAll(dir)
+		t.Fatal("etcd is not started in time")
+	}
+
+	// stop can be called by test to simulate unavailable etcd, so, stop is idempotent
+	var stopOnce sync.Once
+	return server, func() {
+		stopOnce.Do(func() {
+			server.Close()
+			_ = os.RemoveAll(dir)
+		})
+	}
+}
+
+func createTestClient(t *testing.T, server *embed.Etcd) *clientv3.Client {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{server.Clients[0].Addr().String()},
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func getValue(t *testing.T, client *clientv3.Client, key string) (string, clientv3.LeaseID) {
+	response, err := client.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Kvs) == 0 {
+		return "", clientv3.NoLease
+	}
+	return string(response.Kvs[0].Value), clientv3.LeaseID(response.Kvs[0].Lease)
+}
+
+func waitForState(t *testing.T, states chan AgentEntryState, expected AgentEntryState) {
+	timeout := time.After(30 * time.Second)
+	for {
+		select {
+		case state := <-states:
+			if state == expected {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("agent entry is not %s in time", expected)
+		}
+	}
+}
+
+func TestAgentEntry(t *testing.T) {
+	server, stop := startEtcd(t)
+	defer stop()
+
+	client := createTestClient(t, server)
+	defer client.Close()
+
+	key := "/builders/127.0.0.1:1"
+	agentEntry, err := newAgentEntry(key, createTestClient(t, server), testEntryTtl, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if agentEntry.State() != AgentEntryRegistered || !agentEntry.IsAlive() {
+		t.Fatalf("agent entry must be registered, state: %s", agentEntry.State())
+	}
+
+	agentEntry.SetCapacity(4)
+	agentEntry.Update(2)
+	value, leaseId := getValue(t, client, key)
+	if value != encodeAgentValue(4, 2) {
+		t.Errorf("unexpected value: %v", []byte(value))
+	}
+	if leaseId != clientv3.LeaseID(agentEntry.leaseId.Load()) {
+		t.Errorf("entry must be attached to lease %x, actual %x", agentEntry.leaseId.Load(), leaseId)
+	}
+
+	// lease is kept alive longer than ttl
+	time.Sleep(testEntryTtl + 2*time.Second)
+	value, _ = getValue(t, client, key)
+	if value == "" || !agentEntry.IsAlive() {
+		t.Fatal("agent entry must be kept alive")
+	}
+
+	err = agentEntry.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agentEntry.State() != AgentEntryClosed {
+		t.Errorf("agent entry must be closed, state: %s", agentEntry.State())
+	}
+	value, _ = getValue(t, client, key)
+	if value != "" {
+		t.Error("agent entry must be removed on close")
+	}
+}
+
+func TestAgentEntryRegisterAgainOnLeaseLoss(t *testing.T) {
+	server, stop := startEtcd(t)
+	defer stop()
+
+	client := createTestClient(t, server)
+	defer client.Close()
+
+	key := "/builders/127.0.0.1:2"
+	agentEntry, err := newAgentEntry(key, createTestClient(t, server), testEntryTtl, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agentEntry.Close()
+
+	states := make(chan AgentEntryState, 16)
+	agentEntry.OnStateChange(func(state AgentEntryState) {
+		states <- state
+	})
+
+	agentEntry.SetCapacity(3)
+	agentEntry.Update(1)
+	oldLeaseId := clientv3.LeaseID(agentEntry.leaseId.Load())
+
+	// e.g. lease is expired because etcd was not available for a long time
+	_, err = client.Revoke(context.Background(), oldLeaseId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForState(t, states, AgentEntryReconnecting)
+	waitForState(t, states, AgentEntryRegistered)
+
+	value, leaseId := getValue(t, client, key)
+	if value != encodeAgentValue(3, 1) {
+		t.Errorf("capacity and job count must be preserved, actual value: %v", []byte(value))
+	}
+	if leaseId == oldLeaseId || leaseId != clientv3.LeaseID(agentEntry.leaseId.Load()) {
+		t.Errorf("entry must be attached to the new lease (old %x, actual %x)", oldLeaseId, leaseId)
+	}
+
+	agentEntry.Update(5)
+	value, _ = getValue(t, client, key)
+	if value != encodeAgentValue(3, 5) {
+		t.Errorf("entry must be updated after registration, actual value: %v", []byte(value))
+	}
+}
+
+func TestAgentEntryRetryWhileNotAvailable(t *testing.T) {
+	server, stop := startEtcd(t)
+	defer stop()
+
+	client := createTestClient(t, server)
+	defer client.Close()
+
+	key := "/builders/127.0.0.1:3"
+	agentEntry, err := newAgentEntry(key, createTestClient(t, server), testEntryTtl, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	states := make(chan AgentEntryState, 16)
+	agentEntry.OnStateChange(func(state AgentEntryState) {
+		states <- state
+	})
+
+	// etcd is stopped, keep alive channel is closed on lease deadline and registration fails while etcd is stopped
+	stop()
+	waitForState(t, states, AgentEntryReconnecting)
+	time.Sleep(minRegisterRetryDelay * 2)
+	if agentEntry.State() != AgentEntryReconnecting {
+		t.Errorf("agent entry must be reconnecting, state: %s", agentEntry.State())
+	}
+
+	// close must not hang while etcd is not available
+	closeDone := make(chan error, 1)
+	go func() {
+		closeDone <- agentEntry.Close()
+	}()
+	select {
+	case <-closeDone:
+	case <-time.After(revokeTimeout + 10*time.Second):
+		t.Fatal("close is not completed in time")
+	}
+	if agentEntry.State() != AgentEntryClosed {
+		t.Errorf("agent entry must be closed, state: %s", agentEntry.State())
+	}
+}
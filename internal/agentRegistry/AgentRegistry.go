@@ -14,145 +14,302 @@ import (
 	"go.uber.org/zap"
 )
 
-// well, it is ok if client will get dead node address and will check health - but we will not DDoS our server and request new list on each client request
-const agentListTtl = 5 * time.Minute
+const agentKeyPrefix = "/builders/"
 
+// delay before the next sync attempt if etcd is not available, doubled on each failed attempt
+const minSyncRetryDelay = 1 * time.Second
+const maxSyncRetryDelay = 30 * time.Second
+
+var ErrNotSynced = errors.New("agent list is not synced yet")
+
+// AgentRegistry keeps local view of build agents. View is loaded once and then updated incrementally by watch (starting at the revision of the initial list).
+// On compaction or watch cancellation full list is loaded again. Requests never wait for etcd - the last known view is used while registry is resynced.
 type AgentRegistry struct {
-	lastUpdate  time.Time
 	buildAgents map[string]*BuildAgent
+	// revision of the last applied change
+	revision int64
+	isSynced bool
 
 	mutex sync.RWMutex
 
-	store  *clientv3.Client
+	etcdEndpoint string
+	store        *clientv3.Client
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
 	logger *zap.Logger
 }
 
-func NewAgentRegistry(logger *zap.Logger) *AgentRegistry {
-	return &AgentRegistry{
-		logger: logger,
+// NewAgentRegistry starts sync in background, registry must be closed.
+func NewAgentRegistry(etcdEndpoint string, logger *zap.Logger) *AgentRegistry {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &AgentRegistry{
+		etcdEndpoint: etcdEndpoint,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+		logger:       logger,
+	}
+	go t.run(ctx)
+	return t
+}
+
+func (t *AgentRegistry) run(ctx context.Context) {
+	defer close(t.done)
+
+	delay := minSyncRetryDelay
+	for {
+		isSynced, err := t.sync(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if isSynced {
+			delay = minSyncRetryDelay
+		}
+
+		if err == rpctypes.ErrCompacted {
+			t.logger.Info("agent list revision is compacted, resync", zap.Int64("revision", t.getRevision()))
+			// compaction is expected, resync immediately
+			continue
+		}
+
+		t.logger.Warn("cannot sync agent list", zap.Error(err), zap.Duration("retryIn", delay))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > maxSyncRetryDelay {
+			delay = maxSyncRetryDelay
+		}
 	}
 }
 
-func (t *AgentRegistry) listen() error {
-	store, err := internal.CreateEtcdClient()
+func (t *AgentRegistry) connect() (*clientv3.Client, error) {
+	t.mutex.Lock()
+	store := t.store
+	t.mutex.Unlock()
+	if store != nil {
+		return store, nil
+	}
+
+	// not under lock - client creation blocks until connection is established
+	store, err := internal.CreateEtcdClient(t.etcdEndpoint)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 
+	t.mutex.Lock()
 	t.store = store
+	t.mutex.Unlock()
+	return store, nil
+}
+
+// sync loads full list and then applies changes until watch is failed or cancelled. Returns true if full list was loaded.
+func (t *AgentRegistry) sync(ctx context.Context) (bool, error) {
+	store, err := t.connect()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	getContext, cancelGet := context.WithTimeout(ctx, requestTimeout)
+	response, err := store.Get(getContext, agentKeyPrefix, clientv3.WithPrefix())
+	cancelGet()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	buildAgents := make(map[string]*BuildAgent, len(response.Kvs))
+	for _, keyValue := range response.Kvs {
+		t.logger.Debug("etcd entry", zap.ByteString("key", keyValue.Key), zap.ByteString("value", keyValue.Value))
+
+		agent := createBuildAgent(keyValue)
+		if agent == nil {
+			t.logger.Warn("invalid agent entry", zap.ByteString("key", keyValue.Key))
+			continue
+		}
+		buildAgents[agent.Address] = agent
+	}
+
+	revision := response.Header.Revision
+	t.mutex.Lock()
+	t.buildAgents = buildAgents
+	t.revision = revision
+	t.isSynced = true
+	t.mutex.Unlock()
+
+	t.logger.Info("agent list synced", zap.Int("count", len(buildAgents)), zap.Int64("revision", revision))
+	return true, t.watch(ctx, store, revision)
+}
+
+// watch applies changes after the revision until watch is failed or cancelled (rpctypes.ErrCompacted is returned if revision is compacted)
+func (t *AgentRegistry) watch(ctx context.Context, store *clientv3.Client, revision int64) error {
+	// require leader - otherwise watch on partitioned member is not cancelled and view becomes stale silently
+	watchContext, cancelWatch := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancelWatch()
 
-	watchChain := t.store.Watch(context.Background(), "/builders/", clientv3.WithPrefix())
-	go func() {
-		for response := range watchChain {
-			t.handleEvents(&response)
+	for watchResponse := range store.Watch(watchContext, agentKeyPrefix, clientv3.WithPrefix(), clientv3.WithRev(revision+1)) {
+		err := watchResponse.Err()
+		if err != nil {
+			return err
 		}
-	}()
-	return nil
+
+		t.handleEvents(&watchResponse)
+	}
+	return errors.New("watch channel is closed")
 }
 
-// invalidate cache on any PUT/DELETE events
-// lastUpdate time is not updated - better to perform full update to avoid dead entries
 func (t *AgentRegistry) handleEvents(response *clientv3.WatchResponse) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	if t.buildAgents == nil {
-		return
-	}
-
 	for _, event := range response.Events {
+		// already applied (included into the full list)
+		if event.Kv.ModRevision <= t.revision {
+			continue
+		}
+
 		key := etcdKeyToOurMapKey(event.Kv)
 		if event.Type == mvccpb.PUT {
-			info, found := t.buildAgents[key]
+			agent := createBuildAgent(event.Kv)
+			if agent == nil {
+				t.logger.Warn("invalid agent entry", zap.ByteString("key", event.Kv.Key))
+				continue
+			}
+
+			oldAgent, found := t.buildAgents[key]
 			if found {
-				oldJobCount := info.JobCount
-				// already known agent
-				applyToInfo(info, event.Kv)
 				t.logger.Info("agent updated",
 					zap.String("key", key),
-					zap.Int("oldJobCount", oldJobCount),
-					zap.Int("newJobCount", info.JobCount),
+					zap.Int("oldJobCount", oldAgent.JobCount),
+					zap.Int("newJobCount", agent.JobCount),
 				)
 			} else {
 				t.logger.Info("agent added", zap.String("key", key))
-				// new agent - for the sake of simplicity, for now simply invalidate our state
-				t.buildAgents = nil
-				break
 			}
+			// agent is replaced and not modified - returned agents are not guarded by lock
+			t.buildAgents[key] = agent
 		} else {
 			t.logger.Info("agent removed", zap.String("key", key))
 			// DELETE
 			delete(t.buildAgents, key)
 		}
 	}
-}
 
-func (t *AgentRegistry) getListIfValid(isLocked bool) map[string]*BuildAgent {
-	if !isLocked {
-		t.mutex.RLock()
-		defer t.mutex.RUnlock()
+	if response.Header.Revision > t.revision {
+		t.revision = response.Header.Revision
 	}
+}
 
-	if t.buildAgents == nil {
-		t.logger.Debug("no valid agent list", zap.String("reason", "buildAgents is nil"))
-		return nil
+func (t *AgentRegistry) getRevision() int64 {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.revision
+}
+
+// GetAgents returns the local view of agents, ErrNotSynced is returned if agent list was never loaded.
+func (t *AgentRegistry) GetAgents() ([]BuildAgent, error) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	if !t.isSynced {
+		return nil, ErrNotSynced
 	}
 
-	since := time.Since(t.lastUpdate)
-	if since < agentListTtl {
-		return t.buildAgents
-	} else {
-		t.logger.Debug("no valid agent list", zap.String("reason", "buildAgents list outdated"), zap.Duration("since", since))
-		return nil
+	result := make([]BuildAgent, 0, len(t.buildAgents))
+	for _, agent := range t.buildAgents {
+		result = append(result, *agent)
 	}
+	return result, nil
 }
 
-func (t *AgentRegistry) GetAgents() (map[string]*BuildAgent, error) {
-	result := t.getListIfValid(false)
-	if result != nil {
-		return result, nil
+func (t *AgentRegistry) getStore() (*clientv3.Client, error) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	if t.store == nil {
+		return nil, errors.New("registry is not connected")
 	}
+	return t.store, nil
+}
 
-	t.mutex.Lock()
-	defer t.mutex.Unlock()
+// Ping checks that agent list is synced and registry is available (count only request, local view is not used).
+func (t *AgentRegistry) Ping(ctx context.Context) error {
+	store, err := t.getStore()
+	if err != nil {
+		return errors.WithStack(err)
+	}
 
-	result = t.getListIfValid(true)
-	if result != nil {
-		return result, nil
+	t.mutex.RLock()
+	isSynced := t.isSynced
+	t.mutex.RUnlock()
+	if !isSynced {
+		return ErrNotSynced
 	}
 
-	if t.store == nil {
-		err := t.listen()
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
+	_, err = store.Get(ctx, agentKeyPrefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	return errors.WithStack(err)
+}
+
+// GetJobs returns summaries of jobs published by agents (see JobPublisher).
+func (t *AgentRegistry) GetJobs() ([]*JobSummary, error) {
+	store, err := t.getStore()
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 
-	response, err := t.store.Get(context.Background(), "/builders/", clientv3.WithPrefix())
+	response, err := store.Get(context.Background(), jobKeyPrefix, clientv3.WithPrefix())
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	result = make(map[string]*BuildAgent, len(response.Kvs))
+	result := make([]*JobSummary, 0, len(response.Kvs))
 	for _, keyValue := range response.Kvs {
-		info := BuildAgent{}
-
-		t.logger.Debug("etcd entry", zap.ByteString("key", keyValue.Key), zap.ByteString("value", keyValue.Value))
+		summary, err := decodeJobSummary(keyValue.Value)
+		if err != nil {
+			t.logger.Warn("cannot decode job summary", zap.ByteString("key", keyValue.Key), zap.Error(err))
+			continue
+		}
+		result = append(result, summary)
+	}
+	return result, nil
+}
 
-		info.Address = etcdKeyToOurMapKey(keyValue)
-		applyToInfo(&info, keyValue)
-		result[info.Address] = &info
+// GetJob returns nil if job is not found.
+func (t *AgentRegistry) GetJob(id string) (*JobSummary, error) {
+	store, err := t.getStore()
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 
-	t.buildAgents = result
-	t.lastUpdate = time.Now()
+	response, err := store.Get(context.Background(), jobKeyPrefix+id)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 
-	return result, nil
+	if len(response.Kvs) == 0 {
+		return nil, nil
+	}
+	return decodeJobSummary(response.Kvs[0].Value)
 }
 
-func applyToInfo(info *BuildAgent, keyValue *mvccpb.KeyValue) {
-	info.CpuCount = int(keyValue.Value[0])
-	info.JobCount = int(keyValue.Value[1])
+// createBuildAgent returns nil if value is malformed (see encodeAgentValue)
+func createBuildAgent(keyValue *mvccpb.KeyValue) *BuildAgent {
+	if len(keyValue.Value) < 2 {
+		return nil
+	}
+
+	return &BuildAgent{
+		Address:  etcdKeyToOurMapKey(keyValue),
+		CpuCount: int(keyValue.Value[0]),
+		JobCount: int(keyValue.Value[1]),
+	}
 }
 
 func etcdKeyToOurMapKey(keyValue *mvccpb.KeyValue) string {
@@ -161,11 +318,16 @@ func etcdKeyToOurMapKey(keyValue *mvccpb.KeyValue) string {
 }
 
 func (t *AgentRegistry) Close() error {
-	if t.store == nil {
+	t.cancel()
+	<-t.done
+
+	store, err := t.getStore()
+	if err != nil {
+		// not connected
 		return nil
 	}
 
-	err := t.store.Close()
+	err = store.Close()
 	if err == rpctypes.ErrLeaseNotFound {
 		return nil
 	}
@@ -175,5 +337,6 @@ func (t *AgentRegistry) Close() error {
 type BuildAgent struct {
 	Address  string
 	JobCount int
+	// effective capacity advertised by agent (cpu count if agent is not under memory or disk pressure)
 	CpuCount int
 }
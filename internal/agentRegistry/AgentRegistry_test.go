@@ -0,0 +1,147 @@
+package agentRegistry
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"go.uber.org/zap"
+)
+
+func waitForAgents(t *testing.T, registry *AgentRegistry, condition func(agents map[string]BuildAgent) bool) map[string]BuildAgent {
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		list, err := registry.GetAgents()
+		if err == nil {
+			agents := make(map[string]BuildAgent, len(list))
+			for _, agent := range list {
+				agents[agent.Address] = agent
+			}
+			if condition(agents) {
+				return agents
+			}
+		} else if err != ErrNotSynced {
+			t.Fatal(err)
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("agent list is not updated in time (last result: %v, error: %v)", list, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestAgentRegistry(t *testing.T) {
+	server, stop := startEtcd(t)
+	defer stop()
+
+	client := createTestClient(t, server)
+	defer client.Close()
+
+	ctx := context.Background()
+	_, err := client.Put(ctx, agentKeyPrefix+"10.0.0.1:443", encodeAgentValue(4, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// malformed entry must be skipped
+	_, err = client.Put(ctx, agentKeyPrefix+"10.0.0.9:443", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := NewAgentRegistry(server.Clients[0].Addr().String(), zap.NewNop())
+	defer registry.Close()
+
+	agents := waitForAgents(t, registry, func(agents map[string]BuildAgent) bool {
+		return len(agents) == 1
+	})
+	if agents["10.0.0.1:443"] != (BuildAgent{Address: "10.0.0.1:443", CpuCount: 4, JobCount: 1}) {
+		t.Errorf("unexpected agents: %v", agents)
+	}
+
+	err = registry.Ping(ctx)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// changes are applied incrementally
+	_, err = client.Put(ctx, agentKeyPrefix+"10.0.0.2:443", encodeAgentValue(2, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Put(ctx, agentKeyPrefix+"10.0.0.1:443", encodeAgentValue(4, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForAgents(t, registry, func(agents map[string]BuildAgent) bool {
+		return len(agents) == 2 && agents["10.0.0.1:443"].JobCount == 3 && agents["10.0.0.2:443"].CpuCount == 2
+	})
+
+	_, err = client.Delete(ctx, agentKeyPrefix+"10.0.0.1:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForAgents(t, registry, func(agents map[string]BuildAgent) bool {
+		_, found := agents["10.0.0.1:443"]
+		return len(agents) == 1 && !found
+	})
+}
+
+func TestAgentRegistryWatchCompactedRevision(t *testing.T) {
+	server, stop := startEtcd(t)
+	defer stop()
+
+	client := createTestClient(t, server)
+	defer client.Close()
+
+	ctx := context.Background()
+	var revision int64
+	for _, value := range []string{encodeAgentValue(4, 0), encodeAgentValue(4, 1)} {
+		response, err := client.Put(ctx, agentKeyPrefix+"10.0.0.1:443", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		revision = response.Header.Revision
+	}
+
+	_, err := client.Compact(ctx, revision)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// e.g. watch was disconnected for a long time and cannot be resumed, registry must load full list again (see run)
+	registry := &AgentRegistry{buildAgents: make(map[string]*BuildAgent), revision: 1, logger: zap.NewNop()}
+	err = registry.watch(ctx, client, 1)
+	if err != rpctypes.ErrCompacted {
+		t.Errorf("ErrCompacted expected, actual: %v", err)
+	}
+}
+
+func TestAgentRegistryIsNotBlockedIfEtcdIsNotAvailable(t *testing.T) {
+	// free port without server
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := listener.Addr().String()
+	_ = listener.Close()
+
+	registry := NewAgentRegistry(endpoint, zap.NewNop())
+
+	start := time.Now()
+	_, err = registry.GetAgents()
+	if err != ErrNotSynced {
+		t.Errorf("ErrNotSynced expected, actual: %v", err)
+	}
+	if registry.Ping(context.Background()) == nil {
+		t.Error("registry must be not ready")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("registry must not wait for etcd, waited %s", time.Since(start))
+	}
+
+	// close waits for connection attempt (limited by dial timeout)
+	_ = registry.Close()
+}
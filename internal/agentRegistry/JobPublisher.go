@@ -0,0 +1,277 @@
+package agentRegistry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/develar/errors"
+	"github.com/electronuserland/electron-build-service/internal"
+	"github.com/json-iterator/go"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+const jobKeyPrefix = "/jobs/"
+
+// JobPublisher publishes job summaries to the registry, so, router can find which agent owns the job.
+// Own lease is used (not agent entry lease), so, jobs are still visible when agent is drained.
+// Job entries are removed automatically if agent is dead. If lease is lost, lease is granted again (with exponential backoff) and all jobs are published again.
+type JobPublisher struct {
+	store   *clientv3.Client
+	ttl     time.Duration
+	leaseId *atomic.Int64
+
+	// job id -> summary to put (nil to delete). Operations are coalesced per job (only the latest one is applied), so, operation is never dropped.
+	pending map[string]*JobSummary
+	// jobs that are put to the registry (to publish again if lease is lost)
+	published map[string]*JobSummary
+	lock      sync.Mutex
+	wakeUp    chan struct{}
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// unix nano time of the last lease grant or keep alive response
+	lastKeepAliveTime *atomic.Int64
+
+	logger *zap.Logger
+}
+
+func NewJobPublisher(etcdEndpoint string, logger *zap.Logger) (*JobPublisher, error) {
+	store, err := internal.CreateEtcdClient(etcdEndpoint)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	publisher, err := newJobPublisher(store, entryTtl, logger)
+	if err != nil {
+		internal.Close(store, logger)
+		return nil, errors.WithStack(err)
+	}
+	return publisher, nil
+}
+
+// newJobPublisher takes ownership of store (closed on Close)
+func newJobPublisher(store *clientv3.Client, ttl time.Duration, logger *zap.Logger) (*JobPublisher, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &JobPublisher{
+		store:     store,
+		ttl:       ttl,
+		leaseId:   atomic.NewInt64(int64(clientv3.NoLease)),
+		pending:   make(map[string]*JobSummary),
+		published: make(map[string]*JobSummary),
+		wakeUp:    make(chan struct{}, 1),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		logger:    logger.Named("jobPublisher"),
+
+		lastKeepAliveTime: atomic.NewInt64(0),
+	}
+
+	keepAliveChannel, err := t.grant(ctx)
+	if err != nil {
+		cancel()
+		return nil, errors.WithStack(err)
+	}
+
+	go t.run(ctx, keepAliveChannel)
+	return t, nil
+}
+
+func (t *JobPublisher) grant(ctx context.Context) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	requestContext, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	leaseGrantResponse, err := t.store.Grant(requestContext, int64(t.ttl/time.Second))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// keep alive is stopped on ctx cancel (and not on requestContext)
+	keepAliveChannel, err := t.store.KeepAlive(ctx, leaseGrantResponse.ID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	t.leaseId.Store(int64(leaseGrantResponse.ID))
+	t.lastKeepAliveTime.Store(time.Now().UnixNano())
+	return keepAliveChannel, nil
+}
+
+// grantWithRetry returns nil if ctx is cancelled
+func (t *JobPublisher) grantWithRetry(ctx context.Context) <-chan *clientv3.LeaseKeepAliveResponse {
+	delay := minRegisterRetryDelay
+	for {
+		keepAliveChannel, err := t.grant(ctx)
+		if err == nil {
+			return keepAliveChannel
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		t.logger.Warn("cannot grant lease", zap.Error(err), zap.Duration("retryIn", delay))
+		if !sleep(ctx, delay) {
+			return nil
+		}
+		delay = nextRetryDelay(delay)
+	}
+}
+
+// IsAlive returns false if lease is not renewed in time (etcd is not available or lease is lost and not yet granted again).
+func (t *JobPublisher) IsAlive() bool {
+	return time.Since(time.Unix(0, t.lastKeepAliveTime.Load())) < t.ttl
+}
+
+func (t *JobPublisher) Publish(summary JobSummary) {
+	t.enqueue(summary.Id, &summary)
+}
+
+func (t *JobPublisher) Remove(id string) {
+	t.enqueue(id, nil)
+}
+
+func (t *JobPublisher) enqueue(id string, summary *JobSummary) {
+	t.lock.Lock()
+	t.pending[id] = summary
+	t.lock.Unlock()
+
+	select {
+	case t.wakeUp <- struct{}{}:
+	default:
+		// already notified
+	}
+}
+
+func (t *JobPublisher) run(ctx context.Context, keepAliveChannel <-chan *clientv3.LeaseKeepAliveResponse) {
+	defer close(t.done)
+
+	retryDelay := minRegisterRetryDelay
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case _, ok := <-keepAliveChannel:
+			if ok {
+				t.lastKeepAliveTime.Store(time.Now().UnixNano())
+				continue
+			}
+
+			// channel is closed on cancel or if lease cannot be renewed anymore (expired or revoked)
+			if ctx.Err() != nil {
+				return
+			}
+
+			t.logger.Warn("job publisher lease is lost", zap.String("solution", "lease will be granted again and jobs will be published again"))
+			keepAliveChannel = t.grantWithRetry(ctx)
+			if keepAliveChannel == nil {
+				return
+			}
+			// job entries are deleted with the lost lease
+			t.republish()
+
+		case <-t.wakeUp:
+		case <-retry:
+		}
+
+		if t.flush(ctx) {
+			retry = nil
+			retryDelay = minRegisterRetryDelay
+		} else if ctx.Err() == nil {
+			retry = time.After(retryDelay)
+			retryDelay = nextRetryDelay(retryDelay)
+		}
+	}
+}
+
+func (t *JobPublisher) republish() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for id, summary := range t.published {
+		// operation requested after lost lease wins
+		if _, isPending := t.pending[id]; !isPending {
+			t.pending[id] = summary
+		}
+	}
+}
+
+// flush applies pending operations, returns false if some operation is failed (failed operation is kept pending if not superseded).
+func (t *JobPublisher) flush(ctx context.Context) bool {
+	t.lock.Lock()
+	operations := t.pending
+	t.pending = make(map[string]*JobSummary)
+	t.lock.Unlock()
+
+	isOk := true
+	for id, summary := range operations {
+		err := t.apply(ctx, id, summary)
+
+		t.lock.Lock()
+		if err == nil {
+			if summary == nil {
+				delete(t.published, id)
+			} else {
+				t.published[id] = summary
+			}
+		} else if _, isPending := t.pending[id]; !isPending {
+			t.pending[id] = summary
+		}
+		t.lock.Unlock()
+
+		if err != nil {
+			isOk = false
+			if ctx.Err() != nil {
+				return false
+			}
+			t.logger.Error("cannot publish job", zap.String("jobId", id), zap.Error(err))
+		}
+	}
+	return isOk
+}
+
+func (t *JobPublisher) apply(ctx context.Context, id string, summary *JobSummary) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	if summary == nil {
+		_, err := t.store.Delete(ctx, jobKeyPrefix+id)
+		return errors.WithStack(err)
+	}
+
+	value, err := jsoniter.ConfigFastest.Marshal(summary)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = t.store.Put(ctx, jobKeyPrefix+id, string(value), clientv3.WithLease(clientv3.LeaseID(t.leaseId.Load())))
+	return errors.WithStack(err)
+}
+
+func (t *JobPublisher) Close() error {
+	t.cancel()
+	<-t.done
+
+	defer internal.Close(t.store, t.logger)
+
+	ctx, cancel := context.WithTimeout(context.Background(), revokeTimeout)
+	defer cancel()
+	_, err := t.store.Revoke(ctx, clientv3.LeaseID(t.leaseId.Load()))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func decodeJobSummary(value []byte) (*JobSummary, error) {
+	var summary JobSummary
+	err := jsoniter.ConfigFastest.Unmarshal(value, &summary)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	summary.ComputeDurations(time.Now())
+	return &summary, nil
+}
@@ -0,0 +1,110 @@
+package agentRegistry
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"go.uber.org/zap"
+)
+
+// waitForJobs waits until published job ids are equal to expected
+func waitForJobs(t *testing.T, client *clientv3.Client, expected ...string) map[string]clientv3.LeaseID {
+	expected = append([]string{}, expected...)
+	sort.Strings(expected)
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		response, err := client.Get(context.Background(), jobKeyPrefix, clientv3.WithPrefix())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		jobs := make(map[string]clientv3.LeaseID)
+		ids := []string{}
+		for _, kv := range response.Kvs {
+			id := string(kv.Key[len(jobKeyPrefix):])
+			jobs[id] = clientv3.LeaseID(kv.Lease)
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		if reflect.DeepEqual(ids, expected) {
+			return jobs
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected jobs %v, actual %v", expected, ids)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestJobPublisherPublishesAgainOnLeaseLoss(t *testing.T) {
+	server, stop := startEtcd(t)
+	defer stop()
+
+	client := createTestClient(t, server)
+	defer client.Close()
+
+	publisher, err := newJobPublisher(createTestClient(t, server), testEntryTtl, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+
+	publisher.Publish(JobSummary{Id: "a"})
+	publisher.Publish(JobSummary{Id: "b"})
+	publisher.Remove("b")
+	jobs := waitForJobs(t, client, "a")
+	oldLeaseId := jobs["a"]
+
+	// e.g. lease is expired because etcd was not available for a long time
+	_, err = client.Revoke(context.Background(), oldLeaseId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher.Publish(JobSummary{Id: "c"})
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		jobs = waitForJobs(t, client, "a", "c")
+		if jobs["a"] != oldLeaseId && jobs["a"] == jobs["c"] {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("jobs are not published again with the new lease: %v", jobs)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if !publisher.IsAlive() {
+		t.Error("publisher must be alive after lease is granted again")
+	}
+}
+
+func TestJobPublisherDoesNotDropRemoval(t *testing.T) {
+	server, stop := startEtcd(t)
+	defer stop()
+
+	client := createTestClient(t, server)
+	defer client.Close()
+
+	publisher, err := newJobPublisher(createTestClient(t, server), testEntryTtl, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+
+	// more operations than any reasonable buffer, all are enqueued without blocking
+	for i := 0; i < 2000; i++ {
+		publisher.Publish(JobSummary{Id: strconv.Itoa(i % 500)})
+	}
+	for i := 0; i < 499; i++ {
+		publisher.Remove(strconv.Itoa(i))
+	}
+
+	waitForJobs(t, client, "499")
+}
@@ -0,0 +1,52 @@
+package agentRegistry
+
+import (
+	"time"
+)
+
+const JobStatePending = "pending"
+const JobStateRunning = "running"
+const JobStateCompleted = "completed"
+
+// JobSummary is published by agent to the registry and used by admin API (agent and router).
+type JobSummary struct {
+	Id string `json:"id"`
+	// address of the agent that owns job
+	Agent    string   `json:"agent"`
+	Tenant   string   `json:"tenant"`
+	State    string   `json:"state"`
+	Platform string   `json:"platform"`
+	Targets  []string `json:"targets"`
+	ClientIp string   `json:"clientIp"`
+
+	UploadSize int64 `json:"uploadSize"`
+
+	QueuedAt    time.Time  `json:"queuedAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+
+	// in milliseconds, time in queue (until now if still pending)
+	QueueTime int64 `json:"queueTime"`
+	// in milliseconds, build time (until now if still running)
+	RunTime int64 `json:"runTime"`
+}
+
+// ComputeDurations updates QueueTime and RunTime (published summary is not updated while job is pending or running).
+func (t *JobSummary) ComputeDurations(now time.Time) {
+	if t.StartedAt == nil {
+		t.QueueTime = toMilliseconds(now.Sub(t.QueuedAt))
+		t.RunTime = 0
+		return
+	}
+
+	t.QueueTime = toMilliseconds(t.StartedAt.Sub(t.QueuedAt))
+	if t.CompletedAt == nil {
+		t.RunTime = toMilliseconds(now.Sub(*t.StartedAt))
+	} else {
+		t.RunTime = toMilliseconds(t.CompletedAt.Sub(*t.StartedAt))
+	}
+}
+
+func toMilliseconds(duration time.Duration) int64 {
+	return int64(duration / time.Millisecond)
+}
@@ -0,0 +1,356 @@
+package archive
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/develar/errors"
+	"github.com/klauspost/compress/zstd"
+)
+
+// max window size allowed by zstd --long
+const maxWindowSize = 128 << 20
+
+type Limits struct {
+	MaxFileCount int
+	// total size of extracted files
+	MaxUncompressedSize int64
+	// max ratio of uncompressed to compressed size, checked only if uncompressed size is greater than RatioCheckThreshold
+	MaxCompressionRatio float64
+	RatioCheckThreshold int64
+}
+
+// ValidationError is returned if archive is not safe to extract (archive is untrusted client input).
+// Entry is empty if archive is rejected as a whole (e.g. compression ratio limit is exceeded).
+type ValidationError struct {
+	Entry  string
+	Reason string
+}
+
+func (t *ValidationError) Error() string {
+	if t.Entry == "" {
+		return "archive is rejected: " + t.Reason
+	}
+	return fmt.Sprintf("archive entry %q is rejected: %s", t.Entry, t.Reason)
+}
+
+// ExtractTarZstd extracts zstd compressed tar to the dir. Only regular files, directories and links within the dir are allowed (link target must not contain .. or pass through symlink).
+// Files are written as is, so, the caller should remove the dir if error is returned.
+func ExtractTarZstd(ctx context.Context, reader io.Reader, dir string, limits Limits) error {
+	compressed := &countingReader{reader: reader, ctx: ctx}
+	decoder, err := zstd.NewReader(compressed, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(maxWindowSize))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer decoder.Close()
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// dir itself can be under symlink (e.g. /tmp on macOS), resolved paths of entries are compared with resolved dir
+	resolvedDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	extractor := &extractor{
+		dir:          dir,
+		resolvedDir:  resolvedDir,
+		limits:       limits,
+		compressed:   compressed,
+		uncompressed: &countingReader{reader: decoder, ctx: ctx},
+		symlinks:     make(map[string]bool),
+	}
+	return extractor.extract()
+}
+
+type extractor struct {
+	dir         string
+	resolvedDir string
+	limits      Limits
+
+	compressed   *countingReader
+	uncompressed *countingReader
+
+	fileCount int
+	// symlinks created by archive, entries must not be extracted through symlink
+	symlinks map[string]bool
+}
+
+func (t *extractor) extract() error {
+	tarReader := tar.NewReader(t)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = t.extractEntry(tarReader, header)
+		if err != nil {
+			if validationError, ok := err.(*ValidationError); ok && validationError.Entry == "" {
+				// limit is exceeded while reading entry
+				validationError.Entry = header.Name
+			}
+			return err
+		}
+	}
+}
+
+// Read reads uncompressed tar stream and checks limits while reading (to not decompress zip bomb entirely).
+// Size of tar stream is checked (not only file sizes), so, tar headers and padding are also counted.
+func (t *extractor) Read(p []byte) (int, error) {
+	n, err := t.uncompressed.Read(p)
+	sizeErr := t.checkSize()
+	if sizeErr != nil {
+		return n, sizeErr
+	}
+	return n, err
+}
+
+func (t *extractor) checkSize() error {
+	uncompressedSize := t.uncompressed.count
+	if uncompressedSize > t.limits.MaxUncompressedSize {
+		return &ValidationError{Reason: fmt.Sprintf("uncompressed size exceeds %d bytes", t.limits.MaxUncompressedSize)}
+	}
+
+	if uncompressedSize > t.limits.RatioCheckThreshold && t.compressed.count > 0 {
+		ratio := float64(uncompressedSize) / float64(t.compressed.count)
+		if ratio > t.limits.MaxCompressionRatio {
+			return &ValidationError{Reason: fmt.Sprintf("compression ratio %.0f exceeds %.0f", ratio, t.limits.MaxCompressionRatio)}
+		}
+	}
+	return nil
+}
+
+func (t *extractor) extractEntry(reader io.Reader, header *tar.Header) error {
+	if header.Typeflag == tar.TypeXGlobalHeader {
+		return nil
+	}
+
+	name, err := validateName(header.Name)
+	if err != nil {
+		return err
+	}
+
+	if name == "." {
+		if header.Typeflag == tar.TypeDir {
+			return nil
+		}
+		return &ValidationError{Entry: header.Name, Reason: "invalid name"}
+	}
+
+	t.fileCount++
+	if t.fileCount > t.limits.MaxFileCount {
+		return &ValidationError{Entry: header.Name, Reason: fmt.Sprintf("number of files exceeds %d", t.limits.MaxFileCount)}
+	}
+
+	if t.isThroughSymlink(name) {
+		return &ValidationError{Entry: header.Name, Reason: "path contains symlink"}
+	}
+
+	file := filepath.Join(t.dir, filepath.FromSlash(name))
+	switch header.Typeflag {
+	case tar.TypeDir:
+		err = t.checkResolved(header.Name, file)
+		if err != nil {
+			return err
+		}
+		return errors.WithStack(os.MkdirAll(file, os.FileMode(header.Mode).Perm()|0700))
+
+	case tar.TypeReg, tar.TypeRegA:
+		return t.writeFile(file, reader, header)
+
+	case tar.TypeSymlink:
+		if path.IsAbs(header.Linkname) {
+			return &ValidationError{Entry: header.Name, Reason: "symlink target is absolute"}
+		}
+		// without .. symlink can point only to its dir or below, so, chain of symlinks cannot lead outside (e.g. q -> . and p -> q/..)
+		if hasParentReference(header.Linkname) {
+			return &ValidationError{Entry: header.Name, Reason: "symlink target contains .."}
+		}
+		target := path.Join(path.Dir(name), header.Linkname)
+		if t.isThroughSymlink(target) {
+			return &ValidationError{Entry: header.Name, Reason: "symlink target path contains symlink"}
+		}
+
+		err = t.prepareTarget(header.Name, file)
+		if err != nil {
+			return err
+		}
+		err = t.checkResolved(header.Name, filepath.Join(t.dir, filepath.FromSlash(target)))
+		if err != nil {
+			return err
+		}
+		t.symlinks[name] = true
+		return errors.WithStack(os.Symlink(header.Linkname, file))
+
+	case tar.TypeLink:
+		if hasParentReference(header.Linkname) {
+			return &ValidationError{Entry: header.Name, Reason: "hard link target contains .."}
+		}
+		target, err := validateName(header.Linkname)
+		if err != nil || target == "." || t.symlinks[target] || t.isThroughSymlink(target) {
+			return &ValidationError{Entry: header.Name, Reason: "hard link target is outside of archive"}
+		}
+
+		err = t.prepareTarget(header.Name, file)
+		if err != nil {
+			return err
+		}
+		targetFile := filepath.Join(t.dir, filepath.FromSlash(target))
+		// link is created to the target itself (not followed), so, only parent dir is resolved
+		err = t.checkResolved(header.Name, filepath.Dir(targetFile))
+		if err != nil {
+			return err
+		}
+		return errors.WithStack(os.Link(targetFile, file))
+
+	default:
+		return &ValidationError{Entry: header.Name, Reason: fmt.Sprintf("unsupported entry type %q", header.Typeflag)}
+	}
+}
+
+func (t *extractor) writeFile(file string, reader io.Reader, header *tar.Header) error {
+	err := t.prepareTarget(header.Name, file)
+	if err != nil {
+		return err
+	}
+
+	// setuid, setgid and sticky bits are not preserved
+	out, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(header.Mode).Perm()|0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = io.Copy(out, reader)
+	closeErr := out.Close()
+	if err != nil {
+		return err
+	}
+	return errors.WithStack(closeErr)
+}
+
+// isThroughSymlink checks whether some parent of the cleaned name is a symlink created by archive
+func (t *extractor) isThroughSymlink(name string) bool {
+	for parent := path.Dir(name); parent != "."; parent = path.Dir(parent) {
+		if t.symlinks[parent] {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareTarget creates parent dir and removes existing file (to not write through existing symlink)
+func (t *extractor) prepareTarget(entry string, file string) error {
+	parent := filepath.Dir(file)
+	err := t.checkResolved(entry, parent)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(parent, 0755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	info, err := os.Lstat(file)
+	if err == nil {
+		if info.IsDir() {
+			return errors.Errorf("cannot replace directory %s", file)
+		}
+		err = os.Remove(file)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// checkResolved resolves symlinks of the nearest resolvable ancestor of the file (file itself if exists) and checks that resolved path is within the dir.
+// Entry names are validated without touching file system, it is the last line of defence if some symlink is not caught by validation.
+func (t *extractor) checkResolved(entry string, file string) error {
+	existing := file
+	var resolved string
+	for {
+		var err error
+		resolved, err = filepath.EvalSymlinks(existing)
+		if err == nil {
+			break
+		}
+		// not yet extracted file or dangling symlink
+		if !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return errors.WithStack(err)
+		}
+		existing = parent
+	}
+
+	if resolved != t.resolvedDir && !strings.HasPrefix(resolved, t.resolvedDir+string(filepath.Separator)) {
+		return &ValidationError{Entry: entry, Reason: "resolved path is outside of archive"}
+	}
+	return nil
+}
+
+// validateName returns cleaned name (relative, slash-separated)
+func validateName(name string) (string, error) {
+	if name == "" || strings.ContainsRune(name, 0) {
+		return "", &ValidationError{Entry: name, Reason: "invalid name"}
+	}
+	if path.IsAbs(name) {
+		return "", &ValidationError{Entry: name, Reason: "absolute path"}
+	}
+
+	cleaned := path.Clean(name)
+	if isOutside(cleaned) {
+		return "", &ValidationError{Entry: name, Reason: "path is outside of archive"}
+	}
+	return cleaned, nil
+}
+
+// hasParentReference checks whether slash-separated (not cleaned) path contains .. component
+func hasParentReference(name string) bool {
+	for _, component := range strings.Split(name, "/") {
+		if component == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// isOutside checks cleaned relative path
+func isOutside(cleaned string) bool {
+	return cleaned == ".." || strings.HasPrefix(cleaned, "../")
+}
+
+type countingReader struct {
+	reader io.Reader
+	ctx    context.Context
+	count  int64
+}
+
+func (t *countingReader) Read(p []byte) (int, error) {
+	err := t.ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := t.reader.Read(p)
+	t.count += int64(n)
+	return n, err
+}
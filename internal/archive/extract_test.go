@@ -0,0 +1,207 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var testLimits = Limits{
+	MaxFileCount:        10,
+	MaxUncompressedSize: 1 << 20,
+	MaxCompressionRatio: 50,
+	RatioCheckThreshold: 64 * 1024,
+}
+
+type entry struct {
+	header  tar.Header
+	content []byte
+}
+
+func file(name string, content string) entry {
+	return entry{header: tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}, content: []byte(content)}
+}
+
+func dir(name string) entry {
+	return entry{header: tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0755}}
+}
+
+func link(name string, typeFlag byte, target string) entry {
+	return entry{header: tar.Header{Name: name, Typeflag: typeFlag, Linkname: target}}
+}
+
+func createArchive(t *testing.T, entries ...entry) []byte {
+	var tarData bytes.Buffer
+	writer := tar.NewWriter(&tarData)
+	for _, e := range entries {
+		header := e.header
+		err := writer.WriteHeader(&header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = writer.Write(e.content)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result bytes.Buffer
+	encoder, err := zstd.NewWriter(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encoder.Write(tarData.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encoder.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.Bytes()
+}
+
+func extract(t *testing.T, data []byte) (string, error) {
+	dir, err := ioutil.TempDir("", "extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return dir, ExtractTarZstd(context.Background(), bytes.NewReader(data), filepath.Join(dir, "project"), testLimits)
+}
+
+func TestExtract(t *testing.T) {
+	dir, err := extract(t, createArchive(t,
+		dir("node_modules/"),
+		file("package.json", `{"name": "test"}`),
+		file("node_modules/foo/index.js", "module.exports = 42"),
+		link("node_modules/foo/main.js", tar.TypeSymlink, "index.js"),
+		link("node_modules/bar", tar.TypeSymlink, "foo"),
+		link("index.js", tar.TypeLink, "node_modules/foo/index.js"),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	project := filepath.Join(dir, "project")
+	content, err := ioutil.ReadFile(filepath.Join(project, "node_modules", "bar", "main.js"))
+	if err != nil || string(content) != "module.exports = 42" {
+		t.Errorf("symlink is not extracted: %s %v", content, err)
+	}
+	content, err = ioutil.ReadFile(filepath.Join(project, "index.js"))
+	if err != nil || string(content) != "module.exports = 42" {
+		t.Errorf("hard link is not extracted: %s %v", content, err)
+	}
+	content, err = ioutil.ReadFile(filepath.Join(project, "package.json"))
+	if err != nil || string(content) != `{"name": "test"}` {
+		t.Errorf("file is not extracted: %s %v", content, err)
+	}
+}
+
+func TestRejectUnsafeEntries(t *testing.T) {
+	testCases := []struct {
+		name    string
+		entries []entry
+		entry   string
+	}{
+		{"absolute path", []entry{file("/etc/passwd", "x")}, "/etc/passwd"},
+		{"parent", []entry{file("../evil", "x")}, "../evil"},
+		{"nested parent", []entry{file("a/../../evil", "x")}, "a/../../evil"},
+		{"absolute symlink", []entry{link("passwd", tar.TypeSymlink, "/etc/passwd")}, "passwd"},
+		{"symlink outside", []entry{link("a/up", tar.TypeSymlink, "../..")}, "a/up"},
+		{"symlink target with parent", []entry{link("a/b", tar.TypeSymlink, "../c")}, "a/b"},
+		{"path through symlink", []entry{link("self", tar.TypeSymlink, "."), link("self/up", tar.TypeSymlink, "..")}, "self/up"},
+		{"chained symlink", []entry{link("q", tar.TypeSymlink, "."), link("p", tar.TypeSymlink, "q/.."), file("p/evil", "x")}, "p"},
+		{"symlink target through symlink", []entry{link("q", tar.TypeSymlink, "."), link("p", tar.TypeSymlink, "q/x")}, "p"},
+		{"hard link through symlink", []entry{link("q", tar.TypeSymlink, "."), file("x", "x"), link("p", tar.TypeLink, "q/x")}, "p"},
+		{"hard link outside", []entry{link("passwd", tar.TypeLink, "../../etc/passwd")}, "passwd"},
+		{"device", []entry{{header: tar.Header{Name: "null", Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3}}}, "null"},
+		{"fifo", []entry{{header: tar.Header{Name: "fifo", Typeflag: tar.TypeFifo}}}, "fifo"},
+	}
+
+	for _, testCase := range testCases {
+		dir, err := extract(t, createArchive(t, testCase.entries...))
+		validationError, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("%s: expected validation error, got %v", testCase.name, err)
+			continue
+		}
+		if validationError.Entry != testCase.entry {
+			t.Errorf("%s: unexpected rejected entry %q", testCase.name, validationError.Entry)
+		}
+
+		_, err = os.Lstat(filepath.Join(dir, "evil"))
+		if !os.IsNotExist(err) {
+			t.Errorf("%s: file is written outside of dir", testCase.name)
+		}
+	}
+}
+
+// symlink that is not created by archive (i.e. not known to validation) is caught by the resolved path check
+func TestRejectResolvedPathOutside(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	project := filepath.Join(tempDir, "project")
+	err = os.MkdirAll(project, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(tempDir, filepath.Join(project, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []entry{file("out/evil", "x"), dir("out/evil/"), link("out/evil", tar.TypeSymlink, "x"), link("evil", tar.TypeSymlink, "out/evil")} {
+		err = ExtractTarZstd(context.Background(), bytes.NewReader(createArchive(t, e)), project, testLimits)
+		if validationError, ok := err.(*ValidationError); !ok || validationError.Entry != e.header.Name {
+			t.Errorf("%s: expected validation error, got %v", e.header.Name, err)
+		}
+
+		_, err = os.Lstat(filepath.Join(tempDir, "evil"))
+		if !os.IsNotExist(err) {
+			t.Fatalf("%s: file is written outside of dir", e.header.Name)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	var entries []entry
+	for i := 0; i < testLimits.MaxFileCount+1; i++ {
+		entries = append(entries, file(string(rune('a'+i)), "x"))
+	}
+	_, err := extract(t, createArchive(t, entries...))
+	if validationError, ok := err.(*ValidationError); !ok || validationError.Entry != "k" {
+		t.Errorf("file count limit is not enforced: %v", err)
+	}
+
+	// zeros are compressed extremely well
+	_, err = extract(t, createArchive(t, file("bomb", string(make([]byte, 512*1024)))))
+	if validationError, ok := err.(*ValidationError); !ok || validationError.Entry != "bomb" {
+		t.Errorf("compression ratio limit is not enforced: %v", err)
+	}
+
+	// random data is not compressible
+	big := make([]byte, 2<<20)
+	rand.New(rand.NewSource(42)).Read(big)
+	_, err = extract(t, createArchive(t, file("big", string(big))))
+	if validationError, ok := err.(*ValidationError); !ok || validationError.Entry != "big" {
+		t.Errorf("uncompressed size limit is not enforced: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package buildHistory
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/develar/errors"
+	"github.com/json-iterator/go"
+	"go.etcd.io/bbolt"
+)
+
+var buildsBucket = []byte("builds")
+
+// database is opened only for the duration of operation (build completes not so often), so, query tool can read history while agent is running
+const openTimeout = 5 * time.Second
+
+// BoltStore is an embedded build history store. Records are keyed by job id (KSUID is sortable by time).
+type BoltStore struct {
+	file string
+}
+
+func NewBoltStore(file string) (*BoltStore, error) {
+	err := os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	store := &BoltStore{file: file}
+	err = store.update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(buildsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (t *BoltStore) update(task func(tx *bbolt.Tx) error) error {
+	db, err := bbolt.Open(t.file, 0600, &bbolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = db.Update(task)
+	closeErr := db.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(closeErr)
+}
+
+func (t *BoltStore) Write(record *Record) error {
+	value, err := jsoniter.ConfigFastest.Marshal(record)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return t.update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(buildsBucket).Put([]byte(record.JobId), value)
+	})
+}
+
+// Query returns records that match filter, ordered by job id (i.e. by job creation time).
+func (t *BoltStore) Query(filter Filter) ([]*Record, error) {
+	db, err := bbolt.Open(t.file, 0600, &bbolt.Options{Timeout: openTimeout, ReadOnly: true})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer db.Close()
+
+	var result []*Record
+	err = db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(buildsBucket)
+		if bucket == nil {
+			return nil
+		}
+
+		// iterate from the latest to apply limit
+		cursor := bucket.Cursor()
+		for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+			var record Record
+			err := jsoniter.ConfigFastest.Unmarshal(value, &record)
+			if err != nil {
+				return errors.Wrapf(err, "cannot decode record %s", key)
+			}
+
+			if !filter.matches(&record) {
+				continue
+			}
+
+			result = append(result, &record)
+			if filter.Limit > 0 && len(result) >= filter.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// reverse to chronological order
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result, nil
+}
+
+func (t *BoltStore) Close() error {
+	return nil
+}
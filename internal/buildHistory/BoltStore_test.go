@@ -0,0 +1,74 @@
+package buildHistory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewBoltStore(filepath.Join(dir, "history", "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// query of empty store
+	records, err := store.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("empty store returned records: %v", len(records))
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	inputs := []*Record{
+		{JobId: "1", Tenant: "a", Targets: []string{"appimage"}, CompletedAt: now.Add(-3 * time.Hour), Outcome: OutcomeSuccess, ArtifactSizes: []int64{42}},
+		{JobId: "2", Tenant: "b", Targets: []string{"snap"}, CompletedAt: now.Add(-2 * time.Hour), Outcome: OutcomeFailure, ErrorClass: "builder"},
+		{JobId: "3", Tenant: "a", Targets: []string{"snap", "deb"}, CompletedAt: now.Add(-1 * time.Hour), Outcome: OutcomeSuccess},
+		{JobId: "4", Tenant: "a", Targets: []string{"appimage"}, CompletedAt: now, Outcome: OutcomeCancelled},
+	}
+	for _, record := range inputs {
+		err = store.Write(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err = store.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, inputs) {
+		t.Errorf("records were not properly stored: %v", records)
+	}
+
+	assertIds(t, store, Filter{Tenant: "a"}, "1", "3", "4")
+	assertIds(t, store, Filter{Target: "snap"}, "2", "3")
+	assertIds(t, store, Filter{Since: now.Add(-90 * time.Minute)}, "3", "4")
+	assertIds(t, store, Filter{Tenant: "a", Limit: 2}, "3", "4")
+}
+
+func assertIds(t *testing.T, store *BoltStore, filter Filter, expected ...string) {
+	records, err := store.Query(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]string, len(records))
+	for index, record := range records {
+		ids[index] = record.JobId
+	}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("unexpected records for filter %+v: %v, expected: %v", filter, ids, expected)
+	}
+}
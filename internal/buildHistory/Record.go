@@ -0,0 +1,71 @@
+package buildHistory
+
+import (
+	"time"
+)
+
+const OutcomeSuccess = "success"
+const OutcomeFailure = "failure"
+const OutcomeCancelled = "cancelled"
+
+// client closed connection before build completed
+const OutcomeAborted = "aborted"
+
+// Record is written for every job as it finishes.
+type Record struct {
+	JobId    string   `json:"jobId"`
+	Tenant   string   `json:"tenant"`
+	Platform string   `json:"platform"`
+	Targets  []string `json:"targets"`
+	Archs    []string `json:"archs"`
+
+	ElectronVersion string `json:"electronVersion,omitempty"`
+
+	CompletedAt time.Time `json:"completedAt"`
+
+	// in milliseconds
+	UploadTime   int64 `json:"uploadTime"`
+	QueueTime    int64 `json:"queueTime"`
+	BuildTime    int64 `json:"buildTime"`
+	DownloadTime int64 `json:"downloadTime"`
+
+	UploadSize    int64   `json:"uploadSize"`
+	ArtifactSizes []int64 `json:"artifactSizes,omitempty"`
+
+	Outcome string `json:"outcome"`
+	// stage where job failed (e.g. upload, electron, builder, internal), empty if job is successful
+	ErrorClass string `json:"errorClass,omitempty"`
+}
+
+// Sink receives history records. Implementation must be thread-safe.
+type Sink interface {
+	Write(record *Record) error
+	Close() error
+}
+
+type Filter struct {
+	// zero to not filter
+	Since  time.Time
+	Tenant string
+	Target string
+	// zero for no limit, the latest records are returned
+	Limit int
+}
+
+func (t *Filter) matches(record *Record) bool {
+	if !t.Since.IsZero() && record.CompletedAt.Before(t.Since) {
+		return false
+	}
+	if t.Tenant != "" && record.Tenant != t.Tenant {
+		return false
+	}
+	if t.Target != "" {
+		for _, target := range record.Targets {
+			if target == t.Target {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
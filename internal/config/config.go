@@ -0,0 +1,502 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/develar/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the effective configuration of the build agent.
+// Sources (in order of precedence, later overrides earlier): defaults, config file (YAML or TOML), env, flags.
+// Exception: tracing is configured by standard OTEL_* env vars (see tracing.Configure), so, the same deployment settings work for all OpenTelemetry instrumented services.
+type Config struct {
+	Port string `json:"port"`
+	// external host of the agent (determined automatically if not set)
+	Host string `json:"host"`
+	// 4 or 6, used to determine external host
+	PreferredIpVersion string `json:"preferredIpVersion"`
+	// network interface to take external host from (interface resolver)
+	HostInterface string `json:"hostInterface"`
+	// resolvers to determine external host, tried in order (see AddressResolvers)
+	AddressResolvers []string `json:"addressResolvers"`
+	// temp dir for builds (emptied on start)
+	TmpDir string `json:"tmpDir"`
+	// dir that contains node_modules with app-builder-lib
+	NodeModules  string `json:"nodeModules"`
+	EtcdEndpoint string `json:"etcdEndpoint"`
+	UseSsl       bool   `json:"useSsl"`
+	// CA bundle to verify client certificates (mutual TLS is enabled if set)
+	TlsClientCa string `json:"tlsClientCa"`
+	// identity (common name) of router client certificate, client identity forwarded by router (proxyBuilds) is trusted only if request is authenticated by it
+	RouterIdentity string `json:"routerIdentity"`
+	// CIDRs (or IPs) of reverse proxies (e.g. load balancer) that are trusted to set X-Forwarded-For, client IP is used as tenant for fair scheduling
+	TrustedProxies []string `json:"trustedProxies"`
+	// console or json
+	LogEncoding string `json:"logEncoding"`
+	WorkerCount int    `json:"workerCount"`
+	// serve /find-build-agent in the build agent (disable if dedicated router is deployed)
+	Router bool `json:"router"`
+	// file with secret key to sign routing tokens (tokens are not issued if not set)
+	RoutingKeyFile  string   `json:"routingKeyFile"`
+	RoutingTokenTtl Duration `json:"routingTokenTtl"`
+	// CA bundle to verify agent certificates if router proxies requests to agents (system roots are used if not set)
+	AgentCa string `json:"agentCa"`
+	// dedicated router accepts builds and proxies them to selected agent (agents don't need to be publicly reachable)
+	ProxyBuilds bool `json:"proxyBuilds"`
+	// token to authorize admin API requests (admin API is disabled if not set), redacted in /admin/config
+	AdminToken string `json:"adminToken"`
+	// priority or fair (fair share across clients, to not allow one client to starve everyone else)
+	SchedulingPolicy string `json:"schedulingPolicy"`
+	// path to the build history database (builder-history/history.db if not set), "off" to disable
+	HistoryDb string `json:"historyDb"`
+	// path to PEM encoded private key file to sign provenance attestations (provenance is not generated if not set)
+	ProvenanceKey string `json:"provenanceKey"`
+
+	// live-reloadable fields
+
+	JobMaxTime Duration `json:"jobMaxTime"`
+	// in bytes
+	MaxRequestBody int64      `json:"maxRequestBody"`
+	RateLimits     RateLimits `json:"rateLimits"`
+	// tenant -> weight for fair scheduling policy (the more weight, the more jobs are done for the tenant compared to others), 1 if not specified
+	TenantWeights map[string]float64 `json:"tenantWeights"`
+}
+
+type RateLimits struct {
+	Build RateLimit `json:"build"`
+	// client uses app-builder downloader that does parallel requests, so, limit is soft
+	Download RateLimit `json:"download"`
+	Job      RateLimit `json:"job"`
+	Router   RateLimit `json:"router"`
+}
+
+// RateLimit is applied per client IP.
+type RateLimit struct {
+	// requests per second
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Duration is serialized as string (e.g. 30m).
+type Duration time.Duration
+
+func (t Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(t).String())
+}
+
+func (t *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	*t = Duration(duration)
+	return nil
+}
+
+// AddressResolvers lists all address resolvers in default order:
+// config (host field), interface (hostInterface field), kubernetes (downward API env NODE_IP or POD_IP), cloudMetadata (AWS, GCP, Azure, DigitalOcean), external (myexternalip.com).
+var AddressResolvers = []string{"config", "interface", "kubernetes", "cloudMetadata", "external"}
+
+func defaultConfig() Config {
+	return Config{
+		Port: "443",
+		// etcd-operator creates this service discovery entry by default (https://github.com/coreos/etcd-operator/blob/master/doc/user/client_service.md),
+		// so, defaults provided for k8s (rancher), for docker env can be used to customize
+		EtcdEndpoint: "http://etcd-client:2379",
+		// copy, because file decoding reuses slice
+		AddressResolvers: append([]string(nil), AddressResolvers...),
+		UseSsl:           true,
+		LogEncoding:      "console",
+		WorkerCount:      runtime.NumCPU() + 1,
+		Router:           true,
+		// artifacts are kept on agent only until downloaded, so, token is not needed for a long time
+		RoutingTokenTtl:  Duration(1 * time.Hour),
+		SchedulingPolicy: "priority",
+
+		JobMaxTime:     Duration(30 * time.Minute),
+		MaxRequestBody: 768 * 1024 * 1024,
+		RateLimits: RateLimits{
+			Build:    RateLimit{Rate: 1, Burst: 10},
+			Download: RateLimit{Rate: 10, Burst: 100},
+			Job:      RateLimit{Rate: 1, Burst: 10},
+			Router:   RateLimit{Rate: 1, Burst: 10},
+		},
+	}
+}
+
+// env name -> flag name
+var envBindings = []struct {
+	env  string
+	flag string
+}{
+	{"BUILDER_PORT", "port"},
+	{"BUILDER_HOST", "host"},
+	{"PREFERRED_IP_VERSION", "preferred-ip-version"},
+	{"BUILDER_HOST_INTERFACE", "host-interface"},
+	{"BUILDER_ADDRESS_RESOLVERS", "address-resolvers"},
+	{"APP_BUILDER_TMP_DIR", "tmp-dir"},
+	{"BUILDER_NODE_MODULES", "node-modules"},
+	{"ETCD_ENDPOINT", "etcd-endpoint"},
+	{"USE_SSL", "use-ssl"},
+	{"TLS_CLIENT_CA", "tls-client-ca"},
+	{"LOG_ENCODING", "log-encoding"},
+	{"BUILDER_WORKER_COUNT", "worker-count"},
+	{"BUILDER_ROUTER", "router"},
+	{"BUILDER_ROUTING_KEY_FILE", "routing-key-file"},
+	{"BUILDER_ROUTING_TOKEN_TTL", "routing-token-ttl"},
+	{"BUILDER_AGENT_CA", "agent-ca"},
+	{"BUILDER_PROXY_BUILDS", "proxy-builds"},
+	{"BUILDER_ADMIN_TOKEN", "admin-token"},
+	{"BUILDER_SCHEDULING_POLICY", "scheduling-policy"},
+	{"BUILDER_HISTORY_DB", "history-db"},
+	{"BUILDER_PROVENANCE_KEY", "provenance-key"},
+	{"BUILDER_JOB_MAX_TIME", "job-max-time"},
+	{"BUILDER_MAX_REQUEST_BODY", "max-request-body"},
+	{"BUILDER_BUILD_RATE", "build-rate"},
+	{"BUILDER_BUILD_BURST", "build-burst"},
+	{"BUILDER_DOWNLOAD_RATE", "download-rate"},
+	{"BUILDER_DOWNLOAD_BURST", "download-burst"},
+	{"BUILDER_JOB_RATE", "job-rate"},
+	{"BUILDER_JOB_BURST", "job-burst"},
+	{"BUILDER_ROUTER_RATE", "router-rate"},
+	{"BUILDER_ROUTER_BURST", "router-burst"},
+	{"BUILDER_TENANT_WEIGHTS", "tenant-weights"},
+}
+
+func newFlagSet(config *Config, configFile *string) *flag.FlagSet {
+	flags := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ExitOnError)
+	flags.StringVar(configFile, "config", "", "config file (YAML or TOML), env BUILDER_CONFIG")
+	flags.StringVar(&config.Port, "port", config.Port, "listen port")
+	flags.StringVar(&config.Host, "host", config.Host, "external host of the agent (determined automatically if not set)")
+	flags.StringVar(&config.PreferredIpVersion, "preferred-ip-version", config.PreferredIpVersion, "IP version (4 or 6) to determine external host")
+	flags.StringVar(&config.HostInterface, "host-interface", config.HostInterface, "network interface to determine external host")
+	flags.Var((*stringList)(&config.AddressResolvers), "address-resolvers", "comma-separated resolvers to determine external host, tried in order: "+strings.Join(AddressResolvers, ","))
+	flags.StringVar(&config.TmpDir, "tmp-dir", config.TmpDir, "temp dir for builds (emptied on start)")
+	flags.StringVar(&config.NodeModules, "node-modules", config.NodeModules, "dir that contains node_modules with app-builder-lib")
+	flags.StringVar(&config.EtcdEndpoint, "etcd-endpoint", config.EtcdEndpoint, "etcd endpoint")
+	flags.BoolVar(&config.UseSsl, "use-ssl", config.UseSsl, "serve TLS")
+	flags.StringVar(&config.TlsClientCa, "tls-client-ca", config.TlsClientCa, "CA bundle to verify client certificates (mutual TLS is enabled if set)")
+	flags.StringVar(&config.RouterIdentity, "router-identity", config.RouterIdentity, "identity (common name) of router client certificate, client identity forwarded by router is trusted only from it")
+	flags.Var((*stringList)(&config.TrustedProxies), "trusted-proxies", "comma-separated CIDRs (or IPs) of reverse proxies that are trusted to set X-Forwarded-For")
+	flags.StringVar(&config.LogEncoding, "log-encoding", config.LogEncoding, "log encoding: console or json")
+	flags.IntVar(&config.WorkerCount, "worker-count", config.WorkerCount, "number of concurrent builds")
+	flags.BoolVar(&config.Router, "router", config.Router, "serve /find-build-agent in the build agent (ignored by dedicated router)")
+	flags.StringVar(&config.RoutingKeyFile, "routing-key-file", config.RoutingKeyFile, "file with secret key to sign routing tokens (tokens are not issued if not set)")
+	flags.DurationVar((*time.Duration)(&config.RoutingTokenTtl), "routing-token-ttl", time.Duration(config.RoutingTokenTtl), "routing token lifetime")
+	flags.StringVar(&config.AgentCa, "agent-ca", config.AgentCa, "CA bundle to verify agent certificates if router proxies requests (system roots if not set)")
+	flags.BoolVar(&config.ProxyBuilds, "proxy-builds", config.ProxyBuilds, "dedicated router accepts builds and proxies them to selected agent")
+	flags.StringVar(&config.AdminToken, "admin-token", config.AdminToken, "token to authorize admin API requests, admin API is disabled if not set (prefer env BUILDER_ADMIN_TOKEN, command line is visible to other users)")
+	flags.StringVar(&config.SchedulingPolicy, "scheduling-policy", config.SchedulingPolicy, "job scheduling policy: priority or fair")
+	flags.StringVar(&config.HistoryDb, "history-db", config.HistoryDb, `build history database ("off" to disable)`)
+	flags.StringVar(&config.ProvenanceKey, "provenance-key", config.ProvenanceKey, "path to PEM encoded private key file to sign provenance attestations (provenance is not generated if not set)")
+	flags.DurationVar((*time.Duration)(&config.JobMaxTime), "job-max-time", time.Duration(config.JobMaxTime), "max build time")
+	flags.Int64Var(&config.MaxRequestBody, "max-request-body", config.MaxRequestBody, "max upload size in bytes")
+	addRateLimitFlags(flags, "build", &config.RateLimits.Build)
+	addRateLimitFlags(flags, "download", &config.RateLimits.Download)
+	addRateLimitFlags(flags, "job", &config.RateLimits.Job)
+	addRateLimitFlags(flags, "router", &config.RateLimits.Router)
+	flags.Var((*weightMap)(&config.TenantWeights), "tenant-weights", "comma-separated tenant=weight pairs for fair scheduling policy (weight is 1 if not specified)")
+	return flags
+}
+
+// stringList is a comma-separated flag value
+type stringList []string
+
+func (t *stringList) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *stringList) Set(value string) error {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	*t = result
+	return nil
+}
+
+// weightMap is a comma-separated key=weight flag value
+type weightMap map[string]float64
+
+func (t *weightMap) String() string {
+	keys := make([]string, 0, len(*t))
+	for key := range *t {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for index, key := range keys {
+		pairs[index] = key + "=" + strconv.FormatFloat((*t)[key], 'g', -1, 64)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (t *weightMap) Set(value string) error {
+	result := make(map[string]float64)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		separatorIndex := strings.LastIndexByte(item, '=')
+		if separatorIndex <= 0 {
+			return fmt.Errorf("%q is not a key=weight pair", item)
+		}
+
+		weight, err := strconv.ParseFloat(strings.TrimSpace(item[separatorIndex+1:]), 64)
+		if err != nil {
+			return fmt.Errorf("%q: weight is not a number", item)
+		}
+		result[strings.TrimSpace(item[:separatorIndex])] = weight
+	}
+	*t = result
+	return nil
+}
+
+func addRateLimitFlags(flags *flag.FlagSet, name string, limit *RateLimit) {
+	flags.Float64Var(&limit.Rate, name+"-rate", limit.Rate, name+" requests per second per client IP")
+	flags.IntVar(&limit.Burst, name+"-burst", limit.Burst, name+" request burst per client IP")
+}
+
+// Load loads and validates configuration. Args are command line arguments without program name.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	result := defaultConfig()
+	configFile := ""
+	flags := newFlagSet(&result, &configFile)
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	explicitFlags := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		explicitFlags[f.Name] = f.Value.String()
+	})
+
+	// flags must override file and env, so, start from defaults again and apply explicit flags after
+	result = defaultConfig()
+
+	if configFile == "" {
+		configFile, _ = lookupEnv("BUILDER_CONFIG")
+	}
+	if configFile != "" {
+		err = readFile(configFile, &result)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, binding := range envBindings {
+		value, _ := lookupEnv(binding.env)
+		value = strings.TrimSpace(value)
+		// k8s sets envs like BUILDER_SERVICE_PORT=tcp://10.43.216.215:443, so, to avoid issues, do not use such values
+		if value == "" || (binding.flag == "port" && strings.HasPrefix(value, "tcp://")) {
+			continue
+		}
+
+		err = flags.Set(binding.flag, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of env %s: %v", binding.env, err)
+		}
+	}
+
+	for name, value := range explicitFlags {
+		err = flags.Set(name, value)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	err = result.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// readFile reads YAML or TOML (by extension) file, unknown fields are not allowed
+func readFile(file string, config *Config) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var jsonData []byte
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		jsonData, err = yaml.YAMLToJSON(data)
+	case ".toml":
+		var raw map[string]interface{}
+		_, err = toml.Decode(string(data), &raw)
+		if err == nil {
+			jsonData, err = json.Marshal(raw)
+		}
+	default:
+		return fmt.Errorf("unsupported config file format: %s (expected .yaml, .yml or .toml)", file)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot parse config file %s: %v", file, err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(config)
+	if err != nil {
+		return fmt.Errorf("cannot parse config file %s: %v", file, err)
+	}
+	return nil
+}
+
+// Validate returns error that lists all invalid fields.
+func (t *Config) Validate() error {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	port, err := strconv.Atoi(t.Port)
+	if err != nil || port <= 0 || port > 65535 {
+		addProblem("port %q is not a valid port", t.Port)
+	}
+
+	if t.PreferredIpVersion != "" && t.PreferredIpVersion != "4" && t.PreferredIpVersion != "6" {
+		addProblem("preferredIpVersion must be 4 or 6")
+	}
+
+	if len(t.AddressResolvers) == 0 {
+		addProblem("addressResolvers must be not empty")
+	}
+	for _, name := range t.AddressResolvers {
+		if !contains(AddressResolvers, name) {
+			addProblem("addressResolvers: unknown resolver %q (expected one of %s)", name, strings.Join(AddressResolvers, ", "))
+		}
+	}
+
+	endpoint, err := url.Parse(t.EtcdEndpoint)
+	if err != nil || endpoint.Host == "" {
+		addProblem("etcdEndpoint %q is not a valid URL", t.EtcdEndpoint)
+	}
+
+	if t.TlsClientCa != "" && !t.UseSsl {
+		addProblem("tlsClientCa cannot be used if useSsl is false")
+	}
+	if t.RouterIdentity != "" && t.TlsClientCa == "" {
+		addProblem("routerIdentity cannot be used if tlsClientCa is not set (router is authenticated by client certificate)")
+	}
+
+	for _, value := range t.TrustedProxies {
+		if ParseNetwork(value) == nil {
+			addProblem("trustedProxies: %q is not a valid CIDR or IP", value)
+		}
+	}
+
+	if t.LogEncoding != "console" && t.LogEncoding != "json" {
+		addProblem("logEncoding must be console or json")
+	}
+
+	if t.WorkerCount <= 0 {
+		addProblem("workerCount must be positive")
+	}
+
+	if t.SchedulingPolicy != "priority" && t.SchedulingPolicy != "fair" {
+		addProblem("schedulingPolicy must be priority or fair")
+	}
+
+	if t.RoutingTokenTtl <= 0 {
+		addProblem("routingTokenTtl must be positive")
+	}
+
+	if t.JobMaxTime <= 0 {
+		addProblem("jobMaxTime must be positive")
+	}
+
+	if t.MaxRequestBody <= 0 {
+		addProblem("maxRequestBody must be positive")
+	}
+
+	validateRateLimit := func(name string, limit RateLimit) {
+		if limit.Rate <= 0 {
+			addProblem("rateLimits.%s.rate must be positive", name)
+		}
+		if limit.Burst < 1 {
+			addProblem("rateLimits.%s.burst must be at least 1", name)
+		}
+	}
+	validateRateLimit("build", t.RateLimits.Build)
+	validateRateLimit("download", t.RateLimits.Download)
+	validateRateLimit("job", t.RateLimits.Job)
+	validateRateLimit("router", t.RateLimits.Router)
+
+	for tenant, weight := range t.TenantWeights {
+		if weight <= 0 {
+			addProblem("tenantWeights.%s must be positive", tenant)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+}
+
+// Redacted returns copy of configuration without secrets (to expose it, e.g. in /admin/config).
+func (t *Config) Redacted() *Config {
+	result := *t
+	if result.AdminToken != "" {
+		result.AdminToken = "<redacted>"
+	}
+	return &result
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNetwork parses CIDR or single IP (as network of this IP only), returns nil if value is not valid
+func ParseNetwork(value string) *net.IPNet {
+	_, network, err := net.ParseCIDR(value)
+	if err == nil {
+		return network
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil
+	}
+	if ip.To4() != nil {
+		return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
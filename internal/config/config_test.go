@@ -0,0 +1,209 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func envLookup(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func writeConfigFile(t *testing.T, name string, data string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, name)
+	err = ioutil.WriteFile(file, []byte(data), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeConfigFile(t, "builder.yaml", `
+port: "8443"
+logEncoding: json
+jobMaxTime: 10m
+rateLimits:
+  build:
+    rate: 2
+    burst: 20
+`)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	config, err := Load([]string{"-config", file, "-build-burst", "30"}, envLookup(map[string]string{
+		"BUILDER_PORT":  "9443",
+		"ETCD_ENDPOINT": "http://localhost:2379",
+		"USE_SSL":       "false",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// env overrides file
+	if config.Port != "9443" {
+		t.Errorf("port: %s", config.Port)
+	}
+	// file overrides defaults
+	if config.LogEncoding != "json" || time.Duration(config.JobMaxTime) != 10*time.Minute || config.RateLimits.Build.Rate != 2 {
+		t.Errorf("file values are not applied: %+v", config)
+	}
+	// flag overrides file
+	if config.RateLimits.Build.Burst != 30 {
+		t.Errorf("build burst: %d", config.RateLimits.Build.Burst)
+	}
+	if config.UseSsl || config.EtcdEndpoint != "http://localhost:2379" {
+		t.Errorf("env values are not applied: %+v", config)
+	}
+	// defaults
+	if config.RateLimits.Download.Burst != 100 {
+		t.Errorf("download burst: %d", config.RateLimits.Download.Burst)
+	}
+}
+
+func TestLoadToml(t *testing.T) {
+	file := writeConfigFile(t, "builder.toml", `
+maxRequestBody = 1024
+
+[rateLimits.router]
+rate = 5.0
+burst = 50
+`)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	config, err := Load(nil, envLookup(map[string]string{"BUILDER_CONFIG": file}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.MaxRequestBody != 1024 || config.RateLimits.Router.Rate != 5 || config.RateLimits.Router.Burst != 50 {
+		t.Errorf("file values are not applied: %+v", config)
+	}
+}
+
+func TestLoadIgnoresServicePortEnv(t *testing.T) {
+	config, err := Load(nil, envLookup(map[string]string{"BUILDER_PORT": "tcp://10.43.216.215:443"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Port != "443" {
+		t.Errorf("port: %s", config.Port)
+	}
+}
+
+func TestSecretIsRedacted(t *testing.T) {
+	config, err := Load(nil, envLookup(map[string]string{"BUILDER_ADMIN_TOKEN": "secret", "BUILDER_SCHEDULING_POLICY": "fair"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.AdminToken != "secret" || config.SchedulingPolicy != "fair" {
+		t.Errorf("env values are not applied: %+v", config)
+	}
+
+	redacted := config.Redacted()
+	if redacted.AdminToken == "secret" || config.AdminToken != "secret" {
+		t.Errorf("admin token must be redacted in copy only: %q %q", redacted.AdminToken, config.AdminToken)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	file := writeConfigFile(t, "builder.yaml", `
+port: "0"
+logEncoding: xml
+useSsl: false
+tlsClientCa: /ca.pem
+schedulingPolicy: random
+trustedProxies: [10.0.0.0/8, proxy]
+tenantWeights:
+  ci.example.com: 0
+rateLimits:
+  job:
+    rate: 0
+    burst: 0
+`)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	_, err := Load([]string{"-config", file}, envLookup(nil))
+	if err == nil {
+		t.Fatal("invalid configuration must be rejected")
+	}
+
+	for _, expected := range []string{"port", "logEncoding", "tlsClientCa", "schedulingPolicy", "trustedProxies", "tenantWeights.ci.example.com", "rateLimits.job.rate", "rateLimits.job.burst"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s is not reported: %v", expected, err)
+		}
+	}
+}
+
+func TestRouterIdentityRequiresClientCa(t *testing.T) {
+	file := writeConfigFile(t, "builder.yaml", "routerIdentity: router.example.com\n")
+	defer os.RemoveAll(filepath.Dir(file))
+	_, err := Load([]string{"-config", file}, envLookup(nil))
+	if err == nil || !strings.Contains(err.Error(), "routerIdentity") {
+		t.Fatalf("forwarded identity must be not trusted without client certificate authentication: %v", err)
+	}
+}
+
+func TestUnknownField(t *testing.T) {
+	file := writeConfigFile(t, "builder.yaml", "unknown: 1\n")
+	defer os.RemoveAll(filepath.Dir(file))
+	_, err := Load([]string{"-config", file}, envLookup(nil))
+	if err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Fatalf("unknown field must be rejected: %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	env := map[string]string{"BUILDER_JOB_MAX_TIME": "10m", "BUILDER_PORT": "8443"}
+	manager, err := NewManager(nil, envLookup(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var notified *Config
+	manager.OnReload(func(config *Config) {
+		notified = config
+	})
+
+	env["BUILDER_JOB_MAX_TIME"] = "20m"
+	env["BUILDER_TENANT_WEIGHTS"] = "ci.example.com=4, 10.0.0.1=0.5"
+	env["BUILDER_PORT"] = "9443"
+	ignoredFields, err := manager.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ignoredFields) != 1 || ignoredFields[0] != "port" {
+		t.Errorf("ignored fields: %v", ignoredFields)
+	}
+	if manager.Get() != notified {
+		t.Error("listener is not notified")
+	}
+	if time.Duration(manager.Get().JobMaxTime) != 20*time.Minute || manager.Get().Port != "8443" {
+		t.Errorf("unexpected config: %+v", manager.Get())
+	}
+	if !reflect.DeepEqual(manager.Get().TenantWeights, map[string]float64{"ci.example.com": 4, "10.0.0.1": 0.5}) {
+		t.Errorf("tenant weights are not reloaded: %v", manager.Get().TenantWeights)
+	}
+
+	env["BUILDER_JOB_MAX_TIME"] = "-1m"
+	_, err = manager.Reload()
+	if err == nil {
+		t.Fatal("invalid configuration must be rejected")
+	}
+	if time.Duration(manager.Get().JobMaxTime) != 20*time.Minute {
+		t.Error("current configuration must be kept")
+	}
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// Manager holds the current configuration and reloads it on SIGHUP.
+// Only live-reloadable fields (JobMaxTime, MaxRequestBody, RateLimits and TenantWeights) are applied on reload, other fields require restart.
+type Manager struct {
+	args      []string
+	lookupEnv func(string) (string, bool)
+
+	current atomic.Value
+
+	listeners []func(config *Config)
+	lock      sync.Mutex
+}
+
+func NewManager(args []string, lookupEnv func(string) (string, bool)) (*Manager, error) {
+	config, err := Load(args, lookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	manager := &Manager{
+		args:      args,
+		lookupEnv: lookupEnv,
+	}
+	manager.current.Store(config)
+	return manager, nil
+}
+
+// Get returns the current configuration (must be not modified).
+func (t *Manager) Get() *Config {
+	return t.current.Load().(*Config)
+}
+
+// OnReload adds listener that is called with the new configuration after reload.
+func (t *Manager) OnReload(listener func(config *Config)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.listeners = append(t.listeners, listener)
+}
+
+// Reload loads configuration again and applies live-reloadable fields. Returns names of changed fields that are not applied (restart is required).
+// On error the current configuration is kept.
+func (t *Manager) Reload() ([]string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	loaded, err := Load(t.args, t.lookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	current := t.Get()
+	result := *current
+	result.JobMaxTime = loaded.JobMaxTime
+	result.MaxRequestBody = loaded.MaxRequestBody
+	result.RateLimits = loaded.RateLimits
+	result.TenantWeights = loaded.TenantWeights
+
+	t.current.Store(&result)
+	for _, listener := range t.listeners {
+		listener(&result)
+	}
+	return getChangedFields(&result, loaded), nil
+}
+
+// getChangedFields returns JSON names of top-level fields that are different
+func getChangedFields(old *Config, new *Config) []string {
+	var result []string
+	oldValue := reflect.ValueOf(old).Elem()
+	newValue := reflect.ValueOf(new).Elem()
+	configType := oldValue.Type()
+	for i := 0; i < configType.NumField(); i++ {
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			result = append(result, configType.Field(i).Tag.Get("json"))
+		}
+	}
+	return result
+}
+
+// WatchSignal reloads configuration on SIGHUP until returned function is called.
+func (t *Manager) WatchSignal(logger *zap.Logger) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-signals:
+				ignoredFields, err := t.Reload()
+				if err != nil {
+					logger.Error("cannot reload configuration, current configuration is kept", zap.Error(err))
+					continue
+				}
+
+				logger.Info("configuration reloaded")
+				if len(ignoredFields) != 0 {
+					logger.Warn("changed fields cannot be reloaded, restart is required", zap.Strings("fields", ignoredFields))
+				}
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
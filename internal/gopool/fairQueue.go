@@ -0,0 +1,241 @@
+package gopool
+
+import (
+	"time"
+)
+
+// Queue is a source of jobs for the pool. It is not thread-safe - only ManagedSource uses it.
+type Queue interface {
+	Add(job JobEntry)
+	// Next returns nil if queue is empty.
+	Next() JobEntry
+	// Remove returns nil if there is no job with the given id.
+	Remove(id string) JobEntry
+	Length() int
+	// Ids returns ids of jobs in the order in which they will be done.
+	Ids() []string
+}
+
+// Keyed can be implemented by Runnable to be scheduled fairly across keys (e.g. tenant or client IP) by FairQueue.
+type Keyed interface {
+	Key() string
+}
+
+// FairQueue is an implementation of a weighted fair queuing across job keys (start-time fair queuing).
+// Jobs with the same key are done in FIFO order, so, one key that submits a lot of jobs doesn't starve other keys.
+// Job priority is not used.
+type FairQueue struct {
+	keys map[string]*fairKey
+	// job id to key
+	jobKeys map[string]string
+	length  int
+
+	lastSequence uint64
+
+	// start tag of the last started job
+	virtualTime float64
+
+	// returns weight of the key (the more weight, the more jobs are done for the key compared to others), 1 if nil
+	weight func(key string) float64
+	// wait time that is equivalent to one job in the virtual time (aging to avoid starvation), aging is disabled if 0
+	aging time.Duration
+
+	clock func() time.Time
+}
+
+// key is kept after all its jobs are done until virtual time reaches its last finish tag,
+// otherwise key will get new start tag as if it was not served recently
+type fairKey struct {
+	entries []*fairEntry
+	// finish tag of the last added job
+	lastFinish float64
+}
+
+type fairEntry struct {
+	job     JobEntry
+	start   float64
+	finish  float64
+	addTime time.Time
+	// add order (to keep FIFO order for jobs with equal score)
+	sequence uint64
+}
+
+// NewFairQueue creates a new FairQueue. Weight func can be nil (all keys have equal weight).
+func NewFairQueue(weight func(key string) float64, aging time.Duration) *FairQueue {
+	return &FairQueue{
+		keys:    make(map[string]*fairKey),
+		jobKeys: make(map[string]string),
+		weight:  weight,
+		aging:   aging,
+		clock:   time.Now,
+	}
+}
+
+func (q *FairQueue) Length() int {
+	return q.length
+}
+
+func (q *FairQueue) getWeight(key string) float64 {
+	if q.weight == nil {
+		return 1
+	}
+
+	weight := q.weight(key)
+	if weight <= 0 {
+		return 1
+	}
+	return weight
+}
+
+func (q *FairQueue) Add(job JobEntry) {
+	key := job.Key()
+	k := q.keys[key]
+	if k == nil {
+		k = &fairKey{}
+		q.keys[key] = k
+	}
+
+	start := q.virtualTime
+	if k.lastFinish > start {
+		start = k.lastFinish
+	}
+
+	q.lastSequence++
+	entry := &fairEntry{
+		job:      job,
+		start:    start,
+		finish:   start + 1/q.getWeight(key),
+		addTime:  q.clock(),
+		sequence: q.lastSequence,
+	}
+	k.lastFinish = entry.finish
+	k.entries = append(k.entries, entry)
+
+	q.jobKeys[job.String()] = key
+	q.length++
+}
+
+// score - the less, the earlier job will be done
+func (q *FairQueue) score(entry *fairEntry, now time.Time) float64 {
+	if q.aging <= 0 {
+		return entry.finish
+	}
+	return entry.finish - float64(now.Sub(entry.addTime))/float64(q.aging)
+}
+
+func (q *FairQueue) isBefore(a *fairEntry, b *fairEntry, now time.Time) bool {
+	aScore := q.score(a, now)
+	bScore := q.score(b, now)
+	if aScore != bScore {
+		return aScore < bScore
+	}
+	return a.sequence < b.sequence
+}
+
+func (q *FairQueue) Next() JobEntry {
+	if q.length == 0 {
+		return nil
+	}
+
+	now := q.clock()
+	var selectedKey string
+	var selected *fairEntry
+	for key, k := range q.keys {
+		if len(k.entries) == 0 {
+			continue
+		}
+
+		head := k.entries[0]
+		if selected == nil || q.isBefore(head, selected, now) {
+			selected = head
+			selectedKey = key
+		}
+	}
+
+	q.removeEntry(selectedKey, 0)
+	if selected.start > q.virtualTime {
+		q.virtualTime = selected.start
+
+		for key, k := range q.keys {
+			if len(k.entries) == 0 && k.lastFinish <= q.virtualTime {
+				delete(q.keys, key)
+			}
+		}
+	}
+	return selected.job
+}
+
+func (q *FairQueue) Remove(id string) JobEntry {
+	key, ok := q.jobKeys[id]
+	if !ok {
+		return nil
+	}
+
+	k := q.keys[key]
+	for index, entry := range k.entries {
+		if entry.job.String() == id {
+			k.refund(index)
+			q.removeEntry(key, index)
+			return entry.job
+		}
+	}
+	return nil
+}
+
+// refund rewinds tags of the entries added after the removed (not done) one and the last finish tag of the key,
+// so, key is not charged for the job that was never run
+func (k *fairKey) refund(index int) {
+	removed := k.entries[index]
+	cost := removed.finish - removed.start
+	// finish tag of the previous job of the key (or virtual time when removed job was added)
+	previousFinish := removed.start
+	for _, entry := range k.entries[index+1:] {
+		entryCost := entry.finish - entry.start
+		start := entry.start - cost
+		if start < previousFinish {
+			start = previousFinish
+		}
+		entry.start = start
+		entry.finish = start + entryCost
+		previousFinish = entry.finish
+	}
+	k.lastFinish = previousFinish
+}
+
+func (q *FairQueue) removeEntry(key string, index int) {
+	k := q.keys[key]
+	delete(q.jobKeys, k.entries[index].job.String())
+	k.entries = append(k.entries[:index], k.entries[index+1:]...)
+	if len(k.entries) == 0 && k.lastFinish <= q.virtualTime {
+		delete(q.keys, key)
+	}
+	q.length--
+}
+
+func (q *FairQueue) Ids() []string {
+	now := q.clock()
+
+	// simulate Next calls (virtual time is not used to select job, so, only heads are tracked)
+	heads := make(map[string]int, len(q.keys))
+	result := make([]string, 0, q.length)
+	for len(result) < q.length {
+		var selectedKey string
+		var selected *fairEntry
+		for key, k := range q.keys {
+			index := heads[key]
+			if index >= len(k.entries) {
+				continue
+			}
+
+			head := k.entries[index]
+			if selected == nil || q.isBefore(head, selected, now) {
+				selected = head
+				selectedKey = key
+			}
+		}
+
+		heads[selectedKey]++
+		result = append(result, selected.job.String())
+	}
+	return result
+}
@@ -0,0 +1,231 @@
+package gopool
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/electronuserland/electron-build-service/internal"
+	"golang.org/x/net/context"
+)
+
+func TestFairQueue(t *testing.T) {
+	q := NewFairQueue(nil, 0)
+
+	// Verify empty is nil.
+	if q.Next() != nil {
+		t.Fatalf("q.Next() != nil after NewFairQueue()")
+	}
+
+	addKeyed(q, "a", "a1", "a2", "a3", "a4", "a5", "a6")
+	addKeyed(q, "b", "b1", "b2")
+	addKeyed(q, "c", "c1")
+
+	expected := []string{"a1", "b1", "c1", "a2", "b2", "a3", "a4", "a5", "a6"}
+	if !reflect.DeepEqual(q.Ids(), expected) {
+		t.Errorf("q.Ids() is not fair: %v", q.Ids())
+	}
+
+	if !reflect.DeepEqual(drain(q), expected) {
+		t.Errorf("jobs are not scheduled fairly across keys")
+	}
+	if q.Length() != 0 {
+		t.Errorf("q.Length() != 0 after last Next(): %v", q.Length())
+	}
+}
+
+func TestFairQueueWeight(t *testing.T) {
+	q := NewFairQueue(func(key string) float64 {
+		if key == "a" {
+			return 2
+		}
+		return 1
+	}, 0)
+
+	addKeyed(q, "a", "a1", "a2", "a3", "a4")
+	addKeyed(q, "b", "b1", "b2")
+
+	order := drain(q)
+	if !reflect.DeepEqual(order, []string{"a1", "a2", "b1", "a3", "a4", "b2"}) {
+		t.Errorf("weight wasn't properly applied: %v", order)
+	}
+}
+
+func TestFairQueueNewKeyIsNotPenalized(t *testing.T) {
+	q := NewFairQueue(nil, 0)
+
+	addKeyed(q, "a", "a1", "a2", "a3", "a4")
+	if next := q.Next().String(); next != "a1" {
+		t.Fatalf("a1 expected, but got %v", next)
+	}
+	if next := q.Next().String(); next != "a2" {
+		t.Fatalf("a2 expected, but got %v", next)
+	}
+
+	// key that comes later must not wait until all queued jobs of other keys are done
+	addKeyed(q, "b", "b1")
+	order := drain(q)
+	if !reflect.DeepEqual(order, []string{"b1", "a3", "a4"}) {
+		t.Errorf("new key must be scheduled fairly: %v", order)
+	}
+}
+
+func TestFairQueueAging(t *testing.T) {
+	now := time.Now()
+	q := NewFairQueue(func(key string) float64 {
+		if key == "low" {
+			return 0.01
+		}
+		return 1
+	}, time.Second)
+	q.clock = func() time.Time {
+		return now
+	}
+
+	addKeyed(q, "low", "low1")
+	now = now.Add(200 * time.Second)
+	addKeyed(q, "a", "a1", "a2", "a3")
+
+	order := drain(q)
+	if !reflect.DeepEqual(order, []string{"low1", "a1", "a2", "a3"}) {
+		t.Errorf("aging wasn't properly applied: %v", order)
+	}
+
+	// without aging, job with low weight is done last
+	q.aging = 0
+	addKeyed(q, "low", "low1")
+	addKeyed(q, "a", "a1", "a2", "a3")
+	order = drain(q)
+	if !reflect.DeepEqual(order, []string{"a1", "a2", "a3", "low1"}) {
+		t.Errorf("weight wasn't properly applied: %v", order)
+	}
+}
+
+func TestFairQueueRemove(t *testing.T) {
+	q := NewFairQueue(nil, 0)
+
+	addKeyed(q, "a", "a1", "a2", "a3")
+	addKeyed(q, "b", "b1", "b2")
+
+	if q.Remove("c1") != nil {
+		t.Fatalf("removed job that was not added")
+	}
+
+	for _, id := range []string{"a2", "b1"} {
+		removed := q.Remove(id)
+		if removed == nil || removed.String() != id {
+			t.Fatalf("job %s was not removed: %v", id, removed)
+		}
+	}
+
+	if q.Length() != 3 {
+		t.Fatalf("q.Length() != 3 after remove: %v", q.Length())
+	}
+
+	order := drain(q)
+	if !reflect.DeepEqual(order, []string{"a1", "b2", "a3"}) {
+		t.Errorf("unexpected order after remove: %v", order)
+	}
+}
+
+func TestFairQueueRemoveRefundsKey(t *testing.T) {
+	q := NewFairQueue(nil, 0)
+
+	addKeyed(q, "a", "a1", "a2", "a3")
+	addKeyed(q, "b", "b1", "b2", "b3")
+
+	// cancelled jobs of a were never run, a must be not charged for them
+	q.Remove("a2")
+	q.Remove("a3")
+	addKeyed(q, "a", "a4")
+
+	expected := []string{"a1", "b1", "b2", "a4", "b3"}
+	if !reflect.DeepEqual(q.Ids(), expected) {
+		t.Errorf("key is charged for removed jobs: %v", q.Ids())
+	}
+
+	// removal in the middle rewinds tags of later jobs of the key
+	q = NewFairQueue(nil, 0)
+	addKeyed(q, "a", "a1", "a2", "a3", "a4")
+	addKeyed(q, "b", "b1", "b2", "b3")
+	q.Remove("a2")
+	q.Remove("a3")
+
+	expected = []string{"a1", "b1", "a4", "b2", "b3"}
+	if actual := drain(q); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("later jobs of the key are charged for removed jobs: %v", actual)
+	}
+
+	// started job is not refunded
+	q = NewFairQueue(nil, 0)
+	addKeyed(q, "a", "a1", "a2")
+	addKeyed(q, "b", "b1", "b2", "b3")
+	if next := q.Next().String(); next != "a1" {
+		t.Fatalf("a1 expected, but got %v", next)
+	}
+	q.Remove("a2")
+	addKeyed(q, "a", "a3")
+
+	expected = []string{"b1", "b2", "a3", "b3"}
+	if actual := drain(q); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("only started job must be charged: %v", actual)
+	}
+}
+
+func TestGoPoolWithFairQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := NewWithQueue(1, NewFairQueue(nil, 0), ctx, internal.CreateLogger("console"))
+
+	// occupy the only worker
+	release := make(chan struct{})
+	started := make(chan struct{})
+	pool.AddJob(&bt{name: "blocking", started: started, release: release}, 0)
+	<-started
+
+	for _, name := range []string{"a1", "a2", "a3"} {
+		pool.AddJob(&kt{name: name, key: "a"}, 0)
+	}
+	pool.AddJob(&kt{name: "b1", key: "b"}, 0)
+
+	// first added job is already taken from the queue and waits for a free worker
+	expected := []string{"a1", "b1", "a2", "a3"}
+	if !reflect.DeepEqual(pool.GetPendingJobIds(), expected) {
+		t.Errorf("unexpected pending jobs: %v", pool.GetPendingJobIds())
+	}
+
+	close(release)
+
+	for pool.GetPendingJobCount() > 0 || pool.GetRunningJobCount() > 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	pool.Close()
+	pool.Wait()
+}
+
+func addKeyed(q Queue, key string, names ...string) {
+	for _, name := range names {
+		q.Add(newJob(&kt{name: name, key: key}, 0))
+	}
+}
+
+func drain(q Queue) []string {
+	var result []string
+	for job := q.Next(); job != nil; job = q.Next() {
+		result = append(result, job.String())
+	}
+	return result
+}
+
+// kt is a helper job with a key for fair scheduling
+type kt struct {
+	name string
+	key  string
+}
+
+func (t *kt) String() string { return t.name }
+func (t *kt) Key() string    { return t.key }
+func (t *kt) Run(ctx context.Context) {
+}
@@ -22,6 +22,9 @@ type JobEntry interface {
 
 	Priority() int
 
+	// key to schedule jobs fairly (see FairQueue), empty if job doesn't implement Keyed
+	Key() string
+
 	// can be called several times
 	Cancel()
 
@@ -50,7 +53,18 @@ type GoPool struct {
 	pendingJobCount atomic.Int32
 	runningJobCount atomic.Int32
 
-	JobMaxTime time.Duration
+	jobMaxTime atomic.Duration
+
+	// effective worker count (workers that are asked to exit are not counted)
+	workerCount     atomic.Int32
+	workerLock      sync.Mutex
+	lastWorkerIndex int
+	// channel to ask one idle worker to exit (to decrease worker count)
+	shrinkChannel chan struct{}
+	// called when worker exits (tests only, must be set before worker count is changed)
+	onWorkerStop func(reason string)
+
+	logger *zap.Logger
 
 	closeOnce sync.Once
 
@@ -60,12 +74,102 @@ type GoPool struct {
 
 func (t *GoPool) AddJob(job Runnable, priority int) JobEntry {
 	jobEntry := newJob(job, priority)
+	jobEntry.remove = func() {
+		t.RemoveJob(job.String())
+	}
+	// count before send to be accurate right after AddJob call
+	t.pendingJobCount.Inc()
 	t.queue.add <- jobEntry
 	return jobEntry
 }
 
+// RemoveJob removes not yet started job from the queue. Returns false if there is no such pending job.
+func (t *GoPool) RemoveJob(id string) bool {
+	if !t.queue.removeJob(id) {
+		return false
+	}
+
+	t.pendingJobCount.Dec()
+	return true
+}
+
+// GetPendingJobIds returns ids of not yet started jobs in the order in which they will be started.
+func (t *GoPool) GetPendingJobIds() []string {
+	return t.queue.getPendingIds()
+}
+
+// GetJobPosition returns zero-based position of the job in the queue or -1 if job is not pending (started, removed or unknown).
+func (t *GoPool) GetJobPosition(id string) int {
+	for index, pendingId := range t.GetPendingJobIds() {
+		if pendingId == id {
+			return index
+		}
+	}
+	return -1
+}
+
+func (t *GoPool) GetWorkerCount() int {
+	return int(t.workerCount.Load())
+}
+
+// SetWorkerCount changes worker count at runtime. Running jobs are not aborted - if count is decreased, workers exit when become idle.
+func (t *GoPool) SetWorkerCount(count int) {
+	if count < 1 {
+		count = 1
+	}
+
+	t.workerLock.Lock()
+	defer t.workerLock.Unlock()
+
+	current := int(t.workerCount.Load())
+	if current == count {
+		return
+	}
+
+	t.logger.Info("change worker count", zap.Int("old", current), zap.Int("new", count))
+
+	for ; current < count; current++ {
+		t.startWorker()
+	}
+
+	for ; current > count; current-- {
+		t.workerCount.Dec()
+		go func() {
+			select {
+			case t.shrinkChannel <- struct{}{}:
+			case <-t.closeChannel:
+			case <-t.context.Done():
+			}
+		}()
+	}
+}
+
+// must be called under workerLock (or in constructor)
+func (t *GoPool) startWorker() {
+	index := t.lastWorkerIndex
+	t.lastWorkerIndex++
+	t.workerCount.Inc()
+	t.waitGroup.Add(1)
+	go t.worker(t.logger.With(zap.Int("worker", index)))
+}
+
+// IsClosed returns true if pool doesn't accept new jobs anymore
+func (t *GoPool) IsClosed() bool {
+	select {
+	case <-t.closeChannel:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetJobMaxTime sets timeout of jobs (applied to jobs that are started after call)
+func (t *GoPool) SetJobMaxTime(value time.Duration) {
+	t.jobMaxTime.Store(value)
+}
+
 func (t *GoPool) GetPendingJobCount() int {
-	return int(t.queue.pendingJobCount.Load())
+	return int(t.pendingJobCount.Load())
 }
 
 func (t *GoPool) GetRunningJobCount() int {
@@ -79,16 +183,26 @@ func (t *GoPool) GetRunningJobCount() int {
 // want to make sure all of the tasks have got the signal and stopped
 // cleanly, you should use Wait().
 func New(workerCount int, ctx context.Context, logger *zap.Logger) *GoPool {
-	managedSource := newManagedSource(NewPriorityQueue(), ctx, logger)
+	return NewWithQueue(workerCount, NewPriorityQueue(), ctx, logger)
+}
+
+// NewWithQueue creates a new GoPool that uses the given queue to select next job (e.g. FairQueue).
+func NewWithQueue(workerCount int, queue Queue, ctx context.Context, logger *zap.Logger) *GoPool {
 	pool := &GoPool{
 		context: ctx,
-		queue:   managedSource,
 
-		closeChannel: make(chan struct{}),
+		closeChannel:  make(chan struct{}),
+		shrinkChannel: make(chan struct{}),
+
+		logger: logger,
 	}
+	pool.queue = newManagedSource(queue, ctx, func(job JobEntry) {
+		// job is cancelled on shutdown and will be never taken by worker
+		pool.pendingJobCount.Dec()
+	}, logger)
 
 	for index := 0; index < workerCount; index++ {
-		go pool.worker(logger.With(zap.Int("worker", index)))
+		pool.startWorker()
 	}
 	return pool
 }
@@ -123,11 +237,13 @@ func (t *GoPool) Close() {
 // Worker is the function each goroutine uses to get and perform tasks.
 // It stops when the stop channel is closed. It also stops if the source channel is closed but logs a message in addition.
 func (t *GoPool) worker(logger *zap.Logger) {
-	t.waitGroup.Add(1)
 	stopReason := "unknown"
 	defer func() {
-		t.waitGroup.Done()
 		logger.Debug("stopping", zap.String("reason", stopReason))
+		if t.onWorkerStop != nil {
+			t.onWorkerStop(stopReason)
+		}
+		t.waitGroup.Done()
 	}()
 
 	for {
@@ -138,14 +254,22 @@ func (t *GoPool) worker(logger *zap.Logger) {
 		case <-t.context.Done():
 			stopReason = "stop channel closed"
 			return
+		case <-t.shrinkChannel:
+			stopReason = "worker count decreased"
+			return
 		case job, ok := <-t.queue.source:
 			if !ok {
 				stopReason = "input source closed"
 				return
 			}
 
+			// increment running count before decrement pending count to not report empty pool while job is being started
+			t.runningJobCount.Inc()
+			t.pendingJobCount.Dec()
+
 			contextError := t.context.Err()
 			if contextError != nil {
+				t.runningJobCount.Dec()
 				logger.Debug("stopping", zap.NamedError("reason", contextError))
 				return
 			}
@@ -157,7 +281,7 @@ func (t *GoPool) worker(logger *zap.Logger) {
 
 func (t *GoPool) executeJob(logger *zap.Logger, job JobEntry) {
 	logger.Debug("starting job")
-	jobContext, jobCancel := context.WithTimeout(t.context, t.JobMaxTime)
+	jobContext, jobCancel := context.WithTimeout(t.context, t.jobMaxTime.Load())
 
 	start := time.Now()
 
@@ -184,7 +308,6 @@ func (t *GoPool) executeJob(logger *zap.Logger, job JobEntry) {
 		})
 	}
 
-	t.runningJobCount.Inc()
 	defer cancelFuncWrapper()
 	job.Run(jobContext, cancelFuncWrapper)
 }
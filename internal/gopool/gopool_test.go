@@ -29,7 +29,7 @@ func TestGoPool(t *testing.T) {
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	logger := internal.CreateLogger()
+	logger := internal.CreateLogger("console")
 
 	pool := New(5, ctx, logger)
 
@@ -49,7 +49,7 @@ func TestGoPool(t *testing.T) {
 		exp = append(exp, x)
 	}
 
-	for pool.GetRunningJobCount() > 1 {
+	for pool.GetPendingJobCount() > 0 || pool.GetRunningJobCount() > 0 {
 		time.Sleep(20 * time.Millisecond)
 	}
 
@@ -66,6 +66,193 @@ func TestGoPool(t *testing.T) {
 	}
 }
 
+func TestRemovePendingJob(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := New(1, ctx, internal.CreateLogger("console"))
+
+	// occupy the only worker
+	release := make(chan struct{})
+	started := make(chan struct{})
+	pool.AddJob(&bt{name: "blocking", started: started, release: release}, 0)
+	<-started
+
+	var l sync.Mutex
+	var items []int
+	ai := func(i int) {
+		l.Lock()
+		defer l.Unlock()
+		items = append(items, i)
+	}
+
+	entries := make([]JobEntry, 5)
+	for x := 0; x < 5; x++ {
+		entries[x] = pool.AddJob(&tt{f: ai, i: x}, 0)
+	}
+
+	for pool.GetPendingJobCount() != 5 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if pool.RemoveJob("42") {
+		t.Errorf("removed job that was not added")
+	}
+
+	if pool.GetJobPosition("3") != 3 || pool.GetJobPosition("blocking") != -1 {
+		t.Errorf("unexpected job position: %v", pool.GetPendingJobIds())
+	}
+
+	entries[1].Cancel()
+	entries[3].Cancel()
+	// can be called several times
+	entries[3].Cancel()
+
+	if pool.GetPendingJobCount() != 3 {
+		t.Errorf("pending job count is not updated after cancel: %v", pool.GetPendingJobCount())
+	}
+	if !reflect.DeepEqual(pool.GetPendingJobIds(), []string{"0", "2", "4"}) {
+		t.Errorf("unexpected pending jobs after cancel: %v", pool.GetPendingJobIds())
+	}
+
+	close(release)
+
+	for pool.GetPendingJobCount() > 0 || pool.GetRunningJobCount() > 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	pool.Close()
+	pool.Wait()
+
+	sort.Ints(items)
+	if !reflect.DeepEqual(items, []int{0, 2, 4}) {
+		t.Errorf("cancelled pending jobs must be not executed: %v", items)
+	}
+}
+
+func TestPendingJobsAreDroppedOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	pool := New(1, ctx, internal.CreateLogger("console"))
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	pool.AddJob(&bt{name: "blocking", started: started, release: release}, 0)
+	<-started
+
+	for x := 0; x < 3; x++ {
+		pool.AddJob(&tt{f: func(int) {}, i: x}, 0)
+	}
+
+	cancel()
+	waitFor(t, "pending jobs are not dropped on shutdown", func() bool {
+		return pool.GetPendingJobCount() == 0
+	})
+
+	close(release)
+	pool.Wait()
+	if pool.GetPendingJobCount() != 0 {
+		t.Errorf("pending job count must be zero after shutdown: %v", pool.GetPendingJobCount())
+	}
+}
+
+func TestSetWorkerCount(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := New(1, ctx, internal.CreateLogger("console"))
+	stoppedWorkers := make(chan string, 8)
+	pool.onWorkerStop = func(reason string) {
+		stoppedWorkers <- reason
+	}
+
+	pool.SetWorkerCount(3)
+	if pool.GetWorkerCount() != 3 {
+		t.Fatalf("worker count is not increased: %v", pool.GetWorkerCount())
+	}
+
+	release := make(chan struct{})
+	for x := 0; x < 3; x++ {
+		pool.AddJob(&bt{name: strconv.Itoa(x), started: make(chan struct{}), release: release}, 0)
+	}
+
+	waitFor(t, "jobs are not started by all workers", func() bool {
+		return pool.GetRunningJobCount() == 3
+	})
+
+	// running jobs must be not aborted
+	pool.SetWorkerCount(1)
+	if pool.GetWorkerCount() != 1 {
+		t.Fatalf("worker count is not decreased: %v", pool.GetWorkerCount())
+	}
+	if pool.GetRunningJobCount() != 3 {
+		t.Fatalf("running jobs must be not affected by worker count decrease: %v", pool.GetRunningJobCount())
+	}
+
+	close(release)
+	// two workers exit as soon as become idle
+	for x := 0; x < 2; x++ {
+		select {
+		case reason := <-stoppedWorkers:
+			if reason != "worker count decreased" {
+				t.Fatalf("unexpected worker stop reason: %s", reason)
+			}
+		case <-time.After(30 * time.Second):
+			t.Fatal("worker doesn't exit after worker count decrease")
+		}
+	}
+	waitFor(t, "jobs are not finished", func() bool {
+		return pool.GetRunningJobCount() == 0
+	})
+
+	release = make(chan struct{})
+	for x := 3; x < 5; x++ {
+		pool.AddJob(&bt{name: strconv.Itoa(x), started: make(chan struct{}), release: release}, 0)
+	}
+
+	// the only worker cannot run more than one job
+	waitFor(t, "only one job must be running", func() bool {
+		return pool.GetRunningJobCount() == 1 && pool.GetPendingJobCount() == 1
+	})
+
+	pool.SetWorkerCount(2)
+	waitFor(t, "job is not started by added worker", func() bool {
+		return pool.GetRunningJobCount() == 2
+	})
+
+	close(release)
+	waitFor(t, "jobs are not finished", func() bool {
+		return pool.GetPendingJobCount() == 0 && pool.GetRunningJobCount() == 0
+	})
+
+	pool.Close()
+	pool.Wait()
+}
+
+// waitFor polls condition with a deadline long enough for a loaded CI machine
+func waitFor(t *testing.T, message string, condition func() bool) {
+	deadline := time.Now().Add(30 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// bt is a helper job that blocks worker until released
+type bt struct {
+	name    string
+	started chan struct{}
+	release chan struct{}
+}
+
+func (t *bt) String() string { return t.name }
+func (t *bt) Run(ctx context.Context) {
+	close(t.started)
+	<-t.release
+}
+
 type tt struct {
 	f func(int)
 	i int
@@ -3,11 +3,12 @@ package gopool
 import (
 	"container/heap"
 	"context"
+	"sort"
 	"sync"
 )
 
 // newJob returns a PriorityJob with the given task and priority
-func newJob(job Runnable, priority int) JobEntry {
+func newJob(job Runnable, priority int) *pt {
 	return &pt{
 		priority: priority,
 		job:      job,
@@ -21,6 +22,12 @@ type pt struct {
 	job        Runnable
 	lock       *sync.Mutex
 	cancelFunc context.CancelFunc
+
+	isStarted   bool
+	isCancelled bool
+
+	// removes not yet started job from the queue (nil if job is not added to pool)
+	remove func()
 }
 
 func (t *pt) String() string {
@@ -31,8 +38,23 @@ func (t *pt) Priority() int {
 	return t.priority
 }
 
+func (t *pt) Key() string {
+	keyed, ok := t.job.(Keyed)
+	if !ok {
+		return ""
+	}
+	return keyed.Key()
+}
+
 func (t *pt) Run(jobContext context.Context, cancelFunc context.CancelFunc) {
 	t.lock.Lock()
+	if t.isCancelled {
+		// cancelled after worker got the job from the queue but before start
+		t.lock.Unlock()
+		cancelFunc()
+		return
+	}
+	t.isStarted = true
 	t.cancelFunc = cancelFunc
 	t.lock.Unlock()
 
@@ -44,22 +66,29 @@ func (t *pt) Cancel() {
 	t.lock.Lock()
 	cancelFunc = t.cancelFunc
 	t.cancelFunc = nil
+	isStarted := t.isStarted
+	t.isCancelled = true
 	t.lock.Unlock()
 
 	if cancelFunc != nil {
 		cancelFunc()
+	} else if !isStarted && t.remove != nil {
+		// do not occupy worker by already cancelled job
+		t.remove()
 	}
 }
 
 // PriorityQueue is an implementation of a Sourcer using a priority
 // queue. Higher priority tasks will be done first.
+// Queue is indexed by job id (String()) to support removal of the pending jobs.
+// Jobs with equal priority are done in FIFO order.
 type PriorityQueue struct {
 	q *pq
 }
 
 // NewPriorityQueue creates a new PriorityQueue.
 func NewPriorityQueue() *PriorityQueue {
-	q := &PriorityQueue{q: &pq{}}
+	q := &PriorityQueue{q: &pq{indices: make(map[string]int), sequences: make(map[string]uint64)}}
 	heap.Init(q.q)
 	return q
 }
@@ -80,21 +109,71 @@ func (q *PriorityQueue) Add(t JobEntry) {
 	heap.Push(q.q, t)
 }
 
+// Remove returns nil if there is no job with the given id.
+func (q *PriorityQueue) Remove(id string) JobEntry {
+	index, ok := q.q.indices[id]
+	if !ok {
+		return nil
+	}
+	return heap.Remove(q.q, index).(JobEntry)
+}
+
+// Ids returns ids of jobs in the order in which they will be done.
+func (q *PriorityQueue) Ids() []string {
+	entries := make([]JobEntry, len(q.q.entries))
+	copy(entries, q.q.entries)
+	sort.Slice(entries, func(i, j int) bool {
+		return q.q.isBefore(entries[i], entries[j])
+	})
+
+	result := make([]string, len(entries))
+	for index, job := range entries {
+		result[index] = job.String()
+	}
+	return result
+}
+
 // internal representation of priority queue
-type pq []JobEntry
+type pq struct {
+	entries []JobEntry
+	// job id to index in entries
+	indices map[string]int
+
+	// job id to add sequence number (to keep FIFO order for jobs with equal priority)
+	sequences    map[string]uint64
+	lastSequence uint64
+}
 
-func (q pq) Len() int           { return len(q) }
-func (q pq) Less(i, j int) bool { return q[i].Priority() > q[j].Priority() }
-func (q pq) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *pq) isBefore(a JobEntry, b JobEntry) bool {
+	if a.Priority() != b.Priority() {
+		return a.Priority() > b.Priority()
+	}
+	return q.sequences[a.String()] < q.sequences[b.String()]
+}
+
+func (q *pq) Len() int           { return len(q.entries) }
+func (q *pq) Less(i, j int) bool { return q.isBefore(q.entries[i], q.entries[j]) }
+
+func (q *pq) Swap(i, j int) {
+	q.entries[i], q.entries[j] = q.entries[j], q.entries[i]
+	q.indices[q.entries[i].String()] = i
+	q.indices[q.entries[j].String()] = j
+}
 
 func (q *pq) Push(x interface{}) {
-	*q = append(*q, x.(JobEntry))
+	job := x.(JobEntry)
+	q.lastSequence++
+	q.sequences[job.String()] = q.lastSequence
+	q.indices[job.String()] = len(q.entries)
+	q.entries = append(q.entries, job)
 }
 
 func (q *pq) Pop() interface{} {
-	old := *q
-	n := len(old)
-	t := old[n-1]
-	*q = old[0 : n-1]
-	return t
+	n := len(q.entries)
+	job := q.entries[n-1]
+	q.entries[n-1] = nil
+	q.entries = q.entries[0 : n-1]
+	delete(q.indices, job.String())
+	delete(q.sequences, job.String())
+	return job
 }
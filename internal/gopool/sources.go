@@ -1,7 +1,6 @@
 package gopool
 
 import (
-	"go.uber.org/atomic"
 	"go.uber.org/zap"
 	"golang.org/x/net/context"
 )
@@ -11,32 +10,46 @@ type ManagedSource struct {
 	source <-chan JobEntry
 	// channel on which tasks can be added
 	add chan<- JobEntry
+	// channel on which not yet started tasks can be removed
+	remove chan<- removeRequest
+	// channel on which ids of not yet started tasks (in queue order) can be requested
+	pendingIds chan<- chan []string
 
-	pendingJobCount atomic.Uint32
+	done <-chan struct{}
 }
 
-// creates a managed source using the given Sourcer and starts it
-func newManagedSource(queue *PriorityQueue, ctx context.Context, logger *zap.Logger) *ManagedSource {
+type removeRequest struct {
+	id     string
+	result chan bool
+}
+
+// creates a managed source using the given Sourcer and starts it.
+// onDrop is called for each not yet started job that is cancelled because source is stopped.
+func newManagedSource(queue Queue, ctx context.Context, onDrop func(job JobEntry), logger *zap.Logger) *ManagedSource {
 	outputChannel := make(chan JobEntry)
 	addChannel := make(chan JobEntry)
+	removeChannel := make(chan removeRequest)
+	pendingIdsChannel := make(chan chan []string)
+	doneChannel := make(chan struct{})
 
 	source := &ManagedSource{
-		source: outputChannel,
-		add:    addChannel,
+		source:     outputChannel,
+		add:        addChannel,
+		remove:     removeChannel,
+		pendingIds: pendingIdsChannel,
+		done:       doneChannel,
 	}
 
 	go func() {
-		updatePendingJobCount := func() {
-			source.pendingJobCount.Store(uint32(queue.Length()))
-		}
-
 		var topJob JobEntry
 
 		defer func() {
+			close(doneChannel)
 			close(outputChannel)
 
 			if topJob != nil {
 				topJob.Cancel()
+				onDrop(topJob)
 				topJob = nil
 			}
 
@@ -47,15 +60,13 @@ func newManagedSource(queue *PriorityQueue, ctx context.Context, logger *zap.Log
 				}
 
 				job.Cancel()
+				onDrop(job)
 			}
-
-			updatePendingJobCount()
 		}()
 
 		for {
 			if topJob == nil {
 				topJob = queue.Next()
-				updatePendingJobCount()
 			}
 
 			// setup outputChannel based on the availability of a task
@@ -77,16 +88,37 @@ func newManagedSource(queue *PriorityQueue, ctx context.Context, logger *zap.Log
 
 				logger.Debug("add job", zap.Stringer("job", task))
 				queue.Add(task)
-				updatePendingJobCount()
 				// we cannot send job to outputChannel here because send is blocking, but we cannot block since we need to read new jobs from inputChannel,
 				// because if we will not read from inputChannel, send to inputChannel will be blocked but it is not what client expects (add job should be not blocking)
 
+			case request := <-removeChannel:
+				var removed JobEntry
+				if topJob != nil && topJob.String() == request.id {
+					removed = topJob
+					topJob = nil
+				} else {
+					removed = queue.Remove(request.id)
+				}
+				if removed != nil {
+					logger.Debug("remove job", zap.Stringer("job", removed))
+				}
+				request.result <- removed != nil
+
+			case result := <-pendingIdsChannel:
+				ids := queue.Ids()
+				if topJob != nil {
+					// top job will be sent to the next free worker
+					ids = append([]string{topJob.String()}, ids...)
+				}
+				result <- ids
+
 			case <-ctx.Done():
 				logger.Info("stop requested")
 				if topJob != nil {
 					logger.Info("add back job", zap.Stringer("job", topJob))
 					queue.Add(topJob)
-					updatePendingJobCount()
+					// cancelled with the rest of the queue, do not cancel twice
+					topJob = nil
 				}
 				return
 
@@ -99,3 +131,24 @@ func newManagedSource(queue *PriorityQueue, ctx context.Context, logger *zap.Log
 
 	return source
 }
+
+// removeJob returns false if job is not in the queue (already taken by worker or source is stopped).
+func (t *ManagedSource) removeJob(id string) bool {
+	request := removeRequest{id: id, result: make(chan bool, 1)}
+	select {
+	case t.remove <- request:
+		return <-request.result
+	case <-t.done:
+		return false
+	}
+}
+
+func (t *ManagedSource) getPendingIds() []string {
+	result := make(chan []string, 1)
+	select {
+	case t.pendingIds <- result:
+		return <-result
+	case <-t.done:
+		return nil
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"bytes"
 	"io"
 	"log"
+	"reflect"
 	"strconv"
 	"testing"
 	"time"
@@ -29,7 +30,7 @@ func TestNewSource(t *testing.T) {
 		return
 	}
 	ctx, cancel := context.WithCancel(context.Background())
-	ms := newManagedSource(pq, ctx, logger)
+	ms := newManagedSource(pq, ctx, func(job JobEntry) {}, logger)
 
 	// Make sure the top is not job selecting.
 	select {
@@ -123,6 +124,72 @@ func TestPriorityQueue(t *testing.T) {
 	}
 }
 
+func TestPriorityQueueRemove(t *testing.T) {
+	q := NewPriorityQueue()
+
+	buf := &bytes.Buffer{}
+	for x := 0; x < 10; x++ {
+		q.Add(newJob(&sct{name: strconv.Itoa(x), w: buf}, x))
+	}
+
+	if q.Remove("42") != nil {
+		t.Fatalf("removed job that was not added")
+	}
+
+	for _, id := range []string{"9", "4", "0", "5"} {
+		removed := q.Remove(id)
+		if removed == nil || removed.String() != id {
+			t.Fatalf("job %s was not removed: %v", id, removed)
+		}
+	}
+
+	if q.Length() != 6 {
+		t.Fatalf("q.Length() != 6 after remove: %v", q.Length())
+	}
+
+	for c := q.Next(); c != nil; c = q.Next() {
+		c.Run(context.Background(), func() {})
+	}
+	if buf.String() != "876321" {
+		t.Errorf("priority wasn't properly applied after remove. Expected 876321, but got %v", buf.String())
+	}
+}
+
+func TestPriorityQueueFifo(t *testing.T) {
+	q := NewPriorityQueue()
+	buf := &bytes.Buffer{}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		q.Add(newJob(&sct{name: name, w: buf}, 0))
+	}
+	q.Add(newJob(&sct{name: "x", w: buf}, 1))
+
+	if !reflect.DeepEqual(q.Ids(), []string{"x", "a", "b", "c", "d", "e"}) {
+		t.Errorf("q.Ids() doesn't reflect queue order: %v", q.Ids())
+	}
+
+	for c := q.Next(); c != nil; c = q.Next() {
+		c.Run(context.Background(), func() {})
+	}
+	if buf.String() != "xabcde" {
+		t.Errorf("jobs with equal priority must be done in FIFO order. Expected xabcde, but got %v", buf.String())
+	}
+}
+
+func TestCancelledJobIsNotStarted(t *testing.T) {
+	buf := &bytes.Buffer{}
+	job := newJob(&sct{name: "test", w: buf}, 0)
+	job.Cancel()
+
+	cancelled := false
+	job.Run(context.Background(), func() { cancelled = true })
+	if buf.Len() != 0 {
+		t.Errorf("cancelled job was started")
+	}
+	if !cancelled {
+		t.Errorf("cancel func was not called for cancelled job")
+	}
+}
+
 // sct is a helper for testing that basically just prints it's name to
 // w and sets the stop channel.
 type sct struct {
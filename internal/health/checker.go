@@ -0,0 +1,160 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const LivezPath = "/livez"
+const ReadyzPath = "/readyz"
+
+// checks are performed on each probe request (k8s probes are frequent), so, every check must be fast (see Cached for expensive checks)
+const checkTimeout = 5 * time.Second
+
+// CheckFunc returns error if component is not healthy.
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name     string
+	function CheckFunc
+}
+
+// Result of a check, Error is nil if check passed.
+type Result struct {
+	Name  string
+	Error error
+}
+
+// Checker holds liveness and readiness checks.
+// Liveness fails only if process must be restarted, readiness fails if process cannot serve requests now (e.g. registry is not available).
+type Checker struct {
+	liveness  []check
+	readiness []check
+	lock      sync.RWMutex
+}
+
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+func (t *Checker) AddLivenessCheck(name string, function CheckFunc) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.liveness = append(t.liveness, check{name: name, function: function})
+}
+
+func (t *Checker) AddReadinessCheck(name string, function CheckFunc) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.readiness = append(t.readiness, check{name: name, function: function})
+}
+
+func (t *Checker) CheckLiveness(ctx context.Context) []Result {
+	t.lock.RLock()
+	checks := t.liveness
+	t.lock.RUnlock()
+	return runChecks(ctx, checks)
+}
+
+func (t *Checker) CheckReadiness(ctx context.Context) []Result {
+	t.lock.RLock()
+	checks := t.readiness
+	t.lock.RUnlock()
+	return runChecks(ctx, checks)
+}
+
+func runChecks(ctx context.Context, checks []check) []Result {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	results := make([]Result, len(checks))
+	for index, check := range checks {
+		results[index] = Result{Name: check.name, Error: check.function(ctx)}
+	}
+	return results
+}
+
+// IsPassed returns true if all checks passed.
+func IsPassed(results []Result) bool {
+	for _, result := range results {
+		if result.Error != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// GetFailed returns names and errors of failed checks (e.g. to log).
+func GetFailed(results []Result) []string {
+	var failed []string
+	for _, result := range results {
+		if result.Error != nil {
+			failed = append(failed, result.Name+": "+result.Error.Error())
+		}
+	}
+	return failed
+}
+
+// Register registers /livez and /readyz. Per check output is returned if query parameter verbose is specified or if check failed.
+func (t *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc(LivezPath, func(w http.ResponseWriter, r *http.Request) {
+		writeResults(w, r, "livez", t.CheckLiveness(r.Context()))
+	})
+	mux.HandleFunc(ReadyzPath, func(w http.ResponseWriter, r *http.Request) {
+		writeResults(w, r, "readyz", t.CheckReadiness(r.Context()))
+	})
+}
+
+func writeResults(w http.ResponseWriter, r *http.Request, name string, results []Result) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+
+	isPassed := IsPassed(results)
+	_, isVerbose := r.URL.Query()["verbose"]
+	if isPassed && !isVerbose {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok\n"))
+		return
+	}
+
+	var builder strings.Builder
+	for _, result := range results {
+		if result.Error == nil {
+			_, _ = fmt.Fprintf(&builder, "[+]%s ok\n", result.Name)
+		} else {
+			_, _ = fmt.Fprintf(&builder, "[-]%s failed: %s\n", result.Name, result.Error)
+		}
+	}
+
+	if isPassed {
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintf(&builder, "%s check passed\n", name)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = fmt.Fprintf(&builder, "%s check failed\n", name)
+	}
+	_, _ = w.Write([]byte(builder.String()))
+}
+
+// Cached returns check that performs function not more often than once per ttl (e.g. to not spawn process on each probe request).
+func Cached(ttl time.Duration, function CheckFunc) CheckFunc {
+	var lock sync.Mutex
+	var lastCheck time.Time
+	var lastError error
+	return func(ctx context.Context) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		now := time.Now()
+		if lastCheck.IsZero() || now.Sub(lastCheck) >= ttl {
+			lastError = function(ctx)
+			lastCheck = now
+		}
+		return lastError
+	}
+}
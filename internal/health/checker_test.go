@@ -0,0 +1,79 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func request(t *testing.T, mux *http.ServeMux, url string) (int, string) {
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+	body, err := ioutil.ReadAll(recorder.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recorder.Code, string(body)
+}
+
+func TestChecker(t *testing.T) {
+	checker := NewChecker()
+	var diskError error
+	checker.AddReadinessCheck("etcd", func(ctx context.Context) error {
+		return nil
+	})
+	checker.AddReadinessCheck("disk", func(ctx context.Context) error {
+		return diskError
+	})
+
+	mux := http.NewServeMux()
+	checker.Register(mux)
+
+	code, body := request(t, mux, LivezPath)
+	if code != http.StatusOK || body != "ok\n" {
+		t.Errorf("livez: %d %s", code, body)
+	}
+
+	code, body = request(t, mux, ReadyzPath)
+	if code != http.StatusOK || body != "ok\n" {
+		t.Errorf("readyz: %d %s", code, body)
+	}
+
+	code, body = request(t, mux, ReadyzPath+"?verbose")
+	if code != http.StatusOK || body != "[+]etcd ok\n[+]disk ok\nreadyz check passed\n" {
+		t.Errorf("verbose readyz: %d %s", code, body)
+	}
+
+	diskError = errors.New("free space 10 MB is less than 1 GB")
+	code, body = request(t, mux, ReadyzPath)
+	if code != http.StatusServiceUnavailable || !strings.Contains(body, "[-]disk failed: free space 10 MB is less than 1 GB\n") {
+		t.Errorf("failed readyz: %d %s", code, body)
+	}
+
+	failed := GetFailed(checker.CheckReadiness(context.Background()))
+	if len(failed) != 1 || !strings.HasPrefix(failed[0], "disk: ") {
+		t.Errorf("failed checks: %v", failed)
+	}
+}
+
+func TestCached(t *testing.T) {
+	callCount := 0
+	check := Cached(time.Hour, func(ctx context.Context) error {
+		callCount++
+		return errors.New("not found")
+	})
+
+	for i := 0; i < 3; i++ {
+		if check(context.Background()) == nil {
+			t.Fatal("error must be cached")
+		}
+	}
+	if callCount != 1 {
+		t.Errorf("check must be performed once, performed %d times", callCount)
+	}
+}
@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/didip/tollbooth"
+	"github.com/didip/tollbooth/limiter"
+	"github.com/electronuserland/electron-build-service/internal/config"
+	"github.com/json-iterator/go"
+	"github.com/tomasen/realip"
+	"go.uber.org/zap"
+)
+
+func WriteJson(w http.ResponseWriter, value interface{}, logger *zap.Logger) {
+	data, err := jsoniter.ConfigFastest.Marshal(value)
+	if err != nil {
+		logger.Error("cannot serialize", zap.Error(err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// RequireAdminToken checks that request is authorized by header `Authorization: Bearer <token>`.
+func RequireAdminToken(token string, logger *zap.Logger, handler http.HandlerFunc) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			logger.Warn("unauthorized admin request", zap.String("path", r.URL.Path), zap.String("ip", realip.FromRequest(r)))
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	})
+}
+
+// CreateRateLimiter creates limiter per client IP
+func CreateRateLimiter(rateLimit config.RateLimit) *limiter.Limiter {
+	result := tollbooth.NewLimiter(rateLimit.Rate, &limiter.ExpirableOptions{DefaultExpirationTTL: time.Hour})
+	result.SetBurst(rateLimit.Burst)
+	return result
+}
+
+// ApplyRateLimit changes limit on config reload, limit is applied to new clients (existing per client buckets expire within an hour)
+func ApplyRateLimit(rateLimiter *limiter.Limiter, rateLimit config.RateLimit) {
+	rateLimiter.SetMax(rateLimit.Rate)
+	rateLimiter.SetBurst(rateLimit.Burst)
+}
@@ -0,0 +1,176 @@
+package router
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/electronuserland/electron-build-service/internal"
+	"github.com/electronuserland/electron-build-service/internal/agentRegistry"
+	"github.com/electronuserland/electron-build-service/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// the same path as agent serves
+const buildPath = "/v2/build"
+
+// request is retried on the next agent only if upload is not started, so, a few attempts are enough
+const maxBuildAttempts = 3
+
+// BuildProxy accepts build and streams upload and response through to the agent selected by AgentRouter logic (the least loaded first).
+// If agent refuses connection or rejects build before upload is started (e.g. draining), the next agent is tried.
+type BuildProxy struct {
+	router      *AgentRouter
+	tokenSigner *RoutingTokenSigner
+	transport   http.RoundTripper
+	logger      *zap.Logger
+}
+
+func (t *BuildProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, span := tracing.StartServerSpan(r, "proxy-build")
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	agents, result := t.router.getAgents(w, span)
+	if result != resultOk {
+		proxyRequestCount.WithLabelValues("build", result).Inc()
+		return
+	}
+
+	candidates := selectAvailable(agents)
+	if len(candidates) == 0 {
+		writeOverloaded(w, span, t.logger)
+		proxyRequestCount.WithLabelValues("build", resultOverloaded).Inc()
+		return
+	}
+	if len(candidates) > maxBuildAttempts {
+		candidates = candidates[:maxBuildAttempts]
+	}
+
+	roundTripper := &buildRoundTripper{
+		agents:    candidates,
+		transport: t.transport,
+		agent:     atomic.NewString(""),
+		logger:    t.logger,
+	}
+
+	result = resultOk
+	proxy := createAgentProxy(candidates[0].Address, roundTripper, t.logger)
+	// build progress is streamed
+	proxy.FlushInterval = -1
+	director := proxy.Director
+	clientIdentity := internal.GetClientIdentity(r)
+	proxy.Director = func(request *http.Request) {
+		director(request)
+		tracing.Inject(ctx, request.Header)
+		forwardClientIdentity(request.Header, clientIdentity)
+		// agent rejects build before reading body if cannot accept it, so, request can be retried on another agent
+		if request.Body != nil {
+			request.Header.Set("Expect", "100-continue")
+		}
+	}
+	proxy.ModifyResponse = func(response *http.Response) error {
+		agent := roundTripper.agent.Load()
+		span.SetAttributes(attribute.String("agent.address", agent))
+		if t.tokenSigner != nil {
+			// artifacts are stored on agent, client can pass token to download via router
+			token, err := t.tokenSigner.Sign(agent, time.Now())
+			if err != nil {
+				return err
+			}
+			response.Header.Set(RoutingTokenHeader, token)
+		}
+		return nil
+	}
+	handleError := proxy.ErrorHandler
+	proxy.ErrorHandler = func(w http.ResponseWriter, request *http.Request, err error) {
+		result = resultAgentError
+		span.RecordError(err)
+		handleError(w, request, err)
+	}
+	proxy.ServeHTTP(w, r)
+	proxyRequestCount.WithLabelValues("build", result).Inc()
+}
+
+// forwardClientIdentity sets identity of the client authenticated by router - agent authenticates router, not the client.
+// Header set by client is never passed through.
+func forwardClientIdentity(header http.Header, clientIdentity string) {
+	header.Del(internal.ForwardedClientIdentityHeader)
+	if clientIdentity != "" {
+		header.Set(internal.ForwardedClientIdentityHeader, clientIdentity)
+	}
+}
+
+// buildRoundTripper tries agents in order until one accepts the request.
+// Request is retried only if upload is not started (agent refused connection or responded without reading body).
+type buildRoundTripper struct {
+	agents    []agentRegistry.BuildAgent
+	transport http.RoundTripper
+	// agent that accepted request
+	agent  *atomic.String
+	logger *zap.Logger
+}
+
+func (t *buildRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	var body *uploadBody
+	if request.Body != nil {
+		body = &uploadBody{reader: request.Body, isRead: atomic.NewBool(false)}
+	}
+
+	var response *http.Response
+	var err error
+	for index, agent := range t.agents {
+		attempt := request.WithContext(request.Context())
+		attemptUrl := *request.URL
+		attemptUrl.Host = agent.Address
+		attempt.URL = &attemptUrl
+		attempt.Host = agent.Address
+		if body != nil {
+			attempt.Body = body
+		}
+
+		response, err = t.transport.RoundTrip(attempt)
+		t.agent.Store(agent.Address)
+		isUploadStarted := body != nil && body.isRead.Load()
+		if isUploadStarted || (err == nil && !isRejected(response.StatusCode)) || index == len(t.agents)-1 {
+			break
+		}
+
+		if err == nil {
+			t.logger.Warn("agent rejected build, try next agent", zap.String("agent", agent.Address), zap.Int("status", response.StatusCode))
+			_ = response.Body.Close()
+		} else {
+			t.logger.Warn("cannot proxy build to agent, try next agent", zap.String("agent", agent.Address), zap.Error(err))
+		}
+		proxyRetryCount.Inc()
+	}
+	return response, err
+}
+
+// agent cannot accept build now, but another agent can (draining or not enough disk space)
+func isRejected(statusCode int) bool {
+	return statusCode == http.StatusServiceUnavailable || statusCode == http.StatusInsufficientStorage
+}
+
+// uploadBody tracks whether upload is started and is not closed by transport, so, the same incoming body can be sent to another agent
+type uploadBody struct {
+	reader io.Reader
+	isRead *atomic.Bool
+}
+
+func (t *uploadBody) Read(p []byte) (int, error) {
+	t.isRead.Store(true)
+	return t.reader.Read(p)
+}
+
+func (t *uploadBody) Close() error {
+	// incoming request body is closed by server
+	return nil
+}
@@ -0,0 +1,104 @@
+package router
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/electronuserland/electron-build-service/internal"
+	"github.com/electronuserland/electron-build-service/internal/agentRegistry"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+func startAgent(t *testing.T, readBody bool, status int) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if readBody {
+			var err error
+			body, err = ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write(body)
+	}))
+}
+
+func proxyBuild(t *testing.T, agents ...*httptest.Server) (*http.Response, string) {
+	// httptest servers use the same certificate
+	transport := agents[0].Client().Transport.(*http.Transport)
+	transport.ExpectContinueTimeout = 10 * time.Second
+
+	roundTripper := &buildRoundTripper{
+		transport: transport,
+		agent:     atomic.NewString(""),
+		logger:    zap.NewNop(),
+	}
+	for _, agent := range agents {
+		roundTripper.agents = append(roundTripper.agents, agentRegistry.BuildAgent{Address: agent.Listener.Addr().String()})
+	}
+
+	request, err := http.NewRequest(http.MethodPost, "https://"+roundTripper.agents[0].Address+buildPath, bytes.NewReader([]byte("project archive")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Expect", "100-continue")
+
+	response, err := roundTripper.RoundTrip(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response, roundTripper.agent.Load()
+}
+
+func TestBuildProxyRetriesIfAgentRejectedBeforeUpload(t *testing.T) {
+	drainingAgent := startAgent(t, false, http.StatusServiceUnavailable)
+	defer drainingAgent.Close()
+	agent := startAgent(t, true, http.StatusOK)
+	defer agent.Close()
+
+	response, selectedAgent := proxyBuild(t, drainingAgent, agent)
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || string(body) != "project archive" {
+		t.Errorf("build must be proxied to the next agent: %d %s", response.StatusCode, body)
+	}
+	if selectedAgent != agent.Listener.Addr().String() {
+		t.Errorf("unexpected agent: %s", selectedAgent)
+	}
+}
+
+func TestBuildProxyDoesNotRetryIfUploadStarted(t *testing.T) {
+	failedAgent := startAgent(t, true, http.StatusServiceUnavailable)
+	defer failedAgent.Close()
+	agent := startAgent(t, true, http.StatusOK)
+	defer agent.Close()
+
+	response, selectedAgent := proxyBuild(t, failedAgent, agent)
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusServiceUnavailable || selectedAgent != failedAgent.Listener.Addr().String() {
+		t.Errorf("build must be not retried after upload is started: %d %s", response.StatusCode, selectedAgent)
+	}
+}
+
+func TestForwardClientIdentity(t *testing.T) {
+	header := http.Header{}
+	header.Set(internal.ForwardedClientIdentityHeader, "spoofed")
+	forwardClientIdentity(header, "")
+	if value, ok := header[internal.ForwardedClientIdentityHeader]; ok {
+		t.Errorf("identity set by unauthenticated client must be not forwarded: %v", value)
+	}
+
+	header.Set(internal.ForwardedClientIdentityHeader, "spoofed")
+	forwardClientIdentity(header, "ci.example.com")
+	if header.Get(internal.ForwardedClientIdentityHeader) != "ci.example.com" || len(header[internal.ForwardedClientIdentityHeader]) != 1 {
+		t.Errorf("identity of authenticated client must be forwarded: %v", header[internal.ForwardedClientIdentityHeader])
+	}
+}
@@ -0,0 +1,106 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+
+	"github.com/electronuserland/electron-build-service/internal/agentRegistry"
+	"github.com/electronuserland/electron-build-service/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.uber.org/zap"
+)
+
+// the same path as agent serves, so, client can use router host instead of agent endpoint
+const downloadPath = "/v2/download/"
+
+// DownloadProxy proxies /v2/download/{jobId}/{file} to agent that owns the job (artifacts are stored only on agent that built them).
+// Agent is taken from routing token if specified (see RoutingTokenHeader), otherwise from job summary published by agent.
+type DownloadProxy struct {
+	agentRegistry *agentRegistry.AgentRegistry
+	// nil if routing tokens are not enabled
+	tokenSigner *RoutingTokenSigner
+	transport   http.RoundTripper
+	logger      *zap.Logger
+}
+
+func (t *DownloadProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, span := tracing.StartServerSpan(r, "proxy-download")
+	defer span.End()
+
+	jobId := r.URL.Path[len(downloadPath):]
+	slashIndex := strings.IndexByte(jobId, '/')
+	if slashIndex <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	jobId = jobId[:slashIndex]
+	span.SetAttributes(attribute.String("job.id", jobId))
+
+	agent, result := t.resolveAgent(r, jobId)
+	if agent == "" {
+		span.SetStatus(codes.Error, result)
+		proxyRequestCount.WithLabelValues("download", result).Inc()
+		if result == resultJobNotFound {
+			http.Error(w, "job not found", http.StatusNotFound)
+		} else {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	span.SetAttributes(attribute.String("agent.address", agent))
+	result = resultOk
+	proxy := createAgentProxy(agent, t.transport, t.logger)
+	handleError := proxy.ErrorHandler
+	proxy.ErrorHandler = func(w http.ResponseWriter, request *http.Request, err error) {
+		result = resultAgentError
+		span.RecordError(err)
+		handleError(w, request, err)
+	}
+	proxy.ServeHTTP(w, r)
+	proxyRequestCount.WithLabelValues("download", result).Inc()
+}
+
+// resolveAgent returns empty address and result if agent cannot be determined
+func (t *DownloadProxy) resolveAgent(r *http.Request, jobId string) (string, string) {
+	token := r.Header.Get(RoutingTokenHeader)
+	if token != "" && t.tokenSigner != nil {
+		agent, err := t.tokenSigner.Verify(token, time.Now())
+		if err == nil {
+			return agent, resultOk
+		}
+		// not an error - job can be still found in the registry
+		t.logger.Warn("routing token is not accepted", zap.String("jobId", jobId), zap.NamedError("reason", err))
+	}
+
+	job, err := t.agentRegistry.GetJob(jobId)
+	if err != nil {
+		t.logger.Error("cannot get job", zap.String("jobId", jobId), zap.Error(err))
+		return "", resultError
+	}
+	if job == nil || job.Agent == "" {
+		return "", resultJobNotFound
+	}
+	return job.Agent, resultOk
+}
+
+// createAgentProxy returns reverse proxy to agent (agents serve only https, see find-build-agent endpoint)
+func createAgentProxy(agent string, transport http.RoundTripper, logger *zap.Logger) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Director: func(request *http.Request) {
+			request.URL.Scheme = "https"
+			request.URL.Host = agent
+			request.Host = agent
+			// token is for router only
+			request.Header.Del(RoutingTokenHeader)
+		},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, request *http.Request, err error) {
+			logger.Error("cannot proxy request to agent", zap.String("agent", agent), zap.String("path", request.URL.Path), zap.Error(err))
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+}
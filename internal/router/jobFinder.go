@@ -0,0 +1,75 @@
+package router
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/electronuserland/electron-build-service/internal"
+	"github.com/electronuserland/electron-build-service/internal/agentRegistry"
+	"go.uber.org/zap"
+)
+
+const routerJobsPath = "/jobs"
+
+// JobFinder aggregates job summaries published by agents to answer which agent owns a job.
+type JobFinder struct {
+	agentRegistry *agentRegistry.AgentRegistry
+	logger        *zap.Logger
+}
+
+// ServeHTTP handles:
+// GET /jobs?tenant=&state= - jobs of all agents (optionally filtered by tenant and state),
+// GET /jobs/{id} - job summary (field agent is the address of the agent that owns job).
+func (t *JobFinder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobId := strings.Trim(r.URL.Path[len(routerJobsPath):], "/")
+	if jobId != "" {
+		if strings.Contains(jobId, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		job, err := t.agentRegistry.GetJob(jobId)
+		if err != nil {
+			t.logger.Error("cannot get job", zap.String("jobId", jobId), zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if job == nil {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+
+		internal.WriteJson(w, job, t.logger)
+		return
+	}
+
+	jobs, err := t.agentRegistry.GetJobs()
+	if err != nil {
+		t.logger.Error("cannot get jobs", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	tenant := query.Get("tenant")
+	state := query.Get("state")
+
+	result := make([]*agentRegistry.JobSummary, 0, len(jobs))
+	for _, job := range jobs {
+		if (tenant == "" || job.Tenant == tenant) && (state == "" || job.State == state) {
+			result = append(result, job)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].QueuedAt.Before(result[j].QueuedAt)
+	})
+	internal.WriteJson(w, result, t.logger)
+}
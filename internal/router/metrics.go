@@ -0,0 +1,35 @@
+package router
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var requestCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "router_requests_total",
+	Help: "Number of find-build-agent requests by result (ok, noAgents, overloaded, notSynced, error).",
+}, []string{"result"})
+
+var requestDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "router_request_duration_seconds",
+	Help:    "Duration of find-build-agent requests.",
+	Buckets: prometheus.DefBuckets,
+})
+
+var agentCount = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "router_agents",
+	Help: "Number of registered build agents (as of the last find-build-agent request).",
+})
+
+var proxyRequestCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "router_proxy_requests_total",
+	Help: "Number of requests proxied to agents by type (build, download) and result (ok, jobNotFound, noAgents, overloaded, notSynced, agentError, error).",
+}, []string{"type", "result"})
+
+var proxyRetryCount = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "router_proxy_build_retries_total",
+	Help: "Number of builds retried on another agent because selected agent refused or rejected build before upload.",
+})
+
+func init() {
+	prometheus.MustRegister(requestCount, requestDuration, agentCount, proxyRequestCount, proxyRetryCount)
+}
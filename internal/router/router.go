@@ -0,0 +1,274 @@
+package router
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/develar/errors"
+	"github.com/didip/tollbooth"
+	"github.com/electronuserland/electron-build-service/internal"
+	"github.com/electronuserland/electron-build-service/internal/agentRegistry"
+	"github.com/electronuserland/electron-build-service/internal/config"
+	"github.com/electronuserland/electron-build-service/internal/health"
+	"github.com/electronuserland/electron-build-service/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const resultOk = "ok"
+const resultNoAgents = "noAgents"
+const resultOverloaded = "overloaded"
+
+// agent list is not loaded yet (etcd is not available since start)
+const resultNotSynced = "notSynced"
+
+// proxy results
+const resultJobNotFound = "jobNotFound"
+const resultAgentError = "agentError"
+const resultError = "error"
+
+// client retries request after at least 30 seconds if all agents are overloaded
+const maxAgentJobCount = 16
+
+type AgentRouter struct {
+	agentRegistry *agentRegistry.AgentRegistry
+	// nil if routing tokens are not enabled
+	tokenSigner *RoutingTokenSigner
+	// router endpoint is returned instead of agent endpoint (builds are proxied, see BuildProxy)
+	isProxyMode bool
+	logger      *zap.Logger
+}
+
+type findAgentResponse struct {
+	Endpoint string `json:"endpoint"`
+	// pass in RoutingTokenHeader to route follow-up requests to the same agent
+	RoutingToken string `json:"routingToken,omitempty"`
+}
+
+func (t *AgentRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, span := tracing.StartServerSpan(r, "find-build-agent")
+	defer span.End()
+
+	start := time.Now()
+	result := t.route(w, r, span)
+	requestCount.WithLabelValues(result).Inc()
+	requestDuration.Observe(time.Since(start).Seconds())
+}
+
+func (t *AgentRouter) route(w http.ResponseWriter, r *http.Request, span trace.Span) string {
+	agents, result := t.getAgents(w, span)
+	if result != resultOk {
+		return result
+	}
+
+	if t.isProxyMode {
+		// only availability is checked, agent is selected on build request
+		if len(selectAvailable(agents)) == 0 {
+			writeOverloaded(w, span, t.logger)
+			return resultOverloaded
+		}
+
+		span.SetAttributes(attribute.Bool("router.proxy", true))
+		internal.WriteJson(w, findAgentResponse{Endpoint: "https://" + r.Host}, t.logger)
+		return resultOk
+	}
+
+	agent, isSticky := t.getStickyAgent(r, agents)
+	if !isSticky {
+		available := selectAvailable(agents)
+		if len(available) == 0 {
+			writeOverloaded(w, span, t.logger)
+			return resultOverloaded
+		}
+		agent = available[0]
+	}
+
+	span.SetAttributes(attribute.String("agent.address", agent.Address), attribute.Int("agent.jobCount", agent.JobCount), attribute.Bool("agent.sticky", isSticky))
+
+	response := findAgentResponse{Endpoint: "https://" + agent.Address}
+	if t.tokenSigner != nil {
+		var err error
+		response.RoutingToken, err = t.tokenSigner.Sign(agent.Address, time.Now())
+		if err != nil {
+			span.RecordError(err)
+			t.logger.Error("cannot sign routing token", zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return resultError
+		}
+	}
+	internal.WriteJson(w, response, t.logger)
+	return resultOk
+}
+
+// getAgents returns registered agents (the least loaded first), error response is written if there are no agents or agents cannot be get
+func (t *AgentRouter) getAgents(w http.ResponseWriter, span trace.Span) ([]agentRegistry.BuildAgent, string) {
+	agents, err := t.agentRegistry.GetAgents()
+	if err == agentRegistry.ErrNotSynced {
+		span.SetStatus(codes.Error, err.Error())
+		t.logger.Warn("cannot get agents", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return nil, resultNotSynced
+	}
+	if err != nil {
+		span.RecordError(err)
+		t.logger.Error("cannot get agents", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil, resultError
+	}
+
+	agentCount.Set(float64(len(agents)))
+
+	if len(agents) == 0 {
+		errorMessage := "no running build agents"
+		span.SetStatus(codes.Error, errorMessage)
+		t.logger.Error(errorMessage)
+		http.Error(w, errorMessage, http.StatusServiceUnavailable)
+		return nil, resultNoAgents
+	}
+
+	if len(agents) > 1 {
+		sort.Stable(byWeight(agents))
+	}
+	return agents, resultOk
+}
+
+// selectAvailable returns agents that are not overloaded (order is preserved)
+func selectAvailable(agents []agentRegistry.BuildAgent) []agentRegistry.BuildAgent {
+	var result []agentRegistry.BuildAgent
+	for _, agent := range agents {
+		if agent.JobCount <= maxAgentJobCount {
+			result = append(result, agent)
+		}
+	}
+	return result
+}
+
+func writeOverloaded(w http.ResponseWriter, span trace.Span, logger *zap.Logger) {
+	errorMessage := "all build agents are overloaded"
+	span.SetStatus(codes.Error, errorMessage)
+	logger.Error(errorMessage)
+	http.Error(w, errorMessage, http.StatusServiceUnavailable)
+}
+
+// getStickyAgent returns agent from routing token if token is valid and agent is still registered (agent is selected even if overloaded - follow-up request must be served by the same agent)
+func (t *AgentRouter) getStickyAgent(r *http.Request, agents []agentRegistry.BuildAgent) (agentRegistry.BuildAgent, bool) {
+	token := r.Header.Get(RoutingTokenHeader)
+	if token == "" || t.tokenSigner == nil {
+		return agentRegistry.BuildAgent{}, false
+	}
+
+	address, err := t.tokenSigner.Verify(token, time.Now())
+	if err != nil {
+		t.logger.Warn("routing token is not accepted", zap.NamedError("reason", err))
+		return agentRegistry.BuildAgent{}, false
+	}
+
+	for _, agent := range agents {
+		if agent.Address == address {
+			return agent, true
+		}
+	}
+
+	t.logger.Warn("agent from routing token is not registered", zap.String("agent", address))
+	return agentRegistry.BuildAgent{}, false
+}
+
+// Configure registers router endpoints (/find-build-agent, /jobs) and registry readiness check. Returned registry must be closed on shutdown.
+// Dedicated router also proxies /v2/download/ to agent that owns the job (build agent serves own downloads) and, if ProxyBuilds is enabled, /v2/build to selected agent.
+func Configure(configManager *config.Manager, checker *health.Checker, isDedicated bool, logger *zap.Logger) (*agentRegistry.AgentRegistry, error) {
+	configuration := configManager.Get()
+
+	var tokenSigner *RoutingTokenSigner
+	if configuration.RoutingKeyFile != "" {
+		var err error
+		tokenSigner, err = LoadRoutingTokenSigner(configuration.RoutingKeyFile, time.Duration(configuration.RoutingTokenTtl))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	isProxyMode := isDedicated && configuration.ProxyBuilds
+
+	var transport http.RoundTripper
+	if isDedicated {
+		tlsConfig, err := internal.CreateAgentTlsConfig(configuration.AgentCa)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		transport = &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: 10 * time.Second,
+			IdleConnTimeout:     90 * time.Second,
+			MaxIdleConnsPerHost: 16,
+			// if agent doesn't respond in time, upload is started anyway (and build is not retried on another agent)
+			ExpectContinueTimeout: 10 * time.Second,
+		}
+	}
+
+	limit := internal.CreateRateLimiter(configuration.RateLimits.Router)
+	buildLimit := internal.CreateRateLimiter(configuration.RateLimits.Build)
+	downloadLimit := internal.CreateRateLimiter(configuration.RateLimits.Download)
+	configManager.OnReload(func(configuration *config.Config) {
+		internal.ApplyRateLimit(limit, configuration.RateLimits.Router)
+		internal.ApplyRateLimit(buildLimit, configuration.RateLimits.Build)
+		internal.ApplyRateLimit(downloadLimit, configuration.RateLimits.Download)
+	})
+
+	a := agentRegistry.NewAgentRegistry(configuration.EtcdEndpoint, logger)
+	agentRouter := &AgentRouter{
+		agentRegistry: a,
+		tokenSigner:   tokenSigner,
+		isProxyMode:   isProxyMode,
+		logger:        logger,
+	}
+	http.Handle("/find-build-agent", tollbooth.LimitHandler(limit, agentRouter))
+
+	if isProxyMode {
+		http.Handle(buildPath, tollbooth.LimitHandler(buildLimit, &BuildProxy{
+			router:      agentRouter,
+			tokenSigner: tokenSigner,
+			transport:   transport,
+			logger:      logger,
+		}))
+		logger.Info("builds are proxied to agents")
+	}
+
+	if isDedicated {
+		http.Handle(downloadPath, tollbooth.LimitHandler(downloadLimit, &DownloadProxy{
+			agentRegistry: a,
+			tokenSigner:   tokenSigner,
+			transport:     transport,
+			logger:        logger,
+		}))
+	}
+
+	// router is ready if registry is available (distinct name - build agent has own registry check)
+	checker.AddReadinessCheck("routerRegistry", a.Ping)
+
+	// job view exposes tenants and client IPs, so, available only for admin
+	adminToken := configuration.AdminToken
+	if adminToken != "" {
+		jobFinder := internal.RequireAdminToken(adminToken, logger, (&JobFinder{
+			agentRegistry: a,
+			logger:        logger,
+		}).ServeHTTP)
+		http.Handle(routerJobsPath, jobFinder)
+		http.Handle(routerJobsPath+"/", jobFinder)
+	}
+	return a, nil
+}
+
+func getWeight(agent agentRegistry.BuildAgent) int {
+	return agent.JobCount / agent.CpuCount
+}
+
+type byWeight []agentRegistry.BuildAgent
+
+func (v byWeight) Len() int           { return len(v) }
+func (v byWeight) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v byWeight) Less(i, j int) bool { return getWeight(v[i]) < getWeight(v[j]) }
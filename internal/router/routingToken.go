@@ -0,0 +1,98 @@
+package router
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/develar/errors"
+	"github.com/json-iterator/go"
+)
+
+// RoutingTokenHeader is used by client or proxy to pass routing token (returned by /find-build-agent) in follow-up requests,
+// so, the same agent is selected and request is proxied to agent that built the job.
+const RoutingTokenHeader = "X-Routing-Token"
+
+// key is used as is (random bytes or string), short keys are rejected
+const minRoutingKeyLength = 32
+
+var errInvalidRoutingToken = errors.New("routing token is invalid")
+var errRoutingTokenExpired = errors.New("routing token is expired")
+
+// RoutingTokenSigner issues and verifies tokens bound to the agent address: base64url(payload) "." base64url(HMAC-SHA256(payload)).
+type RoutingTokenSigner struct {
+	key []byte
+	ttl time.Duration
+}
+
+type routingTokenPayload struct {
+	Agent string `json:"agent"`
+	// unix time
+	Expires int64 `json:"exp"`
+}
+
+func NewRoutingTokenSigner(key []byte, ttl time.Duration) (*RoutingTokenSigner, error) {
+	if len(key) < minRoutingKeyLength {
+		return nil, errors.Errorf("routing key must be at least %d bytes", minRoutingKeyLength)
+	}
+	return &RoutingTokenSigner{key: key, ttl: ttl}, nil
+}
+
+// LoadRoutingTokenSigner reads key from file (trailing whitespace is ignored, k8s secrets often end with new line).
+func LoadRoutingTokenSigner(file string, ttl time.Duration) (*RoutingTokenSigner, error) {
+	key, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return NewRoutingTokenSigner(bytes.TrimRight(key, " \t\r\n"), ttl)
+}
+
+func (t *RoutingTokenSigner) Sign(agent string, now time.Time) (string, error) {
+	payload, err := jsoniter.ConfigFastest.Marshal(routingTokenPayload{Agent: agent, Expires: now.Add(t.ttl).Unix()})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	return encodedPayload + "." + base64.RawURLEncoding.EncodeToString(t.computeSignature(encodedPayload)), nil
+}
+
+// Verify returns agent address the token is bound to.
+func (t *RoutingTokenSigner) Verify(token string, now time.Time) (string, error) {
+	separatorIndex := strings.IndexByte(token, '.')
+	if separatorIndex <= 0 {
+		return "", errInvalidRoutingToken
+	}
+
+	encodedPayload := token[:separatorIndex]
+	signature, err := base64.RawURLEncoding.DecodeString(token[separatorIndex+1:])
+	if err != nil || !hmac.Equal(signature, t.computeSignature(encodedPayload)) {
+		return "", errInvalidRoutingToken
+	}
+
+	payloadData, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", errInvalidRoutingToken
+	}
+
+	var payload routingTokenPayload
+	err = jsoniter.ConfigFastest.Unmarshal(payloadData, &payload)
+	if err != nil || payload.Agent == "" {
+		return "", errInvalidRoutingToken
+	}
+
+	if now.Unix() >= payload.Expires {
+		return "", errRoutingTokenExpired
+	}
+	return payload.Agent, nil
+}
+
+func (t *RoutingTokenSigner) computeSignature(encodedPayload string) []byte {
+	mac := hmac.New(sha256.New, t.key)
+	_, _ = mac.Write([]byte(encodedPayload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,101 @@
+package router
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var testRoutingKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestRoutingToken(t *testing.T) {
+	signer, err := NewRoutingTokenSigner(testRoutingKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	token, err := signer.Sign("10.0.0.1:443", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent, err := signer.Verify(token, now.Add(time.Minute))
+	if err != nil || agent != "10.0.0.1:443" {
+		t.Errorf("token must be valid: %s %v", agent, err)
+	}
+
+	_, err = signer.Verify(token, now.Add(2*time.Hour))
+	if err != errRoutingTokenExpired {
+		t.Errorf("token must be expired: %v", err)
+	}
+
+	otherSigner, err := NewRoutingTokenSigner([]byte(strings.Repeat("x", minRoutingKeyLength)), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := otherSigner.Sign("10.0.0.2:443", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// signature of another key, payload of another token, garbage
+	payload := otherToken[:strings.IndexByte(otherToken, '.')]
+	for _, invalidToken := range []string{otherToken, payload + token[strings.IndexByte(token, '.'):], "foo", ".", token + "x"} {
+		_, err = signer.Verify(invalidToken, now)
+		if err != errInvalidRoutingToken {
+			t.Errorf("token %q must be invalid: %v", invalidToken, err)
+		}
+	}
+
+	_, err = NewRoutingTokenSigner([]byte("short"), time.Hour)
+	if err == nil {
+		t.Error("short key must be rejected")
+	}
+}
+
+func TestDownloadProxyUsesRoutingToken(t *testing.T) {
+	agentServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(RoutingTokenHeader) != "" {
+			t.Error("routing token must be not passed to agent")
+		}
+		_, _ = w.Write([]byte("artifact " + r.URL.Path))
+	}))
+	defer agentServer.Close()
+
+	signer, err := NewRoutingTokenSigner(testRoutingKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := signer.Sign(agentServer.Listener.Addr().String(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := &DownloadProxy{
+		tokenSigner: signer,
+		transport:   agentServer.Client().Transport,
+		logger:      zap.NewNop(),
+	}
+
+	request := httptest.NewRequest(http.MethodGet, downloadPath+"job1/app.dmg", nil)
+	request.Header.Set(RoutingTokenHeader, token)
+	recorder := httptest.NewRecorder()
+	proxy.ServeHTTP(recorder, request)
+
+	body, _ := ioutil.ReadAll(recorder.Result().Body)
+	if recorder.Code != http.StatusOK || string(body) != "artifact /v2/download/job1/app.dmg" {
+		t.Errorf("unexpected response: %d %s", recorder.Code, body)
+	}
+
+	recorder = httptest.NewRecorder()
+	proxy.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, downloadPath+"job1", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("file path is required: %d", recorder.Code)
+	}
+}
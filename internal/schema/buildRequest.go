@@ -0,0 +1,121 @@
+package schema
+
+// BuildRequestSchema is the published schema of the build request (header x-build-request), served by agent at BuildRequestSchemaPath.
+// Only Linux targets are supported for now. Target name depends on platform, so, it is checked in allOf.
+// Unknown properties are not allowed (names are case-sensitive, e.g. UnpackedDirName is rejected, not decoded as unpackedDirName).
+const BuildRequestSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://github.com/electron-userland/electron-build-service/build-request.schema.json",
+  "title": "Build request",
+  "description": "Value of header x-build-request.",
+  "type": "object",
+  "required": ["platform", "targets"],
+  "properties": {
+    "platform": {
+      "type": "string",
+      "enum": ["linux"]
+    },
+    "targets": {
+      "type": "array",
+      "minItems": 1,
+      "maxItems": 32,
+      "items": {
+        "type": "object",
+        "required": ["name", "arch", "unpackedDirName"],
+        "additionalProperties": false,
+        "properties": {
+          "name": {
+            "type": "string"
+          },
+          "arch": {
+            "type": "string",
+            "enum": ["ia32", "x64", "armv7l", "arm64"]
+          },
+          "unpackedDirName": {
+            "description": "Name (not path) of the directory with prepackaged app in the upload, e.g. linux-unpacked.",
+            "type": "string",
+            "maxLength": 255,
+            "pattern": "^[A-Za-z0-9_][A-Za-z0-9._-]*$"
+          }
+        }
+      }
+    },
+    "electronDownload": {
+      "description": "If specified, Electron is unpacked by agent into unpackedDirName of the first target. Client file system paths (cache, customDir, customFilename) are not allowed.",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "version": {
+          "type": "string",
+          "pattern": "^[0-9]+\\.[0-9]+\\.[0-9]+(-[0-9A-Za-z.-]+)?$"
+        },
+        "platform": {
+          "type": "string",
+          "enum": ["linux"]
+        },
+        "arch": {
+          "type": "string",
+          "enum": ["ia32", "x64", "armv7l", "arm64"]
+        },
+        "mirror": {
+          "description": "Ignored, agent downloads Electron from the default location.",
+          "type": "string"
+        }
+      }
+    },
+    "executableName": {
+      "description": "Electron executable is renamed to.",
+      "type": "string",
+      "maxLength": 255,
+      "pattern": "^[^/\\\\.][^/\\\\]*$"
+    }
+  },
+  "additionalProperties": false,
+  "allOf": [
+    {
+      "if": {
+        "required": ["platform"],
+        "properties": {"platform": {"const": "linux"}}
+      },
+      "then": {
+        "properties": {
+          "targets": {
+            "items": {
+              "properties": {
+                "name": {
+                  "enum": ["appImage", "snap", "deb", "rpm", "sh", "freebsd", "pacman", "apk", "p5p", "zip", "7z", "tar.xz", "tar.lz", "tar.gz", "tar.bz2", "dir"]
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    {
+      "if": {
+        "required": ["electronDownload"],
+        "properties": {
+          "electronDownload": {
+            "type": "object",
+            "required": ["version"],
+            "properties": {"version": {"minLength": 1}}
+          }
+        }
+      },
+      "then": {
+        "required": ["executableName"]
+      }
+    }
+  ]
+}
+`
+
+// BuildRequestSchemaPath is the path the schema is published at.
+const BuildRequestSchemaPath = "/v2/build-request.schema.json"
+
+var buildRequestSchema = MustCompile([]byte(BuildRequestSchema))
+
+// ValidateBuildRequest returns all violations of the build request (nil if request is valid).
+func ValidateBuildRequest(data []byte) []Violation {
+	return buildRequestSchema.ValidateJson(data)
+}
@@ -0,0 +1,353 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/develar/errors"
+)
+
+// keywords that don't affect validation
+var annotations = map[string]bool{"$schema": true, "$id": true, "$comment": true, "title": true, "description": true, "examples": true, "default": true}
+
+// Schema is a compiled JSON Schema (draft-07).
+// Only subset of keywords is supported, unsupported keywords are rejected on compile (so, published schema cannot be silently not enforced).
+type Schema struct {
+	types []string
+
+	// object
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *bool
+
+	// array
+	items    *Schema
+	minItems int
+	maxItems int
+
+	// string
+	minLength int
+	maxLength int
+	pattern   *regexp.Regexp
+
+	enum       []interface{}
+	constValue interface{}
+	hasConst   bool
+
+	allOf      []*Schema
+	ifSchema   *Schema
+	thenSchema *Schema
+}
+
+// Violation describes why value doesn't match schema.
+type Violation struct {
+	// JSON pointer to the invalid value (empty for the root value)
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func Compile(data []byte) (*Schema, error) {
+	var raw interface{}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return compile(raw, "")
+}
+
+func MustCompile(data []byte) *Schema {
+	schema, err := Compile(data)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+func compile(raw interface{}, path string) (*Schema, error) {
+	object, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("%s: schema must be an object", path)
+	}
+
+	result := &Schema{maxItems: -1, maxLength: -1}
+	for _, keyword := range getSortedKeys(object) {
+		value := object[keyword]
+		keywordPath := path + "/" + keyword
+		var err error
+		switch keyword {
+		case "type":
+			result.types, err = compileStringList(value, keywordPath)
+		case "properties":
+			properties, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, errors.Errorf("%s must be an object", keywordPath)
+			}
+			result.properties = make(map[string]*Schema, len(properties))
+			for name, property := range properties {
+				result.properties[name], err = compile(property, keywordPath+"/"+name)
+				if err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			result.required, err = compileStringList(value, keywordPath)
+		case "additionalProperties":
+			isAllowed, ok := value.(bool)
+			if !ok {
+				return nil, errors.Errorf("%s: only boolean value is supported", keywordPath)
+			}
+			result.additionalProperties = &isAllowed
+		case "items":
+			result.items, err = compile(value, keywordPath)
+		case "minItems":
+			result.minItems, err = compileCount(value, keywordPath)
+		case "maxItems":
+			result.maxItems, err = compileCount(value, keywordPath)
+		case "minLength":
+			result.minLength, err = compileCount(value, keywordPath)
+		case "maxLength":
+			result.maxLength, err = compileCount(value, keywordPath)
+		case "pattern":
+			pattern, ok := value.(string)
+			if !ok {
+				return nil, errors.Errorf("%s must be a string", keywordPath)
+			}
+			result.pattern, err = regexp.Compile(pattern)
+		case "enum":
+			enum, ok := value.([]interface{})
+			if !ok || len(enum) == 0 {
+				return nil, errors.Errorf("%s must be a non-empty array", keywordPath)
+			}
+			result.enum = enum
+		case "const":
+			result.constValue = value
+			result.hasConst = true
+		case "allOf":
+			list, ok := value.([]interface{})
+			if !ok {
+				return nil, errors.Errorf("%s must be an array", keywordPath)
+			}
+			for index, item := range list {
+				subSchema, err := compile(item, fmt.Sprintf("%s/%d", keywordPath, index))
+				if err != nil {
+					return nil, err
+				}
+				result.allOf = append(result.allOf, subSchema)
+			}
+		case "if":
+			result.ifSchema, err = compile(value, keywordPath)
+		case "then":
+			result.thenSchema, err = compile(value, keywordPath)
+		default:
+			if !annotations[keyword] {
+				return nil, errors.Errorf("%s: keyword is not supported", keywordPath)
+			}
+		}
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if result.thenSchema != nil && result.ifSchema == nil {
+		return nil, errors.Errorf("%s: then is specified without if", path)
+	}
+	return result, nil
+}
+
+func compileStringList(value interface{}, path string) ([]string, error) {
+	if single, ok := value.(string); ok {
+		return []string{single}, nil
+	}
+
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("%s must be a string or an array of strings", path)
+	}
+
+	result := make([]string, len(list))
+	for index, item := range list {
+		result[index], ok = item.(string)
+		if !ok {
+			return nil, errors.Errorf("%s must be an array of strings", path)
+		}
+	}
+	return result, nil
+}
+
+func compileCount(value interface{}, path string) (int, error) {
+	number, ok := value.(float64)
+	if !ok || number < 0 || number != math.Trunc(number) {
+		return 0, errors.Errorf("%s must be a non-negative integer", path)
+	}
+	return int(number), nil
+}
+
+// ValidateJson decodes data and validates it. Malformed JSON is reported as a violation of the root value.
+func (t *Schema) ValidateJson(data []byte) []Violation {
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return []Violation{{Path: "", Message: "is not a valid JSON: " + err.Error()}}
+	}
+	return t.Validate(value)
+}
+
+// Validate returns all violations (nil if value is valid). Value is expected to be decoded by encoding/json into interface{}.
+func (t *Schema) Validate(value interface{}) []Violation {
+	var violations []Violation
+	t.validate(value, "", &violations)
+	return violations
+}
+
+func (t *Schema) validate(value interface{}, path string, violations *[]Violation) {
+	addViolation := func(format string, args ...interface{}) {
+		*violations = append(*violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(t.types) != 0 && !matchesAnyType(value, t.types) {
+		// other keywords are not checked - messages about wrong type value are not helpful
+		addViolation("must be %s", strings.Join(t.types, " or "))
+		return
+	}
+
+	if t.hasConst && !isEqual(value, t.constValue) {
+		addViolation("must be %s", formatValue(t.constValue))
+	}
+
+	if t.enum != nil && !containsValue(t.enum, value) {
+		values := make([]string, len(t.enum))
+		for index, item := range t.enum {
+			values[index] = formatValue(item)
+		}
+		addViolation("must be one of: %s", strings.Join(values, ", "))
+	}
+
+	switch typedValue := value.(type) {
+	case string:
+		length := len([]rune(typedValue))
+		if length < t.minLength {
+			addViolation("must be at least %d characters long", t.minLength)
+		}
+		if t.maxLength >= 0 && length > t.maxLength {
+			addViolation("must be at most %d characters long", t.maxLength)
+		}
+		if t.pattern != nil && !t.pattern.MatchString(typedValue) {
+			addViolation("must match pattern %s", t.pattern.String())
+		}
+
+	case []interface{}:
+		if len(typedValue) < t.minItems {
+			addViolation("must contain at least %d items", t.minItems)
+		}
+		if t.maxItems >= 0 && len(typedValue) > t.maxItems {
+			addViolation("must contain at most %d items", t.maxItems)
+		}
+		if t.items != nil {
+			for index, item := range typedValue {
+				t.items.validate(item, fmt.Sprintf("%s/%d", path, index), violations)
+			}
+		}
+
+	case map[string]interface{}:
+		for _, name := range t.required {
+			if _, found := typedValue[name]; !found {
+				*violations = append(*violations, Violation{Path: path + "/" + escapePointer(name), Message: "is required"})
+			}
+		}
+
+		for _, name := range getSortedKeys(typedValue) {
+			propertyPath := path + "/" + escapePointer(name)
+			property, found := t.properties[name]
+			if found {
+				property.validate(typedValue[name], propertyPath, violations)
+			} else if t.additionalProperties != nil && !*t.additionalProperties {
+				*violations = append(*violations, Violation{Path: propertyPath, Message: "is not allowed"})
+			}
+		}
+	}
+
+	for _, subSchema := range t.allOf {
+		subSchema.validate(value, path, violations)
+	}
+
+	if t.ifSchema != nil && t.thenSchema != nil && len(t.ifSchema.Validate(value)) == 0 {
+		t.thenSchema.validate(value, path, violations)
+	}
+}
+
+func matchesAnyType(value interface{}, types []string) bool {
+	for _, name := range types {
+		if matchesType(value, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesType(value interface{}, name string) bool {
+	switch typedValue := value.(type) {
+	case nil:
+		return name == "null"
+	case bool:
+		return name == "boolean"
+	case string:
+		return name == "string"
+	case float64:
+		return name == "number" || (name == "integer" && typedValue == math.Trunc(typedValue))
+	case []interface{}:
+		return name == "array"
+	case map[string]interface{}:
+		return name == "object"
+	default:
+		return false
+	}
+}
+
+// only scalar values are supported in enum and const
+func isEqual(a interface{}, b interface{}) bool {
+	switch a.(type) {
+	case []interface{}, map[string]interface{}:
+		return false
+	}
+	switch b.(type) {
+	case []interface{}, map[string]interface{}:
+		return false
+	}
+	return a == b
+}
+
+func containsValue(list []interface{}, value interface{}) bool {
+	for _, item := range list {
+		if isEqual(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatValue(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// https://tools.ietf.org/html/rfc6901#section-3
+func escapePointer(name string) string {
+	return strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1)
+}
+
+func getSortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,136 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+)
+
+const validBuildRequest = `{
+  "platform": "linux",
+  "targets": [{"name": "snap", "arch": "x64", "unpackedDirName": "linux-unpacked"}, {"name": "appImage", "arch": "x64", "unpackedDirName": "linux-unpacked"}],
+  "electronDownload": {"version": "8.2.0", "platform": "linux", "arch": "x64", "mirror": "https://example.com/"},
+  "executableName": "app"
+}`
+
+func TestValidBuildRequest(t *testing.T) {
+	violations := ValidateBuildRequest([]byte(validBuildRequest))
+	if violations != nil {
+		t.Errorf("request must be valid: %v", violations)
+	}
+
+	// electron is not unpacked, executable name is not required
+	violations = ValidateBuildRequest([]byte(`{"platform": "linux", "targets": [{"name": "deb", "arch": "arm64", "unpackedDirName": "linux-arm64-unpacked"}]}`))
+	if violations != nil {
+		t.Errorf("request must be valid: %v", violations)
+	}
+}
+
+func TestInvalidBuildRequest(t *testing.T) {
+	testCases := []struct {
+		name     string
+		request  string
+		expected []Violation
+	}{
+		{
+			name:    "empty targets",
+			request: `{"platform": "linux", "targets": []}`,
+			expected: []Violation{
+				{Path: "/targets", Message: "must contain at least 1 items"},
+			},
+		},
+		{
+			name:    "unknown platform",
+			request: `{"platform": "darwin", "targets": [{"name": "dmg", "arch": "x64", "unpackedDirName": "mac"}]}`,
+			expected: []Violation{
+				{Path: "/platform", Message: `must be one of: "linux"`},
+			},
+		},
+		{
+			name:    "every target violation is reported",
+			request: `{"platform": "linux", "targets": [{"name": "nsis", "arch": "mips", "unpackedDirName": "../linux-unpacked"}, {"name": "snap", "arch": "x64"}]}`,
+			expected: []Violation{
+				{Path: "/targets/0/arch", Message: `must be one of: "ia32", "x64", "armv7l", "arm64"`},
+				{Path: "/targets/0/unpackedDirName", Message: "must match pattern ^[A-Za-z0-9_][A-Za-z0-9._-]*$"},
+				{Path: "/targets/1/unpackedDirName", Message: "is required"},
+				{Path: "/targets/0/name", Message: `must be one of: "appImage", "snap", "deb", "rpm", "sh", "freebsd", "pacman", "apk", "p5p", "zip", "7z", "tar.xz", "tar.lz", "tar.gz", "tar.bz2", "dir"`},
+			},
+		},
+		{
+			name:    "executable name is required if electron is unpacked",
+			request: `{"platform": "linux", "targets": [{"name": "snap", "arch": "x64", "unpackedDirName": "linux-unpacked"}], "electronDownload": {"version": "8.2.0"}}`,
+			expected: []Violation{
+				{Path: "/executableName", Message: "is required"},
+			},
+		},
+		{
+			name:    "unsafe executable name and electron version",
+			request: `{"platform": "linux", "targets": [{"name": "snap", "arch": "x64", "unpackedDirName": "linux-unpacked"}], "electronDownload": {"version": "../8"}, "executableName": "../app"}`,
+			expected: []Violation{
+				{Path: "/electronDownload/version", Message: `must match pattern ^[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.-]+)?$`},
+				{Path: "/executableName", Message: `must match pattern ^[^/\\.][^/\\]*$`},
+			},
+		},
+		{
+			name:    "property names are case-sensitive",
+			request: `{"platform":"linux","targets":[{"name":"deb","arch":"x64","unpackedDirName":"linux-unpacked","UnpackedDirName":"../../etc"}]}`,
+			expected: []Violation{
+				{Path: "/targets/0/UnpackedDirName", Message: "is not allowed"},
+			},
+		},
+		{
+			name:    "client paths in electron download",
+			request: `{"platform": "linux", "targets": [{"name": "snap", "arch": "x64", "unpackedDirName": "linux-unpacked"}], "electronDownload": {"version": "8.2.0", "cache": "/etc", "customDir": "../..", "customFilename": "../../x.zip"}, "executableName": "app"}`,
+			expected: []Violation{
+				{Path: "/electronDownload/cache", Message: "is not allowed"},
+				{Path: "/electronDownload/customDir", Message: "is not allowed"},
+				{Path: "/electronDownload/customFilename", Message: "is not allowed"},
+			},
+		},
+		{
+			name:    "wrong types",
+			request: `{"platform": 1, "targets": {}}`,
+			expected: []Violation{
+				{Path: "/platform", Message: "must be string"},
+				{Path: "/targets", Message: "must be array"},
+			},
+		},
+		{
+			name:     "missing fields",
+			request:  `{}`,
+			expected: []Violation{{Path: "/platform", Message: "is required"}, {Path: "/targets", Message: "is required"}},
+		},
+		{
+			name:     "malformed JSON",
+			request:  `{"platform"`,
+			expected: []Violation{{Path: "", Message: "is not a valid JSON: unexpected end of JSON input"}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		violations := ValidateBuildRequest([]byte(testCase.request))
+		if !reflect.DeepEqual(violations, testCase.expected) {
+			t.Errorf("%s:\nexpected %v\nactual   %v", testCase.name, testCase.expected, violations)
+		}
+	}
+}
+
+func TestCompileRejectsUnsupportedKeyword(t *testing.T) {
+	_, err := Compile([]byte(`{"type": "object", "properties": {"name": {"type": "string", "format": "hostname"}}}`))
+	if err == nil {
+		t.Error("unsupported keyword must be rejected")
+	}
+
+	_, err = Compile([]byte(`{"then": {"required": ["name"]}}`))
+	if err == nil {
+		t.Error("then without if must be rejected")
+	}
+}
+
+func TestAdditionalProperties(t *testing.T) {
+	schema := MustCompile([]byte(`{"type": "object", "properties": {"a/b": {"type": "integer"}}, "additionalProperties": false}`))
+	violations := schema.ValidateJson([]byte(`{"a/b": 1.5, "c": true}`))
+	expected := []Violation{{Path: "/a~1b", Message: "must be integer"}, {Path: "/c", Message: "is not allowed"}}
+	if !reflect.DeepEqual(violations, expected) {
+		t.Errorf("expected %v, actual %v", expected, violations)
+	}
+}
@@ -0,0 +1,296 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/develar/errors"
+	"github.com/electronuserland/electron-build-service/internal/health"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const tlsCertFile = "/etc/secrets/tls.cert"
+const tlsKeyFile = "/etc/secrets/tls.key"
+
+// k8s updates mounted secrets in place (not immediately, sync period is about one minute)
+const tlsReloadInterval = 30 * time.Second
+
+const certificateExpiryWarningThreshold = 14 * 24 * time.Hour
+const certificateExpiryWarningInterval = 24 * time.Hour
+
+var certificateExpiryDays = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "tls_certificate_expiry_days",
+	Help: "Days until the server TLS certificate expires.",
+})
+
+func init() {
+	prometheus.MustRegister(certificateExpiryDays)
+}
+
+// certificateManager provides server certificate (tls.Config.GetCertificate) and client CA bundle, and reloads it if files are changed,
+// so, certificates can be rotated without restart (running builds are not aborted).
+// New certificates are validated before swap, current certificates are kept if new ones are invalid.
+type certificateManager struct {
+	certFile     string
+	keyFile      string
+	clientCaFile string
+
+	certificate *tls.Certificate
+	// nil if client authentication is not enabled
+	clientCas *x509.CertPool
+	// file -> modification time and size, to detect changes
+	fileStamps map[string]fileStamp
+	lock       sync.RWMutex
+
+	lastExpiryWarning time.Time
+
+	logger *zap.Logger
+}
+
+type fileStamp struct {
+	modification time.Time
+	size         int64
+}
+
+// clientCaFile: CA bundle (PEM) to verify client certificates, empty if client authentication is not enabled
+func newCertificateManager(clientCaFile string, logger *zap.Logger) (*certificateManager, error) {
+	manager := &certificateManager{
+		certFile:     tlsCertFile,
+		keyFile:      tlsKeyFile,
+		clientCaFile: clientCaFile,
+		fileStamps:   make(map[string]fileStamp),
+		logger:       logger.Named("tls"),
+	}
+
+	_, err := manager.reloadIfChanged(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return manager, nil
+}
+
+func (t *certificateManager) isClientAuthEnabled() bool {
+	return t.clientCaFile != ""
+}
+
+func (t *certificateManager) files() []string {
+	if t.isClientAuthEnabled() {
+		return []string{t.certFile, t.keyFile, t.clientCaFile}
+	}
+	return []string{t.certFile, t.keyFile}
+}
+
+// reloadIfChanged returns true if certificates are reloaded. On error current certificates are kept.
+func (t *certificateManager) reloadIfChanged(now time.Time) (bool, error) {
+	fileStamps := make(map[string]fileStamp)
+	isChanged := false
+	for _, file := range t.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+
+		stamp := fileStamp{modification: info.ModTime(), size: info.Size()}
+		fileStamps[file] = stamp
+		if t.fileStamps[file] != stamp {
+			isChanged = true
+		}
+	}
+
+	if !isChanged {
+		return false, nil
+	}
+
+	certificate, err := loadCertificate(t.certFile, t.keyFile, now)
+	if err != nil {
+		return false, err
+	}
+
+	var clientCas *x509.CertPool
+	if t.isClientAuthEnabled() {
+		clientCas, err = loadCertPool(t.clientCaFile)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	t.lock.Lock()
+	t.certificate = certificate
+	t.clientCas = clientCas
+	t.fileStamps = fileStamps
+	t.lock.Unlock()
+
+	t.logger.Info("certificate loaded",
+		zap.String("subject", certificate.Leaf.Subject.String()),
+		zap.Strings("dnsNames", certificate.Leaf.DNSNames),
+		zap.Time("notAfter", certificate.Leaf.NotAfter),
+	)
+	// warn immediately about new certificate
+	t.lastExpiryWarning = time.Time{}
+	return true, nil
+}
+
+// loadCertificate checks that key matches certificate and certificate is currently valid
+func loadCertificate(certFile string, keyFile string, now time.Time) (*tls.Certificate, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if now.Before(leaf.NotBefore) {
+		return nil, fmt.Errorf("certificate %s is not valid before %s", certFile, leaf.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(leaf.NotAfter) {
+		return nil, fmt.Errorf("certificate %s is expired at %s", certFile, leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	certificate.Leaf = leaf
+	return &certificate, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+// checkExpiry updates expiry metric and warns (not more often than once a day) if certificate expires soon
+func (t *certificateManager) checkExpiry(now time.Time) {
+	t.lock.RLock()
+	notAfter := t.certificate.Leaf.NotAfter
+	t.lock.RUnlock()
+
+	timeToExpiry := notAfter.Sub(now)
+	certificateExpiryDays.Set(timeToExpiry.Hours() / 24)
+
+	if timeToExpiry < certificateExpiryWarningThreshold && now.Sub(t.lastExpiryWarning) >= certificateExpiryWarningInterval {
+		t.lastExpiryWarning = now
+		t.logger.Warn("certificate expires soon", zap.Time("notAfter", notAfter), zap.Duration("timeToExpiry", timeToExpiry))
+	}
+}
+
+// watch checks files periodically until done is closed
+func (t *certificateManager) watch(done <-chan struct{}) {
+	t.checkExpiry(time.Now())
+
+	ticker := time.NewTicker(tlsReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			_, err := t.reloadIfChanged(now)
+			if err != nil {
+				t.logger.Error("cannot reload certificates, current certificates are kept", zap.Error(err))
+			}
+			t.checkExpiry(now)
+		}
+	}
+}
+
+func (t *certificateManager) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.certificate, nil
+}
+
+func (t *certificateManager) configureServer(config *tls.Config) {
+	config.GetCertificate = t.getCertificate
+	if !t.isClientAuthEnabled() {
+		return
+	}
+
+	// ClientCAs cannot be changed in the shared config, so, config is created per connection with the current CA pool
+	baseConfig := config.Clone()
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		t.lock.RLock()
+		clientCas := t.clientCas
+		t.lock.RUnlock()
+
+		result := baseConfig.Clone()
+		result.ClientCAs = clientCas
+		// not RequireAndVerifyClientCert because health checks (k8s probes) cannot present certificate, requireClientCertificate checks it for other requests
+		result.ClientAuth = tls.VerifyClientCertIfGiven
+		return result, nil
+	}
+}
+
+// requireClientCertificate rejects requests without verified client certificate (except health checks)
+func requireClientCertificate(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isHealthCheckPath(r.URL.Path) && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func isHealthCheckPath(path string) bool {
+	return path == healthCheckPath || path == health.LivezPath || path == health.ReadyzPath
+}
+
+// ForwardedClientIdentityHeader is set by router to identity of the client (see GetClientIdentity) if build is proxied.
+// Agent must trust it only if request is authenticated by the router certificate (any client can set header).
+const ForwardedClientIdentityHeader = "X-Forwarded-Client-Identity"
+
+// GetClientIdentity returns subject of verified client certificate (common name if set), empty if client is not authenticated by certificate.
+func GetClientIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	if subject.CommonName != "" {
+		return subject.CommonName
+	}
+	return subject.String()
+}
+
+// CreateAgentTlsConfig returns client TLS config to connect to agents (router proxies requests to agents).
+// Agent certificates are verified using agentCaFile (system roots if empty).
+// Server certificate is presented as client certificate if exists, because agents can require client certificates (see TlsClientCa).
+func CreateAgentTlsConfig(agentCaFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			// loaded on each handshake to use rotated certificate (connections are reused, so, handshakes are not frequent)
+			certificate, err := tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile)
+			if err != nil {
+				// no client certificate, agent decides whether it is required
+				return &tls.Certificate{}, nil
+			}
+			return &certificate, nil
+		},
+	}
+
+	if agentCaFile != "" {
+		pool, err := loadCertPool(agentCaFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
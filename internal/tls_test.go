@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func writeCertificate(t *testing.T, dir string, commonName string, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, filepath.Join(dir, "tls.cert"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, filepath.Join(dir, "tls.key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+}
+
+// modification time resolution may be not enough to detect change, so, every write gets a new modification time
+var fileVersion = 0
+
+func writeFile(t *testing.T, file string, data []byte) {
+	err := ioutil.WriteFile(file, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileVersion++
+	modification := time.Now().Add(time.Duration(fileVersion) * time.Second)
+	err = os.Chtimes(file, modification, modification)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertificateManagerReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manager := &certificateManager{
+		certFile:   filepath.Join(dir, "tls.cert"),
+		keyFile:    filepath.Join(dir, "tls.key"),
+		fileStamps: make(map[string]fileStamp),
+		logger:     zap.NewNop(),
+	}
+
+	assertCommonName := func(expected string) {
+		certificate, _ := manager.getCertificate(nil)
+		if certificate.Leaf.Subject.CommonName != expected {
+			t.Fatalf("expected %s, got %s", expected, certificate.Leaf.Subject.CommonName)
+		}
+	}
+
+	writeCertificate(t, dir, "first", time.Now().Add(24*time.Hour))
+	isReloaded, err := manager.reloadIfChanged(time.Now())
+	if err != nil || !isReloaded {
+		t.Fatalf("certificate must be loaded: %v", err)
+	}
+	assertCommonName("first")
+
+	isReloaded, err = manager.reloadIfChanged(time.Now())
+	if err != nil || isReloaded {
+		t.Fatalf("certificate must be not reloaded if files are not changed: %v", err)
+	}
+
+	// key doesn't match certificate
+	keyData, _ := ioutil.ReadFile(manager.keyFile)
+	writeCertificate(t, dir, "second", time.Now().Add(24*time.Hour))
+	writeFile(t, manager.keyFile, keyData)
+	_, err = manager.reloadIfChanged(time.Now())
+	if err == nil {
+		t.Fatal("mismatched key must be rejected")
+	}
+	assertCommonName("first")
+
+	writeCertificate(t, dir, "expired", time.Now().Add(-time.Minute))
+	_, err = manager.reloadIfChanged(time.Now())
+	if err == nil {
+		t.Fatal("expired certificate must be rejected")
+	}
+	assertCommonName("first")
+
+	writeCertificate(t, dir, "third", time.Now().Add(24*time.Hour))
+	isReloaded, err = manager.reloadIfChanged(time.Now())
+	if err != nil || !isReloaded {
+		t.Fatalf("certificate must be reloaded: %v", err)
+	}
+	assertCommonName("third")
+
+	manager.checkExpiry(time.Now())
+	if manager.lastExpiryWarning.IsZero() {
+		t.Fatal("expiry warning must be logged")
+	}
+}
@@ -0,0 +1,249 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/develar/errors"
+	"github.com/json-iterator/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// otlpHttpExporter sends spans using OTLP/HTTP with JSON encoding.
+// Official OTLP exporters depend on a newer gRPC than etcd client supports, and JSON encoding doesn't require protobuf.
+type otlpHttpExporter struct {
+	url    string
+	client *http.Client
+}
+
+func newOtlpHttpExporter(url string) *otlpHttpExporter {
+	return &otlpHttpExporter{
+		url:    url,
+		client: &http.Client{Timeout: shutdownTimeout},
+	}
+}
+
+func (t *otlpHttpExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	data, err := jsoniter.ConfigFastest.Marshal(encodeSpans(spans))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(data))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	response, err := t.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer response.Body.Close()
+	// read to allow connection reuse
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 4096))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("cannot export spans - status: %d, response: %s", response.StatusCode, body)
+	}
+	return nil
+}
+
+func (t *otlpHttpExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/trace/v1/trace.proto
+// trace and span ids are hex-encoded, 64-bit integers are strings
+type otlpTraceData struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource      `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId           string         `json:"traceId"`
+	SpanId            string         `json:"spanId"`
+	ParentSpanId      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// only one field is set
+type otlpAnyValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	IntValue    *string         `json:"intValue,omitempty"`
+	DoubleValue *float64        `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+func encodeSpans(spans []sdktrace.ReadOnlySpan) *otlpTraceData {
+	// all spans are produced by the same provider, so, resource is the same
+	resourceSpans := otlpResourceSpans{
+		Resource: otlpResource{Attributes: encodeAttributes(spans[0].Resource().Attributes())},
+	}
+
+	scopeToSpans := make(map[string]*otlpScopeSpans)
+	for _, span := range spans {
+		scope := span.InstrumentationScope()
+		scopeSpans := scopeToSpans[scope.Name]
+		if scopeSpans == nil {
+			scopeSpans = &otlpScopeSpans{Scope: otlpScope{Name: scope.Name, Version: scope.Version}}
+			scopeToSpans[scope.Name] = scopeSpans
+			resourceSpans.ScopeSpans = append(resourceSpans.ScopeSpans, scopeSpans)
+		}
+		scopeSpans.Spans = append(scopeSpans.Spans, encodeSpan(span))
+	}
+
+	return &otlpTraceData{ResourceSpans: []otlpResourceSpans{resourceSpans}}
+}
+
+func encodeSpan(span sdktrace.ReadOnlySpan) otlpSpan {
+	spanContext := span.SpanContext()
+	result := otlpSpan{
+		TraceId: spanContext.TraceID().String(),
+		SpanId:  spanContext.SpanID().String(),
+		Name:    span.Name(),
+		// OTLP span kind values are the same as SpanKind
+		Kind:              int(span.SpanKind()),
+		StartTimeUnixNano: strconv.FormatInt(span.StartTime().UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.EndTime().UnixNano(), 10),
+		Attributes:        encodeAttributes(span.Attributes()),
+	}
+
+	if span.Parent().IsValid() {
+		result.ParentSpanId = span.Parent().SpanID().String()
+	}
+
+	for _, event := range span.Events() {
+		result.Events = append(result.Events, otlpEvent{
+			TimeUnixNano: strconv.FormatInt(event.Time.UnixNano(), 10),
+			Name:         event.Name,
+			Attributes:   encodeAttributes(event.Attributes),
+		})
+	}
+
+	status := span.Status()
+	switch status.Code {
+	case codes.Ok:
+		result.Status.Code = 1
+	case codes.Error:
+		result.Status.Code = 2
+		result.Status.Message = status.Description
+	}
+	return result
+}
+
+func encodeAttributes(attributes []attribute.KeyValue) []otlpKeyValue {
+	result := make([]otlpKeyValue, 0, len(attributes))
+	for _, keyValue := range attributes {
+		result = append(result, otlpKeyValue{Key: string(keyValue.Key), Value: encodeValue(keyValue.Value)})
+	}
+	return result
+}
+
+func encodeValue(value attribute.Value) otlpAnyValue {
+	switch value.Type() {
+	case attribute.BOOL:
+		v := value.AsBool()
+		return otlpAnyValue{BoolValue: &v}
+	case attribute.INT64:
+		v := strconv.FormatInt(value.AsInt64(), 10)
+		return otlpAnyValue{IntValue: &v}
+	case attribute.FLOAT64:
+		v := value.AsFloat64()
+		return otlpAnyValue{DoubleValue: &v}
+	case attribute.STRING:
+		v := value.AsString()
+		return otlpAnyValue{StringValue: &v}
+	case attribute.BOOLSLICE:
+		var values []attribute.Value
+		for _, item := range value.AsBoolSlice() {
+			values = append(values, attribute.BoolValue(item))
+		}
+		return encodeArray(values)
+	case attribute.INT64SLICE:
+		var values []attribute.Value
+		for _, item := range value.AsInt64Slice() {
+			values = append(values, attribute.Int64Value(item))
+		}
+		return encodeArray(values)
+	case attribute.FLOAT64SLICE:
+		var values []attribute.Value
+		for _, item := range value.AsFloat64Slice() {
+			values = append(values, attribute.Float64Value(item))
+		}
+		return encodeArray(values)
+	case attribute.STRINGSLICE:
+		var values []attribute.Value
+		for _, item := range value.AsStringSlice() {
+			values = append(values, attribute.StringValue(item))
+		}
+		return encodeArray(values)
+	default:
+		v := value.Emit()
+		return otlpAnyValue{StringValue: &v}
+	}
+}
+
+func encodeArray(values []attribute.Value) otlpAnyValue {
+	result := make([]otlpAnyValue, len(values))
+	for index, value := range values {
+		result[index] = encodeValue(value)
+	}
+	return otlpAnyValue{ArrayValue: &otlpArrayValue{Values: result}}
+}
@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/json-iterator/go"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestOtlpHttpExporter(t *testing.T) {
+	var requests []otlpTraceData
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request: %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var data otlpTraceData
+		err = jsoniter.Unmarshal(body, &data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		requests = append(requests, data)
+	}))
+	defer server.Close()
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(newOtlpHttpExporter(server.URL + "/v1/traces")))
+	tracer := provider.Tracer("test")
+
+	ctx, parent := tracer.Start(context.Background(), "build")
+	_, child := tracer.Start(ctx, "upload")
+	child.SetAttributes(attribute.Int64("upload.size", 42), attribute.StringSlice("targets", []string{"snap"}))
+	EndSpan(child, errors.New("client closed connection"))
+	parent.End()
+
+	err := provider.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("expected request per span (syncer), got %d", len(requests))
+	}
+
+	childSpan := requests[0].ResourceSpans[0].ScopeSpans[0].Spans[0]
+	parentSpan := requests[1].ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if childSpan.Name != "upload" || parentSpan.Name != "build" {
+		t.Errorf("unexpected span names: %s, %s", childSpan.Name, parentSpan.Name)
+	}
+	if childSpan.TraceId != parentSpan.TraceId || childSpan.ParentSpanId != parentSpan.SpanId || parentSpan.ParentSpanId != "" {
+		t.Errorf("child span is not linked to parent: %+v, %+v", childSpan, parentSpan)
+	}
+	if childSpan.Status.Code != 2 || childSpan.Status.Message != "client closed connection" || parentSpan.Status.Code != 0 {
+		t.Errorf("unexpected status: %+v, %+v", childSpan.Status, parentSpan.Status)
+	}
+
+	attributes := make(map[string]otlpAnyValue)
+	for _, keyValue := range childSpan.Attributes {
+		attributes[keyValue.Key] = keyValue.Value
+	}
+	if value := attributes["upload.size"].IntValue; value == nil || *value != "42" {
+		t.Errorf("unexpected upload.size: %+v", attributes["upload.size"])
+	}
+	if value := attributes["targets"].ArrayValue; value == nil || len(value.Values) != 1 || *value.Values[0].StringValue != "snap" {
+		t.Errorf("unexpected targets: %+v", attributes["targets"])
+	}
+	if len(childSpan.Events) != 1 || childSpan.Events[0].Name != "exception" {
+		t.Errorf("error is not recorded: %+v", childSpan.Events)
+	}
+}
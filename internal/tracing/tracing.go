@@ -0,0 +1,123 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/develar/app-builder/pkg/util"
+	"github.com/develar/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const instrumentationName = "github.com/electronuserland/electron-build-service"
+
+const shutdownTimeout = 10 * time.Second
+
+// Configure configures global tracer provider, returned function flushes and stops exporter.
+//
+// OTEL_TRACES_EXPORTER: "none" (default), "otlp" or "file".
+// OTEL_EXPORTER_OTLP_ENDPOINT: OTLP/HTTP collector endpoint (default: http://localhost:4318), spans are sent to {endpoint}/v1/traces.
+// OTEL_TRACES_FILE: file for "file" exporter (spans are written as JSON, one span per line, to test tracing locally).
+//
+// W3C trace context is propagated regardless of exporter (so, trace of client is not broken).
+// These env vars are intentionally not part of config.Config - they follow OpenTelemetry conventions and are read once on start (not live-reloadable).
+func Configure(serviceName string, logger *zap.Logger) (func(), error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	exporterName := util.GetEnvOrDefault("OTEL_TRACES_EXPORTER", "none")
+	var exporter sdktrace.SpanExporter
+	switch exporterName {
+	case "none":
+		return func() {}, nil
+
+	case "otlp":
+		endpoint := util.GetEnvOrDefault("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+		exporter = newOtlpHttpExporter(endpoint + "/v1/traces")
+
+	case "file":
+		file, err := os.Create(util.GetEnvOrDefault("OTEL_TRACES_FILE", "traces.json"))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			util.Close(file)
+			return nil, errors.WithStack(err)
+		}
+		exporter = &closingExporter{SpanExporter: exporter, file: file}
+
+	default:
+		return nil, errors.Errorf("unknown traces exporter: %s", exporterName)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	logger.Info("tracing is enabled", zap.String("exporter", exporterName))
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		err := provider.Shutdown(ctx)
+		if err != nil {
+			logger.Error("cannot shutdown tracer provider", zap.Error(err))
+		}
+	}, nil
+}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// StartServerSpan starts span for incoming request, W3C trace context is extracted from request headers.
+func StartServerSpan(r *http.Request, name string) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("http.method", r.Method), attribute.String("http.target", r.URL.Path)),
+	)
+}
+
+// Inject propagates span context of ctx to headers of outgoing request (e.g. router proxies request to agent).
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// EndSpan records error (if not nil) and ends span
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// closingExporter closes output file on shutdown
+type closingExporter struct {
+	sdktrace.SpanExporter
+	file *os.File
+}
+
+func (t *closingExporter) Shutdown(ctx context.Context) error {
+	err := t.SpanExporter.Shutdown(ctx)
+	closeErr := t.file.Close()
+	if err != nil {
+		return err
+	}
+	return errors.WithStack(closeErr)
+}
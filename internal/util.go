@@ -8,24 +8,14 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
 	"time"
 
-	"github.com/develar/app-builder/pkg/util"
+	"github.com/electronuserland/electron-build-service/internal/health"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 )
 
-func GetListenPort(envName string) string {
-	port := os.Getenv(envName)
-	// k8s sets envs like BUILDER_SERVICE_PORT=tcp://10.43.216.215:443, so, to avoid issues, do not use such values
-	if port == "" || strings.HasPrefix(port, "tcp://") {
-		return "443"
-	} else {
-		return port
-	}
-}
-
 func createHttpServerOptions(port string) *http.Server {
 	return &http.Server{
 		Addr: ":" + port,
@@ -37,24 +27,63 @@ func createHttpServerOptions(port string) *http.Server {
 
 type BeforeServerShutdown func()
 
-func ListenAndServe(port string, logger *zap.Logger) *http.Server {
-	http.HandleFunc("/health-check", func(writer http.ResponseWriter, request *http.Request) {
+// legacy liveness check (see health.LivezPath)
+const healthCheckPath = "/health-check"
+const metricsPath = "/metrics"
+
+type ServerOptions struct {
+	Port   string
+	UseSsl bool
+	// CA bundle to verify client certificates, if set, every request (except health checks) must be authenticated by client certificate
+	ClientCaFile string
+	// /livez and /readyz are served if set
+	Health *health.Checker
+}
+
+func ListenAndServe(options ServerOptions, logger *zap.Logger) *http.Server {
+	http.HandleFunc(healthCheckPath, func(writer http.ResponseWriter, request *http.Request) {
 		writer.WriteHeader(200)
 	})
 
-	server := createHttpServerOptions(port)
+	http.Handle(metricsPath, promhttp.Handler())
+
+	if options.Health != nil {
+		options.Health.Register(http.DefaultServeMux)
+	}
+
+	server := createHttpServerOptions(options.Port)
+
+	if options.UseSsl {
+		certificateManager, err := newCertificateManager(options.ClientCaFile, logger)
+		if err != nil {
+			logger.Fatal("cannot load certificates", zap.Error(err))
+		}
+
+		certificateManager.configureServer(server.TLSConfig)
+		if certificateManager.isClientAuthEnabled() {
+			server.Handler = requireClientCertificate(http.DefaultServeMux)
+			logger.Info("client certificate authentication is enabled", zap.String("clientCa", certificateManager.clientCaFile))
+		}
+
+		done := make(chan struct{})
+		server.RegisterOnShutdown(func() {
+			close(done)
+		})
+		go certificateManager.watch(done)
+	}
 
 	go func() {
 		var err error
-		if os.Getenv("USE_SSL") == "false" {
-			err = server.ListenAndServe()
+		if options.UseSsl {
+			// certificate is provided by GetCertificate
+			err = server.ListenAndServeTLS("", "")
 		} else {
-			err = server.ListenAndServeTLS("/etc/secrets/tls.cert", "/etc/secrets/tls.key")
+			err = server.ListenAndServe()
 		}
 		if err == http.ErrServerClosed {
 			logger.Debug("server closed")
 		} else {
-			logger.Fatal("cannot serve", zap.Error(err), zap.String("port", port))
+			logger.Fatal("cannot serve", zap.Error(err), zap.String("port", options.Port))
 		}
 	}()
 
@@ -91,9 +120,10 @@ func shutdownHttpServer(server *http.Server, shutdownTimeout time.Duration, logg
 	logger.Info("server is shutdown", zap.Duration("duration", time.Since(start)))
 }
 
-func CreateLogger() *zap.Logger {
+// encoding: console or json
+func CreateLogger(encoding string) *zap.Logger {
 	config := zap.NewDevelopmentConfig()
-	config.Encoding = util.GetEnvOrDefault("LOG_ENCODING", "console")
+	config.Encoding = encoding
 	config.DisableCaller = true
 	logger, err := config.Build()
 	if err != nil {